@@ -6,13 +6,23 @@
 package app
 
 import (
+	"fmt"
 	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/category"
+	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/categoryKey"
+	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/datastore"
 	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/images"
 	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/localFileStructure"
+	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/metadata"
 	"github.com/sirupsen/logrus"
 	"os"
+	"sort"
+	"strings"
+	"time"
 )
 
+// Number of nodes a stage of the local synchronization may be ahead of the next one.
+const nodeBufferSize = 128
+
 func Run() {
 	initializeFlags()
 	initializeLog()
@@ -22,42 +32,278 @@ func Run() {
 		logErrorAndExit(err, 1)
 	}
 
-	err = context.piwigo.Login()
-	if err != nil {
-		logErrorAndExit(err, 2)
+	if *previewAlbums {
+		err = printAlbumPreview(context.roots)
+		if err != nil {
+			logErrorAndExit(err, 3)
+		}
+		return
 	}
 
-	filesystemNodes, err := localFileStructure.ScanLocalFileStructure(context.localRootPath, extensions, ignoreDirs, *dirSuffixToSkip)
-	if err != nil {
-		logErrorAndExit(err, 3)
+	if *verifyChecksums {
+		verifyLocalChecksums(context)
+		return
+	}
+
+	for _, target := range context.targets {
+		logrus.Infof("Logging in to piwigo target %s", target.name)
+		err = target.piwigo.Login()
+		if err != nil {
+			logErrorAndExit(err, 2)
+		}
+	}
+
+	if *watchMode {
+		err = watchRoots(context)
+		if err != nil {
+			logErrorAndExit(err, 12)
+		}
+		return
+	}
+
+	synchronize(context, nil)
+
+	for _, target := range context.targets {
+		_ = target.piwigo.Logout()
+	}
+}
+
+// Synchronizes the local files with all targets. Without paths, all local files are scanned and the images that
+// are gone get marked for deletion. Otherwise only the given files and directories are scanned. The files that are
+// still being written are returned as pending.
+func synchronize(context *appContext, paths []string) []string {
+	// the categories created by earlier runs are known already, only a complete run picks up changes on piwigo
+	if len(paths) == 0 {
+		for _, target := range context.targets {
+			logrus.Infof("Loading categories of piwigo target %s", target.name)
+			err := category.UpdateCategoriesFromServer(target.piwigo, target.dataStore)
+			if err != nil {
+				logErrorAndExit(err, 4)
+			}
+		}
+	}
+
+	pending := synchronizeLocalFiles(context, paths)
+	if len(paths) == 0 && *scrubSize > 0 {
+		scrubLocalFiles(context)
+	}
+
+	for _, target := range context.targets {
+		synchronizeTarget(target)
+	}
+
+	printRunSummary(pending)
+	return pending
+}
+
+// The scan, the category creation and the change detection run at the same time. Every directory reaches the
+// category stage before its files, so the albums exist on all targets before the files get assigned to them.
+// A scan limited to some paths does not see the other files, so it neither marks removed images nor uses the
+// directory cache.
+func synchronizeLocalFiles(context *appContext, paths []string) []string {
+	scanId := time.Now().UnixNano()
+	complete := len(paths) == 0
+
+	roots := context.roots
+	cacheUsed, fullScan := false, false
+	var err error
+	if complete {
+		cacheUsed, fullScan, err = useDirectoryCacheForScan(context, scanId)
+		if err != nil {
+			logErrorAndExit(err, 3)
+		}
+	} else {
+		roots = limitRootsToPaths(context.roots, paths)
+	}
+
+	nodes := make(chan *localFileStructure.FilesystemNode, nodeBufferSize)
+	files := make(chan *localFileStructure.FilesystemNode, nodeBufferSize)
+	done := make(chan struct{})
+
+	scanResult := make(chan error, 1)
+	go func() {
+		scanResult <- localFileStructure.StreamLocalFileStructures(roots, *dirSuffixToSkip, nodes, done)
+	}()
+
+	categoryResult := make(chan error, 1)
+	go func() {
+		categoryResult <- synchronizeCategories(context.targets, nodes, files, done)
+	}()
+
+	metadataTargets := make([]images.MetadataTarget, 0, len(context.targets))
+	for _, target := range context.targets {
+		metadataTargets = append(metadataTargets, images.MetadataTarget{ImageDb: target.dataStore, CategoryDb: target.dataStore})
 	}
 
-	err = category.SynchronizeCategories(filesystemNodes, context.piwigo, context.dataStore)
+	checksumCalculator := context.checksumCalculator
+	if *validateImages {
+		checksumCalculator = localFileStructure.ValidatedFileCheckSums(checksumCalculator)
+	}
+	checksumCalculator = localFileStructure.StableFileCheckSums(*quietPeriod, checksumCalculator)
+	var metadataReader func(filePath string, sidecarPath string) (metadata.Metadata, error)
+	if *readMetadata {
+		metadataReader = metadata.Read
+	}
+	pending, metadataErr := images.SynchronizeLocalImageMetadata(metadataTargets, files, context.hashWorkers, scanId, *changeDetection, checksumCalculator, metadataReader)
+
+	// a failed category stage cancels the scan, so its error is the cause
+	err = <-categoryResult
 	if err != nil {
 		logErrorAndExit(err, 4)
 	}
+	err = <-scanResult
+	if err != nil {
+		logErrorAndExit(err, 3)
+	}
+	if metadataErr != nil {
+		logErrorAndExit(metadataErr, 5)
+	}
+
+	if !complete {
+		return pending
+	}
 
-	err = images.SynchronizeLocalImageMetadata(context.dataStore, context.dataStore, filesystemNodes, localFileStructure.CalculateFileCheckSums)
+	for _, target := range context.targets {
+		err = images.MarkRemovedImages(target.dataStore, scanId)
+		if err != nil {
+			logErrorAndExit(err, 5)
+		}
+	}
+
+	if *skipEmptyAlbums {
+		for _, target := range context.targets {
+			err = category.MarkEmptyCategories(target.dataStore)
+			if err != nil {
+				logErrorAndExit(err, 5)
+			}
+		}
+	}
+
+	if cacheUsed {
+		err = context.dataStore.SaveCompletedScan(datastore.ScanData{ScanId: scanId, FullScan: fullScan, Completed: time.Now()})
+		if err != nil {
+			logErrorAndExit(err, 5)
+		}
+	}
+	return pending
+}
+
+// Hands the directory cache to the roots if it is enabled and decides if all directories have to be listed again.
+func useDirectoryCacheForScan(context *appContext, scanId int64) (bool, bool, error) {
+	if !*useDirectoryCache || context.dataStore == nil || *followSymlinks {
+		return false, false, nil
+	}
+
+	full, err := isFullScanRequired(context.dataStore, *fullScan, *fullScanInterval)
 	if err != nil {
-		logErrorAndExit(err, 5)
+		return false, false, err
+	}
+
+	cache := &directoryCache{db: context.dataStore, scanId: scanId}
+	for i := range context.roots {
+		context.roots[i].Cache = cache
+		context.roots[i].FullScan = full
+	}
+	return true, full, nil
+}
+
+// Creates the category of every directory on all targets and passes the files on. With skipEmptyAlbums, the
+// directories are held back until a file within them is found that gets uploaded. On errors, the scan gets cancelled
+// and the remaining nodes are dropped.
+func synchronizeCategories(targets []*targetContext, nodes <-chan *localFileStructure.FilesystemNode, files chan<- *localFileStructure.FilesystemNode, done chan<- struct{}) error {
+	defer close(files)
+
+	heldDirectories := make(map[string]*localFileStructure.FilesystemNode)
+	for node := range nodes {
+		var err error
+		if node.IsDir && *skipEmptyAlbums {
+			heldDirectories[node.Key] = node
+		} else if node.IsDir {
+			err = synchronizeCategory(targets, node)
+		} else if *skipEmptyAlbums && !node.Settings.SkipUpload {
+			err = synchronizeHeldCategories(targets, heldDirectories, categoryKey.Parent(node.Key))
+		}
+
+		if err != nil {
+			close(done)
+			for range nodes {
+			}
+			return err
+		}
+
+		if !node.IsDir {
+			files <- node
+		}
+	}
+	return nil
+}
+
+func synchronizeCategory(targets []*targetContext, directory *localFileStructure.FilesystemNode) error {
+	for _, target := range targets {
+		err := category.SynchronizeCategory(directory, target.piwigo, target.dataStore)
+		if err != nil {
+			return err
+		}
 	}
+	return nil
+}
+
+// Creates the held back album with the given key and its held back parents, parents first.
+func synchronizeHeldCategories(targets []*targetContext, heldDirectories map[string]*localFileStructure.FilesystemNode, key string) error {
+	var directories []*localFileStructure.FilesystemNode
+	for ; key != ""; key = categoryKey.Parent(key) {
+		directory, held := heldDirectories[key]
+		if !held {
+			// the parents of an album that is not held back are created already
+			break
+		}
+		directories = append([]*localFileStructure.FilesystemNode{directory}, directories...)
+		delete(heldDirectories, key)
+	}
+
+	for _, directory := range directories {
+		err := synchronizeCategory(targets, directory)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	err = images.SynchronizePiwigoMetadata(context.piwigo, context.dataStore)
+func synchronizeTarget(target *targetContext) {
+	logrus.Infof("Synchronizing images of piwigo target %s", target.name)
+
+	err := images.SynchronizePiwigoMetadata(target.piwigo, target.dataStore)
 	if err != nil {
 		logErrorAndExit(err, 6)
 	}
 
+	if *adoptExisting {
+		options := images.AdoptOptions{MatchSize: *adoptMatchSize, MatchDate: *adoptMatchDate}
+		err = images.AdoptExistingImages(target.piwigo, target.dataStore, options)
+		if err != nil {
+			logErrorAndExit(err, 9)
+		}
+	}
+
 	if *removeImages {
-		err = images.DeleteImages(context.piwigo, context.dataStore)
+		err = images.DeleteImages(target.piwigo, target.dataStore)
 		if err != nil {
 			logErrorAndExit(err, 7)
 		}
+
+		if *skipEmptyAlbums {
+			err = category.DeleteEmptyCategories(target.piwigo, target.dataStore)
+			if err != nil {
+				logErrorAndExit(err, 7)
+			}
+		}
 	} else {
 		logrus.Info("The flag removeImages is disabled. Skipping...")
 	}
 
 	if !(*noUpload) {
-		err = images.UploadImages(context.piwigo, context.dataStore, *parallelUploads)
+		err = images.UploadImages(target.piwigo, target.dataStore, *parallelUploads)
 		if err != nil {
 			logErrorAndExit(err, 8)
 		}
@@ -65,7 +311,116 @@ func Run() {
 		logrus.Warnln("Skipping upload of images as flag noUpload is set to true!")
 	}
 
-	_ = context.piwigo.Logout()
+	err = images.UpdateImageInfo(target.piwigo, target.dataStore)
+	if err != nil {
+		logErrorAndExit(err, 10)
+	}
+
+	err = category.UpdateCategorySettings(target.piwigo, target.dataStore, target.dataStore)
+	if err != nil {
+		logErrorAndExit(err, 11)
+	}
+}
+
+// Prints the album every local directory ends up in, so the rewrite rules can be checked before uploading.
+func printAlbumPreview(roots []localFileStructure.ScanRoot) error {
+	nodes := make(chan *localFileStructure.FilesystemNode, nodeBufferSize)
+	scanResult := make(chan error, 1)
+	go func() {
+		scanResult <- localFileStructure.StreamLocalFileStructures(roots, *dirSuffixToSkip, nodes, nil)
+	}()
+
+	var lines []string
+	for node := range nodes {
+		if node.IsDir {
+			lines = append(lines, fmt.Sprintf("%s -> %s", node.Path, strings.Join(categoryKey.Split(node.Key), " / ")))
+		}
+	}
+
+	err := <-scanResult
+	if err != nil {
+		return err
+	}
+
+	sort.Strings(lines)
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+	return nil
+}
+
+// Hashes the local files and compares them with the stored checksums without touching the database or piwigo. The
+// application exits with code 13 if any file does not match.
+func verifyLocalChecksums(context *appContext) {
+	nodes := make(chan *localFileStructure.FilesystemNode, nodeBufferSize)
+	done := make(chan struct{})
+
+	scanResult := make(chan error, 1)
+	go func() {
+		scanResult <- localFileStructure.StreamLocalFileStructures(context.roots, *dirSuffixToSkip, nodes, done)
+	}()
+
+	metadataTargets := make([]images.MetadataTarget, 0, len(context.targets))
+	for _, target := range context.targets {
+		metadataTargets = append(metadataTargets, images.MetadataTarget{ImageDb: target.dataStore, CategoryDb: target.dataStore})
+	}
+
+	checksumCalculator := localFileStructure.StableFileCheckSums(*quietPeriod, context.checksumCalculator)
+	mismatches, verified, err := images.VerifyChecksums(metadataTargets, nodes, context.hashWorkers, checksumCalculator)
+	if err != nil {
+		logErrorAndExit(err, 5)
+	}
+	err = <-scanResult
+	if err != nil {
+		logErrorAndExit(err, 3)
+	}
+
+	if len(mismatches) == 0 {
+		logrus.Infof("Verified %d files, all checksums match", verified)
+		return
+	}
+
+	logrus.Warnf("Verified %d files, %d of them do not match the stored checksum", verified, len(mismatches))
+	for _, mismatch := range mismatches {
+		if mismatch.Changed {
+			logrus.Warnf("Mismatch: %s (changed since the last synchronization)", mismatch.Path)
+		} else {
+			logrus.Warnf("Mismatch: %s (unchanged fingerprint, possibly corrupted)", mismatch.Path)
+		}
+	}
+	os.Exit(13)
+}
+
+// Runs after the change detection, so only the files that are not modified since they were hashed are compared and
+// the corrupted ones are flagged before the upload.
+func scrubLocalFiles(context *appContext) {
+	metadataTargets := make([]images.MetadataTarget, 0, len(context.targets))
+	for _, target := range context.targets {
+		metadataTargets = append(metadataTargets, images.MetadataTarget{ImageDb: target.dataStore, CategoryDb: target.dataStore})
+	}
+
+	checksumCalculator := localFileStructure.StableFileCheckSums(*quietPeriod, context.checksumCalculator)
+	mismatches, _, err := images.ScrubChecksums(metadataTargets, int64(*scrubSize)*1024*1024*1024, checksumCalculator)
+	if err != nil {
+		logErrorAndExit(err, 14)
+	}
+
+	for _, mismatch := range mismatches {
+		logrus.Warnf("Possibly corrupted: %s", mismatch.Path)
+	}
+}
+
+// Lists the files that were skipped as they are still being written, so they do not go unnoticed until the next run.
+func printRunSummary(pending []string) {
+	if len(pending) == 0 {
+		logrus.Info("Run summary: no pending files")
+		return
+	}
+
+	logrus.Warnf("Run summary: %d files are pending as they are still being written, they are checked again on the next run", len(pending))
+	for _, path := range pending {
+		logrus.Warnf("Pending: %s", path)
+	}
 }
 
 func initializeLog() {
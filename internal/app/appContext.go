@@ -7,17 +7,35 @@ package app
 
 import (
 	"errors"
+	"fmt"
+	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/categoryKey"
 	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/datastore"
+	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/images"
+	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/localFileStructure"
 	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/piwigo"
 	"github.com/sirupsen/logrus"
+	"runtime"
+	"strconv"
+	"strings"
 )
 
 type appContext struct {
 	// think again if this is a good idea to have such a context!
-	piwigo        *piwigo.ServerContext
-	dataStore     *datastore.LocalDataStore
-	sessionId     string
-	localRootPath string
+	targets   []*targetContext
+	dataStore *datastore.LocalDataStore
+	sessionId string
+	roots     []localFileStructure.ScanRoot
+	// calculates the md5 sum and the configured strong hash of the files
+	checksumCalculator func(filePath string) (localFileStructure.FileChecksums, error)
+	// the number of files hashed in parallel, which is also the number of workers checking the files
+	hashWorkers int
+}
+
+// Every piwigo server we mirror the local files to has its own session and its own view on the data store.
+type targetContext struct {
+	name      string
+	piwigo    *piwigo.ServerContext
+	dataStore *datastore.LocalDataStore
 }
 
 func (c *appContext) useMetadataStore(connectionString string) error {
@@ -32,7 +50,11 @@ func (c *appContext) useMetadataStore(connectionString string) error {
 	return err
 }
 
-func (c *appContext) usePiwigo(url string, user string, password string) error {
+func (c *appContext) usePiwigo(name string, url string, user string, password string) error {
+	if name == "" {
+		return errors.New("missing piwigo target name")
+	}
+
 	if url == "" {
 		return errors.New("missing piwigo url")
 	}
@@ -45,15 +67,115 @@ func (c *appContext) usePiwigo(url string, user string, password string) error {
 		return errors.New("missing piwigo password")
 	}
 
-	c.piwigo = new(piwigo.ServerContext)
-	return c.piwigo.Initialize(url, user, password)
+	for _, target := range c.targets {
+		if target.name == name {
+			return fmt.Errorf("the piwigo target %s is configured more than once", name)
+		}
+	}
+
+	target := &targetContext{
+		name:   name,
+		piwigo: new(piwigo.ServerContext),
+	}
+	if c.dataStore != nil {
+		target.dataStore = c.dataStore.ForTarget(name)
+	}
+
+	c.targets = append(c.targets, target)
+	return target.piwigo.Initialize(url, user, password)
+}
+
+// The files are only throttled if any of the hash flags is set, otherwise every worker hashes on its own.
+func newReadThrottle(workers int) (*localFileStructure.ReadThrottle, error) {
+	if *maxReadRate < 0 {
+		return nil, fmt.Errorf("invalid maxReadRate %d. Expected zero or a positive number", *maxReadRate)
+	}
+	if *hashWorkers == 0 && !*adaptiveHashing && *maxReadRate == 0 {
+		return nil, nil
+	}
+
+	return localFileStructure.NewReadThrottle(workers, *adaptiveHashing, int64(*maxReadRate)*1024*1024), nil
 }
 
 func newAppContext() (*appContext, error) {
 	logrus.Infoln("Preparing application context and configuration")
 
 	context := new(appContext)
-	context.localRootPath = *imagesRootPath
+
+	roots, err := parseScanRoots(*imagesRootPath, rootDefinitions)
+	if err != nil {
+		return nil, err
+	}
+
+	rules, err := parseRules(ruleDefinitions)
+	if err != nil {
+		return nil, err
+	}
+	rewrites, err := parseRewriteRules(categoryRewrites)
+	if err != nil {
+		return nil, err
+	}
+	var layout *localFileStructure.DateLayout
+	if *dateLayout != "" {
+		layout, err = localFileStructure.ParseDateLayout(*dateLayout)
+		if err != nil {
+			return nil, err
+		}
+		if len(rewrites) > 0 || *dirSuffixToSkip > 0 || *maxAlbumDepth > 0 {
+			logrus.Warnln("The flags categoryRewrite, dirSuffixToSkip and maxAlbumDepth have no effect if dateLayout is set")
+		}
+	}
+	var variants *localFileStructure.VariantPreference
+	if *variantPreference != "" {
+		variants, err = localFileStructure.ParseVariantPreference(*variantPreference)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if *maxAlbumDepth < 0 {
+		return nil, fmt.Errorf("invalid maxAlbumDepth %d. Expected zero or a positive number", *maxAlbumDepth)
+	}
+	if *symlinkKeys != localFileStructure.SymlinkKeysLink && *symlinkKeys != localFileStructure.SymlinkKeysResolved {
+		return nil, fmt.Errorf("unknown value for symlinkKeys: %s", *symlinkKeys)
+	}
+	if *changeDetection != images.ChangeDetectionModTime && *changeDetection != images.ChangeDetectionFingerprint && *changeDetection != images.ChangeDetectionHash {
+		return nil, fmt.Errorf("unknown value for changeDetection: %s", *changeDetection)
+	}
+
+	for i := range roots {
+		roots[i].Rules = rules
+		roots[i].FollowSymlinks = *followSymlinks
+		roots[i].SymlinkKeys = *symlinkKeys
+		roots[i].Rewrites = rewrites
+		roots[i].DateLayout = layout
+		roots[i].Variants = variants
+		roots[i].Sidecars = *readMetadata
+		roots[i].MaxAlbumDepth = *maxAlbumDepth
+		roots[i].JoinCollapsedAlbums = *joinCollapsedAlbums
+	}
+	context.roots = roots
+
+	if *hashWorkers < 0 {
+		return nil, fmt.Errorf("invalid hashWorkers %d. Expected zero or a positive number", *hashWorkers)
+	}
+	context.hashWorkers = *hashWorkers
+	if context.hashWorkers == 0 {
+		context.hashWorkers = runtime.NumCPU()
+	}
+
+	throttle, err := newReadThrottle(context.hashWorkers)
+	if err != nil {
+		return nil, err
+	}
+	context.checksumCalculator, err = localFileStructure.NewChecksumCalculator(*strongHash, throttle)
+	if err != nil {
+		return nil, err
+	}
+
+	// the preview only scans the local directories, so neither the database nor the piwigo targets are required
+	if *previewAlbums {
+		return context, nil
+	}
 
 	if *sqliteDb != "" {
 		err := context.useMetadataStore(*sqliteDb)
@@ -64,7 +186,204 @@ func newAppContext() (*appContext, error) {
 		logrus.Warnln("No persistence configured. Skipping metadata storage. This might affect performance on large collections!")
 	}
 
-	err := context.usePiwigo(*piwigoUrl, *piwigoUser, *piwigoPassword)
+	if *useDirectoryCache && context.dataStore == nil {
+		logrus.Warnln("The directoryCache requires the sqliteDb and is disabled")
+	} else if *useDirectoryCache && *followSymlinks {
+		logrus.Warnln("The directoryCache is not used if followSymlinks is set")
+	}
+
+	if *verifyChecksums && context.dataStore == nil {
+		return nil, errors.New("verifyChecksums requires the sqliteDb")
+	}
+	if *scrubSize < 0 {
+		return nil, fmt.Errorf("invalid scrubSize %d. Expected zero or a positive number", *scrubSize)
+	}
+	if *scrubSize > 0 && context.dataStore == nil {
+		return nil, errors.New("scrubSize requires the sqliteDb")
+	}
+
+	if *watchMode && *followSymlinks {
+		logrus.Warnln("Changes behind followed symlinks are not watched and only found by the reconcileInterval")
+	}
+
+	if *piwigoUrl != "" || len(piwigoTargets) == 0 {
+		err := context.usePiwigo(datastore.DefaultTarget, *piwigoUrl, *piwigoUser, *piwigoPassword)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, targetDefinition := range piwigoTargets {
+		// the password is the last part so it may contain the separator as well
+		parts := strings.SplitN(targetDefinition, "|", 4)
+		if len(parts) != 4 {
+			return nil, fmt.Errorf("invalid piwigo target definition for '%s'. Expected name|url|user|password", parts[0])
+		}
+
+		err := context.usePiwigo(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), strings.TrimSpace(parts[2]), parts[3])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	bindings, err := parseCategoryBindings(categoryBindings)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, target := range context.targets {
+		err = target.piwigo.UseDuplicateCategoriesPolicy(*duplicateCategories, bindings[target.name])
+		if err != nil {
+			return nil, err
+		}
+		delete(bindings, target.name)
+	}
+
+	for name := range bindings {
+		return nil, fmt.Errorf("found category bindings for the unknown piwigo target %s", name)
+	}
+
+	bases, err := parseBaseCategories(baseCategories)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, target := range context.targets {
+		base, ok := bases[target.name]
+		if !ok {
+			continue
+		}
+		err = target.piwigo.UseBaseCategory(base)
+		if err != nil {
+			return nil, err
+		}
+		delete(bases, target.name)
+	}
+
+	for name := range bases {
+		return nil, fmt.Errorf("found a base category for the unknown piwigo target %s", name)
+	}
+
+	return context, nil
+}
+
+// Parses the category bindings grouped by the name of the target. The directory uses slashes to separate
+// the directory levels and is converted into the category key.
+func parseCategoryBindings(definitions []string) (map[string]map[string]int, error) {
+	bindings := make(map[string]map[string]int)
+	for _, definition := range definitions {
+		parts := strings.Split(definition, "|")
+		if len(parts) == 2 {
+			parts = append([]string{datastore.DefaultTarget}, parts...)
+		}
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid category binding '%s'. Expected [target|]directory|id", definition)
+		}
+
+		id, err := strconv.Atoi(strings.TrimSpace(parts[2]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid category id in binding '%s': %s", definition, err)
+		}
+
+		target := strings.TrimSpace(parts[0])
+		if bindings[target] == nil {
+			bindings[target] = make(map[string]int)
+		}
+		key := categoryKey.Join(strings.Split(strings.Trim(parts[1], "/"), "/")...)
+		bindings[target][key] = id
+	}
+	return bindings, nil
+}
+
+// Parses the base category of each target. The base category is either the id or the path of the category.
+func parseBaseCategories(definitions []string) (map[string]string, error) {
+	bases := make(map[string]string)
+	for _, definition := range definitions {
+		parts := strings.SplitN(definition, "|", 2)
+		if len(parts) == 1 {
+			parts = append([]string{datastore.DefaultTarget}, parts...)
+		}
 
-	return context, err
+		target := strings.TrimSpace(parts[0])
+		if _, exists := bases[target]; exists {
+			return nil, fmt.Errorf("the piwigo target %s has more than one base category", target)
+		}
+		bases[target] = strings.TrimSpace(parts[1])
+	}
+	return bases, nil
+}
+
+// Parses the local roots to scan. The imagesRootPath is mirrored to the gallery root and uses the global extensions
+// and ignored directories. Additional roots use the format path|albumPrefix|extensions|ignoreDirs where the lists
+// are separated by commas. Omitted lists fall back to the global ones.
+func parseScanRoots(imagesRootPath string, definitions []string) ([]localFileStructure.ScanRoot, error) {
+	var roots []localFileStructure.ScanRoot
+	if imagesRootPath != "" {
+		roots = append(roots, localFileStructure.ScanRoot{Path: imagesRootPath, Extensions: extensions, IgnoreDirs: ignoreDirs})
+	}
+
+	for _, definition := range definitions {
+		parts := strings.Split(definition, "|")
+		if len(parts) > 4 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid root definition '%s'. Expected path[|albumPrefix[|extensions[|ignoreDirs]]]", definition)
+		}
+		for len(parts) < 4 {
+			parts = append(parts, "")
+		}
+
+		root := localFileStructure.ScanRoot{
+			Path:       strings.TrimSpace(parts[0]),
+			Extensions: splitList(parts[2], extensions),
+			IgnoreDirs: splitList(parts[3], ignoreDirs),
+		}
+		prefix := strings.Trim(strings.TrimSpace(parts[1]), "/")
+		if prefix != "" {
+			root.AlbumPrefix = categoryKey.Join(strings.Split(prefix, "/")...)
+		}
+		roots = append(roots, root)
+	}
+
+	if len(roots) == 0 {
+		return nil, errors.New("missing images root path. Please configure imagesRootPath or at least one root")
+	}
+	return roots, nil
+}
+
+func parseRules(definitions []string) ([]localFileStructure.Rule, error) {
+	rules := make([]localFileStructure.Rule, 0, len(definitions))
+	for _, definition := range definitions {
+		rule, err := localFileStructure.ParseRule(definition)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func parseRewriteRules(definitions []string) ([]localFileStructure.RewriteRule, error) {
+	rewrites := make([]localFileStructure.RewriteRule, 0, len(definitions))
+	for _, definition := range definitions {
+		rewrite, err := localFileStructure.ParseRewriteRule(definition)
+		if err != nil {
+			return nil, err
+		}
+		rewrites = append(rewrites, rewrite)
+	}
+	return rewrites, nil
+}
+
+func splitList(list string, fallback []string) []string {
+	if strings.TrimSpace(list) == "" {
+		return fallback
+	}
+
+	var values []string
+	for _, value := range strings.Split(list, ",") {
+		value = strings.TrimSpace(value)
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
 }
@@ -0,0 +1,77 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package app
+
+import (
+	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/datastore"
+	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/localFileStructure"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// Stores the directory listings of the scanner in the local database. The listings do not belong to a piwigo target
+// as all targets share the same local files.
+type directoryCache struct {
+	db     datastore.DirectoryProvider
+	scanId int64
+}
+
+func (c *directoryCache) Entries(path string, modTime time.Time) ([]localFileStructure.DirectoryEntry, bool) {
+	directory, err := c.db.DirectoryData(path)
+	if err == datastore.ErrorRecordNotFound {
+		return nil, false
+	}
+	if err != nil {
+		logrus.Warnf("Could not load the cached entries of %s - %s", path, err)
+		return nil, false
+	}
+	if !directory.ModTime.Equal(modTime) {
+		return nil, false
+	}
+
+	entries := make([]localFileStructure.DirectoryEntry, 0, len(directory.Entries))
+	for _, entry := range directory.Entries {
+		entries = append(entries, localFileStructure.DirectoryEntry{Name: entry.Name, IsDir: entry.IsDir, Size: entry.Size, ModTime: entry.ModTime, FileId: entry.FileId, ChangeTime: entry.ChangeTime})
+	}
+	return entries, true
+}
+
+func (c *directoryCache) SaveEntries(path string, modTime time.Time, entries []localFileStructure.DirectoryEntry) error {
+	directory := datastore.DirectoryData{
+		Path:    path,
+		ModTime: modTime,
+		Entries: make([]datastore.DirectoryEntry, 0, len(entries)),
+		ScanId:  c.scanId,
+	}
+	for _, entry := range entries {
+		directory.Entries = append(directory.Entries, datastore.DirectoryEntry{Name: entry.Name, IsDir: entry.IsDir, Size: entry.Size, ModTime: entry.ModTime, FileId: entry.FileId, ChangeTime: entry.ChangeTime})
+	}
+	return c.db.SaveDirectoryData(directory)
+}
+
+// A full scan lists every directory again and picks up files that got modified in place. It is done if requested,
+// if there was no full scan so far or if the last one is older than the interval.
+func isFullScanRequired(db datastore.DirectoryProvider, forced bool, interval time.Duration) (bool, error) {
+	if forced {
+		logrus.Info("Doing a full scan as requested")
+		return true, nil
+	}
+
+	scan, err := db.LastCompletedFullScan()
+	if err == datastore.ErrorRecordNotFound {
+		logrus.Info("Doing a full scan as there was no completed full scan yet")
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if interval > 0 && time.Since(scan.Completed) > interval {
+		logrus.Infof("Doing a full scan as the last one completed at %s", scan.Completed.Format(time.RFC3339))
+		return true, nil
+	}
+	return false, nil
+}
@@ -9,6 +9,7 @@ import (
 	"flag"
 	"github.com/vharitonsky/iniflags"
 	"strings"
+	"time"
 )
 
 var (
@@ -24,6 +25,48 @@ var (
 	dirSuffixToSkip = flag.Int("dirSuffixToSkip", 0, "Set the number of directories at the end of the filepath to remove to build the category (e.g. value of 1: /foo/png/img.png results in foo/img.png).")
 	extensions      arrayFlags
 	ignoreDirs      arrayFlags
+	piwigoTargets   arrayFlags
+	rootDefinitions arrayFlags
+	ruleDefinitions arrayFlags
+
+	duplicateCategories = flag.String("duplicateCategories", "fail", "Defines what happens if piwigo contains categories with the same name and parent. (fail,lowestId)")
+	categoryBindings    arrayFlags
+	baseCategories      arrayFlags
+
+	adoptExisting  = flag.Bool("adoptExisting", false, "If set to true, images with a different checksum that exist in the same album with the same filename on piwigo are adopted instead of uploaded again.")
+	adoptMatchSize = flag.Bool("adoptMatchSize", false, "If set to true, adopted images must have the same file size as the local file.")
+	adoptMatchDate = flag.Bool("adoptMatchDate", false, "If set to true, adopted images must have the same capture date as the exif data of the local file.")
+
+	followSymlinks = flag.Bool("followSymlinks", false, "If set to true, symlinks to files and directories are followed. Every file is only scanned once even if more than one link points to it.")
+	symlinkKeys    = flag.String("symlinkKeys", "link", "Defines which path of a followed symlink is used to build the album. (link,resolved)")
+
+	categoryRewrites arrayFlags
+	dateLayout       = flag.String("dateLayout", "", "Builds the albums from the capture date of the images instead of the directories using a template like {year}/{year}-{month}. Uses the modification time if an image has no capture date. (placeholders: {year},{month},{day})")
+	previewAlbums    = flag.Bool("previewAlbums", false, "If set to true, the local directories are scanned and the resulting albums are printed without connecting to piwigo.")
+
+	maxAlbumDepth       = flag.Int("maxAlbumDepth", 0, "Set the maximum number of album levels created for the directories. Deeper directories are merged into their ancestor at the limit. Zero disables the limit.")
+	joinCollapsedAlbums = flag.Bool("joinCollapsedAlbums", false, "If set to true, directories below maxAlbumDepth get their own album named by all collapsed directories (e.g. Trip - Day 2 - Beach).")
+	skipEmptyAlbums     = flag.Bool("skipEmptyAlbums", false, "If set to true, albums are only created for directories containing at least one image to upload. Albums whose images are all gone get deleted if removeImages is set.")
+
+	useDirectoryCache = flag.Bool("directoryCache", false, "If set to true, the listings of the directories are stored in the sqliteDb and directories with an unchanged modification time are not listed again.")
+	fullScan          = flag.Bool("fullScan", false, "If set to true, all directories are listed again even if the directoryCache is enabled.")
+	fullScanInterval  = flag.Duration("fullScanInterval", 7*24*time.Hour, "The time after which a full scan is done even if the directoryCache is enabled. Zero disables the periodic full scan.")
+
+	quietPeriod       = flag.Duration("quietPeriod", 0, "Files modified within this period are still being written and get checked again on the next run. Files changing while they are hashed are skipped as well.")
+	readMetadata      = flag.Bool("readMetadata", false, "If set to true, the exif, iptc and xmp metadata of jpeg and png files and their .xmp sidecars is read and stored in the sqliteDb. A changed sidecar only updates the stored metadata.")
+	variantPreference = flag.String("variantPreference", "", "Comma separated list of file name endings in the order of preference, e.g. -edit.jpg,.jpg. Of the files sharing a base name within a directory only the most preferred one is uploaded and replaces the image of a less preferred one.")
+	validateImages    = flag.Bool("validateImages", false, "If set to true, jpeg, png, gif and webp files are checked by their content before the upload. Invalid files are not uploaded until they change.")
+	changeDetection   = flag.String("changeDetection", "mtime", "Defines which files are hashed to find changes: files with a changed modification time, files with a changed modification time, size, inode or change time, or all files. Except for mtime, only files with a changed checksum get uploaded. (mtime,fingerprint,hash)")
+	verifyChecksums   = flag.Bool("verifyChecksums", false, "If set to true, all files are hashed and compared with the checksums in the sqliteDb. Mismatches are reported and the application exits with code 13 without connecting to piwigo.")
+	strongHash        = flag.String("strongHash", "", "Additional collision resistant hash calculated together with the md5 sum and stored in the sqliteDb. New files with the content of an image whose file is gone are handled as moved instead of being uploaded again. (sha256,blake2b)")
+	hashWorkers       = flag.Int("hashWorkers", 0, "Set the number of files that get hashed in parallel. Zero uses the number of CPUs.")
+	adaptiveHashing   = flag.Bool("adaptiveHashing", false, "If set to true, the number of files hashed in parallel starts at one and is adjusted up to hashWorkers by the measured read throughput.")
+	scrubSize         = flag.Int("scrubSize", 0, "The amount of files in GiB that are hashed again per complete run to find corrupted files, starting with the files verified longest ago. Corrupted files are reported and not uploaded. Zero disables the scrub.")
+	maxReadRate       = flag.Int("maxReadRate", 0, "Limits the bandwidth used to read the files while hashing in MiB per second. Zero disables the limit.")
+
+	watchMode         = flag.Bool("watch", false, "If set to true, the application keeps running and synchronizes the changed files and directories as soon as no further changes arrive for the watchDebounce period.")
+	watchDebounce     = flag.Duration("watchDebounce", 5*time.Second, "The time without further changes after which the changed files are synchronized in watch mode.")
+	reconcileInterval = flag.Duration("reconcileInterval", 24*time.Hour, "The time after which the watch mode runs a complete synchronization to catch missed changes and removed files. Zero disables the periodic synchronization.")
 )
 
 type arrayFlags []string
@@ -47,5 +90,11 @@ func (arr *arrayFlags) Set(value string) error {
 func initializeFlags() {
 	flag.Var(&extensions, "extension", "Supported file extensions. Flag can be specified multiple times. Uses jpg and png if omitted.")
 	flag.Var(&ignoreDirs, "ignoreDir", "Directories that should be ignored. Flag can be specified multiple times for more than one directory.")
+	flag.Var(&rootDefinitions, "root", "Additional local root path mirrored below an album using the format path|albumPrefix|extensions|ignoreDirs. The lists are separated by commas and use the global values if omitted. Flag can be specified multiple times.")
+	flag.Var(&ruleDefinitions, "rule", "Include or exclude files and directories by their relative path using the format include|exclude followed by a glob or re:regex. The first matching rule wins. Flag can be specified multiple times.")
+	flag.Var(&categoryRewrites, "categoryRewrite", "Rewrites the relative directory path used to build the album using the format regex=>replacement. Capture groups are referenced as $1. The rules are applied in order. Flag can be specified multiple times.")
+	flag.Var(&categoryBindings, "categoryBinding", "Binds a local directory to the piwigo category id to use if there are categories with the same name using the format [target|]directory|id. Flag can be specified multiple times.")
+	flag.Var(&baseCategories, "baseCategory", "Existing piwigo category used as parent of all top level categories using the format [target|]id or [target|]path. Flag can be specified once per target.")
+	flag.Var(&piwigoTargets, "piwigoTarget", "Additional piwigo server to mirror the images to using the format name|url|user|password. Flag can be specified multiple times for more than one server.")
 	iniflags.Parse()
 }
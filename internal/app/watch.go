@@ -0,0 +1,216 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package app
+
+import (
+	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/localFileStructure"
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Watches the roots for changes and synchronizes the changed files and directories once no further events arrived
+// for the debounce period. A complete synchronization runs at the start, after the reconcile interval and whenever
+// events got lost, as only the complete run finds removed files.
+func watchRoots(context *appContext) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	for _, root := range context.roots {
+		rootPath, err := filepath.Abs(root.Path)
+		if err != nil {
+			return err
+		}
+		addWatches(watcher, rootPath, root.IgnoreDirs)
+	}
+
+	// the watches are added first, so no change gets lost between the complete run and the first event
+	changed := make(map[string]struct{})
+	requeuePending(changed, synchronizeForTargets(context, nil))
+
+	var reconcile <-chan time.Time
+	if *reconcileInterval > 0 {
+		ticker := time.NewTicker(*reconcileInterval)
+		defer ticker.Stop()
+		reconcile = ticker.C
+	}
+
+	debounce := time.NewTimer(*watchDebounce)
+	if len(changed) == 0 {
+		stopTimer(debounce)
+	}
+	completeRunRequired := false
+
+	logrus.Infof("Watching %d roots for changes", len(context.roots))
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			logrus.Debugf("Got %s", event)
+
+			path := event.Name
+			if event.Op&fsnotify.Create != 0 {
+				if info, err := os.Lstat(path); err == nil && info.IsDir() {
+					addWatches(watcher, path, nil)
+				}
+			}
+			if localFileStructure.AffectsDirectory(path) {
+				path = filepath.Dir(path)
+			}
+			changed[path] = struct{}{}
+			resetTimer(debounce, *watchDebounce)
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			logrus.Warnf("Events of the watched directories got lost, running a complete synchronization - %s", err)
+			completeRunRequired = true
+			resetTimer(debounce, *watchDebounce)
+
+		case <-debounce.C:
+			var pending []string
+			if completeRunRequired {
+				pending = synchronizeForTargets(context, nil)
+			} else {
+				pending = synchronizeForTargets(context, sortedPaths(changed))
+			}
+			completeRunRequired = false
+			changed = make(map[string]struct{})
+			if requeuePending(changed, pending) {
+				resetTimer(debounce, pendingRetryDelay())
+			}
+
+		case <-reconcile:
+			logrus.Info("Running the periodic complete synchronization")
+			changed = make(map[string]struct{})
+			if requeuePending(changed, synchronizeForTargets(context, nil)) {
+				resetTimer(debounce, pendingRetryDelay())
+			}
+		}
+	}
+}
+
+// Makes sure the sessions are still valid before synchronizing, as the watch mode may be idle for a long time.
+func synchronizeForTargets(context *appContext, paths []string) []string {
+	for _, target := range context.targets {
+		err := target.piwigo.KeepAlive()
+		if err != nil {
+			logErrorAndExit(err, 2)
+		}
+	}
+
+	if len(paths) > 0 {
+		logrus.Infof("Synchronizing %d changed paths", len(paths))
+	}
+	return synchronize(context, paths)
+}
+
+// Files that are still being written are checked again once the quiet period is over.
+func requeuePending(changed map[string]struct{}, pending []string) bool {
+	for _, path := range pending {
+		changed[path] = struct{}{}
+	}
+	return len(pending) > 0
+}
+
+// Watches the directory and all directories below it. Hidden and ignored directories are skipped like the scan
+// does. A directory that could not be watched is only synchronized by the complete runs.
+func addWatches(watcher *fsnotify.Watcher, path string, ignoreDirs []string) {
+	_ = filepath.Walk(path, func(dir string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+		if dir != path && (strings.HasPrefix(info.Name(), ".") || isIgnoredDir(info.Name(), ignoreDirs)) {
+			return filepath.SkipDir
+		}
+
+		err = watcher.Add(dir)
+		if err != nil {
+			logrus.Warnf("Could not watch %s, changes are only found by the complete synchronization - %s", dir, err)
+		}
+		return nil
+	})
+}
+
+func isIgnoredDir(name string, ignoreDirs []string) bool {
+	for _, ignoreDir := range ignoreDirs {
+		if strings.EqualFold(name, ignoreDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// Only the roots containing a changed path get scanned.
+func limitRootsToPaths(roots []localFileStructure.ScanRoot, paths []string) []localFileStructure.ScanRoot {
+	var limited []localFileStructure.ScanRoot
+	for _, root := range roots {
+		rootPath, err := filepath.Abs(root.Path)
+		if err != nil {
+			continue
+		}
+
+		var rootPaths []string
+		for _, path := range paths {
+			if path == rootPath || strings.HasPrefix(path, rootPath+string(os.PathSeparator)) {
+				rootPaths = append(rootPaths, path)
+			}
+		}
+		if len(rootPaths) == 0 {
+			continue
+		}
+
+		root.Paths = rootPaths
+		root.Cache = nil
+		limited = append(limited, root)
+	}
+	return limited
+}
+
+func sortedPaths(paths map[string]struct{}) []string {
+	sorted := make([]string, 0, len(paths))
+	for path := range paths {
+		sorted = append(sorted, path)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+func stopTimer(timer *time.Timer) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+}
+
+// Files still being written are checked again after the quietPeriod, but never sooner than the debounce, so files
+// that keep changing while they are hashed do not trigger one synchronization after another.
+func pendingRetryDelay() time.Duration {
+	if *quietPeriod > *watchDebounce {
+		return *quietPeriod
+	}
+	return *watchDebounce
+}
+
+func resetTimer(timer *time.Timer, duration time.Duration) {
+	stopTimer(timer)
+	timer.Reset(duration)
+}
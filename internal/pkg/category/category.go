@@ -8,64 +8,100 @@ package category
 import (
 	"errors"
 	"fmt"
+	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/categoryKey"
 	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/datastore"
 	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/localFileStructure"
 	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/piwigo"
 	"github.com/sirupsen/logrus"
 	"path/filepath"
+	"strconv"
+	"strings"
 )
 
-func SynchronizeCategories(filesystemNodes map[string]*localFileStructure.FilesystemNode, piwigoApi piwigo.CategoryApi, db datastore.CategoryProvider) error {
-	logrus.Debug("Entering SynchronizeCategories...")
-	defer logrus.Debug("Leaving SynchronizeCategories...")
+// UpdateCategoriesFromServer loads the categories of piwigo into the local database. This has to be done before
+// the categories of the local directories get synchronized with SynchronizeCategory.
+func UpdateCategoriesFromServer(piwigoApi piwigo.CategoryApi, db datastore.CategoryProvider) error {
+	return updatePiwigoCategoriesFromServer(piwigoApi, db)
+}
+
+// SynchronizeCategory adds the category of a single directory to the local database and creates it on piwigo
+// right away, so the images within the directory can be assigned to it. The parent directory has to be
+// synchronized before.
+func SynchronizeCategory(directory *localFileStructure.FilesystemNode, piwigoApi piwigo.CategoryApi, db datastore.CategoryProvider) error {
+	if !directory.IsDir {
+		return nil
+	}
 
-	err := updatePiwigoCategoriesFromServer(piwigoApi, db)
+	err := addMissingCategoryToLocalDb(db, directory)
 	if err != nil {
 		return err
 	}
 
-	logrus.Infoln("Adding missing categories to local db...")
-	err = addMissingPiwigoCategoriesToLocalDb(db, filesystemNodes)
+	category, err := db.GetCategoryByKey(directory.Key)
 	if err != nil {
 		return err
 	}
-
-	return createMissingCategories(piwigoApi, db)
+	if category.PiwigoId > 0 {
+		return nil
+	}
+	return createCategory(piwigoApi, db, category)
 }
 
-func addMissingPiwigoCategoriesToLocalDb(db datastore.CategoryProvider, fileSystemNodes map[string]*localFileStructure.FilesystemNode) error {
-	logrus.Debug("Entering addMissingPiwigoCategoriesToLocalDb...")
-	defer logrus.Debug("Leave addMissingPiwigoCategoriesToLocalDb...")
-
-	for _, file := range fileSystemNodes {
-		if !file.IsDir {
-			logrus.Tracef("%s: Skipping as no directory", file.Key)
-			continue
+func addMissingCategoryToLocalDb(db datastore.CategoryProvider, directory *localFileStructure.FilesystemNode) error {
+	category, err := db.GetCategoryByKey(directory.Key)
+	if err == nil {
+		if !applyDirectorySettings(&category, directory) {
+			logrus.Debugf("%s already exists.", directory.Key)
+			return nil
 		}
 
-		_, err := db.GetCategoryByKey(file.Key)
-		if err == nil {
-			logrus.Debugf("%s already exists.", file.Key)
-			continue
-		}
-		if err != datastore.ErrorRecordNotFound {
-			return err
-		}
+		logrus.Debugf("Settings of category %s changed", directory.Key)
+		category.InfoUpdateRequired = true
+		return db.SaveCategory(category)
+	}
+	if err != datastore.ErrorRecordNotFound {
+		return err
+	}
 
-		logrus.Debugf("Creating missing category %s", file.Key)
-		category := datastore.CategoryData{
-			Key:            file.Key,
-			Name:           file.Name,
-			PiwigoParentId: 0,
-			PiwigoId:       0,
-		}
+	logrus.Debugf("Creating missing category %s", directory.Key)
+	category = datastore.CategoryData{
+		Key:            directory.Key,
+		Name:           directory.Name,
+		PiwigoParentId: 0,
+		PiwigoId:       0,
+	}
+	category.InfoUpdateRequired = applyDirectorySettings(&category, directory)
 
-		err = db.SaveCategory(category)
-		if err != nil {
-			return err
-		}
+	return db.SaveCategory(category)
+}
+
+// Copies the settings of the directory to the category and reports if any of them changed.
+func applyDirectorySettings(category *datastore.CategoryData, directory *localFileStructure.FilesystemNode) bool {
+	settings := directory.Settings
+
+	coverImagePath := ""
+	if settings.Cover != "" {
+		coverImagePath = filepath.Join(directory.Path, settings.Cover)
 	}
-	return nil
+	userIds := joinIds(settings.Users)
+	groupIds := joinIds(settings.Groups)
+
+	if category.Description == settings.Description &&
+		category.Status == settings.Status &&
+		category.UserIds == userIds &&
+		category.GroupIds == groupIds &&
+		category.CoverImagePath == coverImagePath &&
+		category.Rank == settings.Rank {
+		return false
+	}
+
+	category.Description = settings.Description
+	category.Status = settings.Status
+	category.UserIds = userIds
+	category.GroupIds = groupIds
+	category.CoverImagePath = coverImagePath
+	category.Rank = settings.Rank
+	return true
 }
 
 func updatePiwigoCategoriesFromServer(piwigoApi piwigo.CategoryApi, db datastore.CategoryProvider) error {
@@ -107,42 +143,134 @@ func updatePiwigoCategoriesFromServer(piwigoApi piwigo.CategoryApi, db datastore
 	return nil
 }
 
-func createMissingCategories(piwigoApi piwigo.CategoryApi, db datastore.CategoryProvider) error {
-	logrus.Debug("Entering createMissingCategories...")
-	defer logrus.Debug("Leaving createMissingCategories...")
+func createCategory(piwigoApi piwigo.CategoryApi, db datastore.CategoryProvider, category datastore.CategoryData) error {
+	logrus.Infof("Creating category %s", category.Key)
+
+	parentId, err := getParentId(category, db)
+	if err != nil {
+		return err
+	}
+
+	// create category on piwigo
+	id, err := piwigoApi.CreateCategory(parentId, category.Name)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Could not create category on piwigo: %s", err))
+	}
+
+	// update local category information
+	category.PiwigoId = id
+	category.PiwigoParentId = parentId
+
+	return db.SaveCategory(category)
+}
+
+// Sends the changed settings of the categories to piwigo. The cover of a category can only be set after the image
+// got uploaded, so categories with a missing cover image are updated again on the next run.
+func UpdateCategorySettings(piwigoApi piwigo.CategoryApi, db datastore.CategoryProvider, imageDb datastore.ImageMetadataProvider) error {
+	logrus.Debug("Entering UpdateCategorySettings...")
+	defer logrus.Debug("Leaving UpdateCategorySettings...")
 
-	missingCategories, err := db.GetCategoriesToCreate()
+	categories, err := db.GetCategoriesToUpdate()
 	if err != nil {
 		return err
 	}
 
-	if len(missingCategories) == 0 {
-		logrus.Info("No categories missing on piwigo.")
+	if len(categories) == 0 {
+		logrus.Info("No category settings to update on piwigo.")
 		return nil
 	}
 
-	logrus.Infof("Creating %d categories", len(missingCategories))
+	logrus.Infof("Updating settings of %d categories", len(categories))
 
-	for _, category := range missingCategories {
-		logrus.Infof("Creating category %s", category.Key)
+	for _, category := range categories {
+		settings := piwigo.CategorySettings{
+			Description: category.Description,
+			Status:      category.Status,
+			UserIds:     splitIds(category.UserIds),
+			GroupIds:    splitIds(category.GroupIds),
+			Rank:        category.Rank,
+		}
 
-		var parentId int
-		parentId, err = getParentId(category, db)
+		coverMissing := false
+		if category.CoverImagePath != "" {
+			img, err := imageDb.ImageMetadata(category.CoverImagePath)
+			if err == nil && img.PiwigoId > 0 {
+				settings.RepresentativeId = img.PiwigoId
+			} else {
+				logrus.Warnf("The cover %s of category %s is not uploaded yet", category.CoverImagePath, category.Key)
+				coverMissing = true
+			}
+		}
+
+		err = piwigoApi.UpdateCategorySettings(category.PiwigoId, settings)
 		if err != nil {
-			return err
+			return errors.New(fmt.Sprintf("Could not update settings of category %s on piwigo: %s", category.Key, err))
 		}
 
-		// create category on piwigo
-		id, err := piwigoApi.CreateCategory(parentId, category.Name)
+		category.InfoUpdateRequired = coverMissing
+		err = db.SaveCategory(category)
 		if err != nil {
-			return errors.New(fmt.Sprintf("Could not create category on piwigo: %s", err))
+			return err
 		}
+	}
 
-		// update local category information
-		category.PiwigoId = id
-		category.PiwigoParentId = parentId
+	return nil
+}
 
-		err = db.SaveCategory(category)
+// MarkEmptyCategories marks the categories whose images are all gone for deletion.
+func MarkEmptyCategories(db datastore.CategoryProvider) error {
+	marked, err := db.MarkEmptyCategoriesForDeletion()
+	if err != nil {
+		return err
+	}
+
+	logrus.Infof("Marked %d categories without images for deletion", marked)
+	return nil
+}
+
+// DeleteEmptyCategories deletes the marked categories on piwigo and in the local database. Categories that still
+// contain images on piwigo are kept, e.g. as the removed images were not deleted on piwigo yet.
+func DeleteEmptyCategories(piwigoApi piwigo.CategoryApi, db datastore.CategoryProvider) error {
+	logrus.Debug("Entering DeleteEmptyCategories...")
+	defer logrus.Debug("Leaving DeleteEmptyCategories...")
+
+	categories, err := db.GetCategoriesToDelete()
+	if err != nil {
+		return err
+	}
+
+	if len(categories) == 0 {
+		logrus.Info("No empty categories to delete.")
+		return nil
+	}
+
+	serverCategories, err := piwigoApi.GetAllCategories()
+	if err != nil {
+		return err
+	}
+	serverCategoriesById := make(map[int]*piwigo.Category, len(serverCategories))
+	for _, serverCategory := range serverCategories {
+		serverCategoriesById[serverCategory.Id] = serverCategory
+	}
+
+	logrus.Infof("Deleting %d empty categories", len(categories))
+
+	for _, category := range categories {
+		serverCategory, exists := serverCategoriesById[category.PiwigoId]
+		if exists && serverCategory.TotalImages > 0 {
+			logrus.Warnf("Keeping category %s as it still contains %d images on piwigo", category.Key, serverCategory.TotalImages)
+			continue
+		}
+
+		if exists {
+			logrus.Infof("Deleting empty category %s", category.Key)
+			err = piwigoApi.DeleteCategory(category.PiwigoId)
+			if err != nil {
+				return errors.New(fmt.Sprintf("Could not delete category %s on piwigo: %s", category.Key, err))
+			}
+		}
+
+		err = db.DeleteCategory(category.CategoryId)
 		if err != nil {
 			return err
 		}
@@ -151,6 +279,31 @@ func createMissingCategories(piwigoApi piwigo.CategoryApi, db datastore.Category
 	return nil
 }
 
+func joinIds(ids []int) string {
+	parts := make([]string, 0, len(ids))
+	for _, id := range ids {
+		parts = append(parts, strconv.Itoa(id))
+	}
+	return strings.Join(parts, ",")
+}
+
+func splitIds(ids string) []int {
+	if ids == "" {
+		return nil
+	}
+
+	var values []int
+	for _, part := range strings.Split(ids, ",") {
+		id, err := strconv.Atoi(part)
+		if err != nil {
+			logrus.Warnf("Ignoring invalid id %s", part)
+			continue
+		}
+		values = append(values, id)
+	}
+	return values
+}
+
 func getParentId(category datastore.CategoryData, db datastore.CategoryProvider) (int, error) {
 	if category.Key == "" || category.Key == "." {
 		msg := fmt.Sprintf("Category with id %d has a invalid value in the keyfield!", category.CategoryId)
@@ -158,8 +311,8 @@ func getParentId(category datastore.CategoryData, db datastore.CategoryProvider)
 		return 0, errors.New(msg)
 	}
 
-	parentKey := filepath.Dir(category.Key)
-	if category.Name == parentKey || parentKey == "." || parentKey == "" {
+	parentKey := categoryKey.Parent(category.Key)
+	if parentKey == "" {
 		logrus.Debugf("The category %s is a root category, there is no parent", category.Name)
 		return 0, nil
 	}
@@ -11,12 +11,13 @@ import (
 	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/localFileStructure"
 	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/piwigo"
 	"github.com/golang/mock/gomock"
+	"path/filepath"
 	"testing"
 	"time"
 )
 
 //go:generate mockgen -destination=./piwigo_mock_test.go -package=category git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/piwigo CategoryApi,ImageApi
-//go:generate mockgen -destination=./datastore_mock_test.go -package=category git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/datastore CategoryProvider
+//go:generate mockgen -destination=./datastore_mock_test.go -package=category git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/datastore CategoryProvider,ImageMetadataProvider
 
 func Test_updatePiwigoCategoriesFromServer_adds_new_categories(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
@@ -71,55 +72,55 @@ func Test_updatePiwigoCategoriesFromServer_updates_a_category(t *testing.T) {
 	}
 }
 
-func Test_createMissingCategories_does_not_call_piwigo_if_there_is_no_category_missing(t *testing.T) {
+func Test_addMissingCategoryToLocalDb_creates_category_in_database(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
 
-	var categoriesToCreate []datastore.CategoryData
+	expectedCategory := createDbRootCategory()
+	expectedCategory.PiwigoParentId = 0
+	expectedCategory.PiwigoId = 0
+	expectedCategory.CategoryId = 0
 
-	dbmock := NewMockCategoryProvider(mockCtrl)
-	dbmock.EXPECT().GetCategoriesToCreate().Return(categoriesToCreate, nil).Times(1)
+	fileNode := &localFileStructure.FilesystemNode{
+		Name:    expectedCategory.Name,
+		Key:     expectedCategory.Key,
+		Path:    fmt.Sprintf("/home/nonexisting/%s", expectedCategory.Name),
+		ModTime: time.Now(),
+		IsDir:   true,
+	}
 
-	piwigoMock := NewMockCategoryApi(mockCtrl)
-	piwigoMock.EXPECT().CreateCategory(gomock.Any(), gomock.Any()).Times(0)
+	dbmock := NewMockCategoryProvider(mockCtrl)
+	dbmock.EXPECT().GetCategoryByKey(fileNode.Key).Return(datastore.CategoryData{}, datastore.ErrorRecordNotFound).Times(1)
+	dbmock.EXPECT().SaveCategory(expectedCategory).Return(nil).Times(1)
 
-	err := createMissingCategories(piwigoMock, dbmock)
+	err := addMissingCategoryToLocalDb(dbmock, fileNode)
 	if err != nil {
 		t.Error(err)
 	}
 }
 
-func Test_addMissingPiwigoCategoriesToLocalDb_creates_category_in_database(t *testing.T) {
+func Test_addMissingCategoryToLocalDb_does_nothing_already_in_db(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
 
-	expectedCategory := createDbRootCategory()
-	expectedCategory.PiwigoParentId = 0
-	expectedCategory.PiwigoId = 0
-	expectedCategory.CategoryId = 0
-
 	fileNode := &localFileStructure.FilesystemNode{
-		Name:    expectedCategory.Name,
-		Key:     expectedCategory.Key,
-		Path:    fmt.Sprintf("/home/nonexisting/%s", expectedCategory.Name),
+		Name:    "dir",
+		Key:     "dir",
+		Path:    "/home/nonexisting/dir",
 		ModTime: time.Now(),
 		IsDir:   true,
 	}
 
-	fileSystemNodes := make(map[string]*localFileStructure.FilesystemNode)
-	fileSystemNodes[fileNode.Key] = fileNode
-
 	dbmock := NewMockCategoryProvider(mockCtrl)
-	dbmock.EXPECT().GetCategoryByKey(fileNode.Key).Return(datastore.CategoryData{}, datastore.ErrorRecordNotFound).Times(1)
-	dbmock.EXPECT().SaveCategory(expectedCategory).Return(nil).Times(1)
+	dbmock.EXPECT().GetCategoryByKey(fileNode.Key).Return(datastore.CategoryData{}, nil).Times(1)
 
-	err := addMissingPiwigoCategoriesToLocalDb(dbmock, fileSystemNodes)
+	err := addMissingCategoryToLocalDb(dbmock, fileNode)
 	if err != nil {
 		t.Error(err)
 	}
 }
 
-func Test_addMissingPiwigoCategoriesToLocalDb_does_nothing_already_in_db(t *testing.T) {
+func Test_addMissingCategoryToLocalDb_marks_changed_settings_for_update(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
 
@@ -129,21 +130,31 @@ func Test_addMissingPiwigoCategoriesToLocalDb_does_nothing_already_in_db(t *test
 		Path:    "/home/nonexisting/dir",
 		ModTime: time.Now(),
 		IsDir:   true,
+		Settings: localFileStructure.DirectorySettings{
+			Description: "Holidays",
+			Users:       []int{1, 2},
+			Cover:       "cover.jpg",
+		},
 	}
 
-	fileSystemNodes := make(map[string]*localFileStructure.FilesystemNode)
-	fileSystemNodes[fileNode.Key] = fileNode
+	existingCategory := datastore.CategoryData{CategoryId: 1, PiwigoId: 2, Key: "dir", Name: "dir"}
+	expectedCategory := existingCategory
+	expectedCategory.Description = "Holidays"
+	expectedCategory.UserIds = "1,2"
+	expectedCategory.CoverImagePath = filepath.Join("/home/nonexisting/dir", "cover.jpg")
+	expectedCategory.InfoUpdateRequired = true
 
 	dbmock := NewMockCategoryProvider(mockCtrl)
-	dbmock.EXPECT().GetCategoryByKey(fileNode.Key).Return(datastore.CategoryData{}, nil).Times(1)
+	dbmock.EXPECT().GetCategoryByKey(fileNode.Key).Return(existingCategory, nil).Times(1)
+	dbmock.EXPECT().SaveCategory(expectedCategory).Times(1)
 
-	err := addMissingPiwigoCategoriesToLocalDb(dbmock, fileSystemNodes)
+	err := addMissingCategoryToLocalDb(dbmock, fileNode)
 	if err != nil {
 		t.Error(err)
 	}
 }
 
-func Test_addMissingPiwigoCategoriesToLocalDb_does_nothing_if_list_contains_only_files(t *testing.T) {
+func Test_SynchronizeCategory_does_nothing_for_files(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
 
@@ -155,49 +166,166 @@ func Test_addMissingPiwigoCategoriesToLocalDb_does_nothing_if_list_contains_only
 		IsDir:   false,
 	}
 
-	fileSystemNodes := make(map[string]*localFileStructure.FilesystemNode)
-	fileSystemNodes[fileNode.Key] = fileNode
-
 	dbmock := NewMockCategoryProvider(mockCtrl)
+	piwigoMock := NewMockCategoryApi(mockCtrl)
 
-	err := addMissingPiwigoCategoriesToLocalDb(dbmock, fileSystemNodes)
+	err := SynchronizeCategory(fileNode, piwigoMock, dbmock)
 	if err != nil {
 		t.Error(err)
 	}
 }
 
-func Test_addMissingPiwigoCategoriesToLocalDb_does_nothing_if_list_is_empty(t *testing.T) {
+func Test_SynchronizeCategory_creates_missing_category_on_piwigo(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
 
-	var fileSystemNodes map[string]*localFileStructure.FilesystemNode
+	expectedCategory := createDbRootCategory()
+	expectedCategory.CategoryId = 0
+	category := expectedCategory
+	category.PiwigoId = 0
+
+	fileNode := &localFileStructure.FilesystemNode{
+		Name:  category.Name,
+		Key:   category.Key,
+		Path:  fmt.Sprintf("/home/nonexisting/%s", category.Name),
+		IsDir: true,
+	}
 
 	dbmock := NewMockCategoryProvider(mockCtrl)
+	gomock.InOrder(
+		dbmock.EXPECT().GetCategoryByKey(fileNode.Key).Return(datastore.CategoryData{}, datastore.ErrorRecordNotFound),
+		dbmock.EXPECT().SaveCategory(category).Return(nil),
+		dbmock.EXPECT().GetCategoryByKey(fileNode.Key).Return(category, nil),
+		dbmock.EXPECT().SaveCategory(expectedCategory).Return(nil),
+	)
 
-	err := addMissingPiwigoCategoriesToLocalDb(dbmock, fileSystemNodes)
+	piwigoMock := NewMockCategoryApi(mockCtrl)
+	piwigoMock.EXPECT().CreateCategory(0, category.Name).Return(expectedCategory.PiwigoId, nil).Times(1)
+
+	err := SynchronizeCategory(fileNode, piwigoMock, dbmock)
 	if err != nil {
 		t.Error(err)
 	}
 }
 
-func Test_createMissingCategories_calls_piwigo_api_and_saves_returned_id(t *testing.T) {
+func Test_SynchronizeCategory_does_not_call_piwigo_for_existing_category(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
 
-	expectedCategory := createDbRootCategory()
 	category := createDbRootCategory()
-	category.PiwigoId = 0
-
-	categoriesToCreate := []datastore.CategoryData{category}
+	fileNode := &localFileStructure.FilesystemNode{Name: category.Name, Key: category.Key, IsDir: true}
 
 	dbmock := NewMockCategoryProvider(mockCtrl)
-	dbmock.EXPECT().GetCategoriesToCreate().Return(categoriesToCreate, nil).Times(1)
-	dbmock.EXPECT().SaveCategory(expectedCategory).Return(nil).Times(1)
+	dbmock.EXPECT().GetCategoryByKey(fileNode.Key).Return(category, nil).Times(2)
+	dbmock.EXPECT().SaveCategory(gomock.Any()).Times(0)
 
 	piwigoMock := NewMockCategoryApi(mockCtrl)
-	piwigoMock.EXPECT().CreateCategory(0, category.Name).Return(1, nil).Times(1)
+	piwigoMock.EXPECT().CreateCategory(gomock.Any(), gomock.Any()).Times(0)
 
-	err := createMissingCategories(piwigoMock, dbmock)
+	err := SynchronizeCategory(fileNode, piwigoMock, dbmock)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_UpdateCategorySettings_sends_settings_with_cover(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	category := datastore.CategoryData{
+		CategoryId:         1,
+		PiwigoId:           2,
+		Key:                "dir",
+		Status:             "private",
+		GroupIds:           "3",
+		CoverImagePath:     "/home/nonexisting/dir/cover.jpg",
+		InfoUpdateRequired: true,
+	}
+	savedCategory := category
+	savedCategory.InfoUpdateRequired = false
+
+	dbmock := NewMockCategoryProvider(mockCtrl)
+	dbmock.EXPECT().GetCategoriesToUpdate().Return([]datastore.CategoryData{category}, nil).Times(1)
+	dbmock.EXPECT().SaveCategory(savedCategory).Times(1)
+
+	imagemock := NewMockImageMetadataProvider(mockCtrl)
+	imagemock.EXPECT().ImageMetadata(category.CoverImagePath).Return(datastore.ImageMetaData{PiwigoId: 42}, nil).Times(1)
+
+	expectedSettings := piwigo.CategorySettings{Status: "private", GroupIds: []int{3}, RepresentativeId: 42}
+	piwigomock := NewMockCategoryApi(mockCtrl)
+	piwigomock.EXPECT().UpdateCategorySettings(2, expectedSettings).Return(nil).Times(1)
+
+	err := UpdateCategorySettings(piwigomock, dbmock, imagemock)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_UpdateCategorySettings_keeps_update_flag_if_cover_is_missing(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	category := datastore.CategoryData{
+		CategoryId:         1,
+		PiwigoId:           2,
+		Key:                "dir",
+		CoverImagePath:     "/home/nonexisting/dir/cover.jpg",
+		InfoUpdateRequired: true,
+	}
+
+	dbmock := NewMockCategoryProvider(mockCtrl)
+	dbmock.EXPECT().GetCategoriesToUpdate().Return([]datastore.CategoryData{category}, nil).Times(1)
+	dbmock.EXPECT().SaveCategory(category).Times(1)
+
+	imagemock := NewMockImageMetadataProvider(mockCtrl)
+	imagemock.EXPECT().ImageMetadata(category.CoverImagePath).Return(datastore.ImageMetaData{}, datastore.ErrorRecordNotFound).Times(1)
+
+	piwigomock := NewMockCategoryApi(mockCtrl)
+	piwigomock.EXPECT().UpdateCategorySettings(2, piwigo.CategorySettings{}).Return(nil).Times(1)
+
+	err := UpdateCategorySettings(piwigomock, dbmock, imagemock)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_DeleteEmptyCategories_deletes_only_categories_without_images_on_piwigo(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	serverCategories := createTwoServerCategories()
+	serverCategories["2019"].TotalImages = 3
+
+	sub := datastore.CategoryData{CategoryId: 2, PiwigoId: 2, Key: "2019/SubCategory", DeleteRequired: true}
+	root := datastore.CategoryData{CategoryId: 1, PiwigoId: 1, Key: "2019", DeleteRequired: true}
+	localOnly := datastore.CategoryData{CategoryId: 3, PiwigoId: 0, Key: "2018", DeleteRequired: true}
+
+	dbmock := NewMockCategoryProvider(mockCtrl)
+	dbmock.EXPECT().GetCategoriesToDelete().Return([]datastore.CategoryData{sub, root, localOnly}, nil).Times(1)
+	dbmock.EXPECT().DeleteCategory(sub.CategoryId).Times(1)
+	dbmock.EXPECT().DeleteCategory(localOnly.CategoryId).Times(1)
+
+	piwigomock := NewMockCategoryApi(mockCtrl)
+	piwigomock.EXPECT().GetAllCategories().Return(serverCategories, nil).Times(1)
+	piwigomock.EXPECT().DeleteCategory(sub.PiwigoId).Return(nil).Times(1)
+
+	err := DeleteEmptyCategories(piwigomock, dbmock)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_DeleteEmptyCategories_does_nothing_without_marked_categories(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	dbmock := NewMockCategoryProvider(mockCtrl)
+	dbmock.EXPECT().GetCategoriesToDelete().Return(nil, nil).Times(1)
+
+	piwigomock := NewMockCategoryApi(mockCtrl)
+	piwigomock.EXPECT().GetAllCategories().Times(0)
+
+	err := DeleteEmptyCategories(piwigomock, dbmock)
 	if err != nil {
 		t.Error(err)
 	}
@@ -279,23 +407,25 @@ func Test_getParentId_finds_the_exptected_parent_id(t *testing.T) {
 	}
 }
 
-func Test_SynchronizeCategories(t *testing.T) {
+func Test_getParentId_does_not_split_names_containing_slashes(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
 
-	fileSystemNodes := make(map[string]*localFileStructure.FilesystemNode)
+	category := createDbRootCategory()
+	category.Name = "AC/DC 2019"
+	category.Key = "AC%2FDC 2019"
 
 	dbmock := NewMockCategoryProvider(mockCtrl)
-	dbmock.EXPECT().GetCategoriesToCreate().Times(1)
-
-	piwigoMock := NewMockCategoryApi(mockCtrl)
-	piwigoMock.EXPECT().GetAllCategories().Times(1)
+	dbmock.EXPECT().GetCategoryByKey(gomock.Any()).Times(0)
 
-	err := SynchronizeCategories(fileSystemNodes, piwigoMock, dbmock)
+	parentId, err := getParentId(category, dbmock)
 	if err != nil {
 		t.Error(err)
 	}
 
+	if parentId != 0 {
+		t.Errorf("Found parent id %d but expected 0", parentId)
+	}
 }
 
 func createDbRootCategory() datastore.CategoryData {
@@ -1,5 +1,5 @@
 // Code generated by MockGen. DO NOT EDIT.
-// Source: git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/datastore (interfaces: CategoryProvider)
+// Source: git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/datastore (interfaces: CategoryProvider,ImageMetadataProvider)
 
 // Package category is a generated GoMock package.
 package category
@@ -33,19 +33,48 @@ func (m *MockCategoryProvider) EXPECT() *MockCategoryProviderMockRecorder {
 	return m.recorder
 }
 
-// GetCategoriesToCreate mocks base method
-func (m *MockCategoryProvider) GetCategoriesToCreate() ([]datastore.CategoryData, error) {
+// DeleteCategory mocks base method
+func (m *MockCategoryProvider) DeleteCategory(arg0 int) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetCategoriesToCreate")
+	ret := m.ctrl.Call(m, "DeleteCategory", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteCategory indicates an expected call of DeleteCategory
+func (mr *MockCategoryProviderMockRecorder) DeleteCategory(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteCategory", reflect.TypeOf((*MockCategoryProvider)(nil).DeleteCategory), arg0)
+}
+
+// GetCategoriesToDelete mocks base method
+func (m *MockCategoryProvider) GetCategoriesToDelete() ([]datastore.CategoryData, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetCategoriesToDelete")
+	ret0, _ := ret[0].([]datastore.CategoryData)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCategoriesToDelete indicates an expected call of GetCategoriesToDelete
+func (mr *MockCategoryProviderMockRecorder) GetCategoriesToDelete() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCategoriesToDelete", reflect.TypeOf((*MockCategoryProvider)(nil).GetCategoriesToDelete))
+}
+
+// GetCategoriesToUpdate mocks base method
+func (m *MockCategoryProvider) GetCategoriesToUpdate() ([]datastore.CategoryData, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetCategoriesToUpdate")
 	ret0, _ := ret[0].([]datastore.CategoryData)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// GetCategoriesToCreate indicates an expected call of GetCategoriesToCreate
-func (mr *MockCategoryProviderMockRecorder) GetCategoriesToCreate() *gomock.Call {
+// GetCategoriesToUpdate indicates an expected call of GetCategoriesToUpdate
+func (mr *MockCategoryProviderMockRecorder) GetCategoriesToUpdate() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCategoriesToCreate", reflect.TypeOf((*MockCategoryProvider)(nil).GetCategoriesToCreate))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCategoriesToUpdate", reflect.TypeOf((*MockCategoryProvider)(nil).GetCategoriesToUpdate))
 }
 
 // GetCategoryByKey mocks base method
@@ -78,6 +107,21 @@ func (mr *MockCategoryProviderMockRecorder) GetCategoryByPiwigoId(arg0 interface
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCategoryByPiwigoId", reflect.TypeOf((*MockCategoryProvider)(nil).GetCategoryByPiwigoId), arg0)
 }
 
+// MarkEmptyCategoriesForDeletion mocks base method
+func (m *MockCategoryProvider) MarkEmptyCategoriesForDeletion() (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MarkEmptyCategoriesForDeletion")
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MarkEmptyCategoriesForDeletion indicates an expected call of MarkEmptyCategoriesForDeletion
+func (mr *MockCategoryProviderMockRecorder) MarkEmptyCategoriesForDeletion() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkEmptyCategoriesForDeletion", reflect.TypeOf((*MockCategoryProvider)(nil).MarkEmptyCategoriesForDeletion))
+}
+
 // SaveCategory mocks base method
 func (m *MockCategoryProvider) SaveCategory(arg0 datastore.CategoryData) error {
 	m.ctrl.T.Helper()
@@ -91,3 +135,202 @@ func (mr *MockCategoryProviderMockRecorder) SaveCategory(arg0 interface{}) *gomo
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveCategory", reflect.TypeOf((*MockCategoryProvider)(nil).SaveCategory), arg0)
 }
+
+// MockImageMetadataProvider is a mock of ImageMetadataProvider interface
+type MockImageMetadataProvider struct {
+	ctrl     *gomock.Controller
+	recorder *MockImageMetadataProviderMockRecorder
+}
+
+// MockImageMetadataProviderMockRecorder is the mock recorder for MockImageMetadataProvider
+type MockImageMetadataProviderMockRecorder struct {
+	mock *MockImageMetadataProvider
+}
+
+// NewMockImageMetadataProvider creates a new mock instance
+func NewMockImageMetadataProvider(ctrl *gomock.Controller) *MockImageMetadataProvider {
+	mock := &MockImageMetadataProvider{ctrl: ctrl}
+	mock.recorder = &MockImageMetadataProviderMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockImageMetadataProvider) EXPECT() *MockImageMetadataProviderMockRecorder {
+	return m.recorder
+}
+
+// DeleteMarkedImages mocks base method
+func (m *MockImageMetadataProvider) DeleteMarkedImages() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteMarkedImages")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteMarkedImages indicates an expected call of DeleteMarkedImages
+func (mr *MockImageMetadataProviderMockRecorder) DeleteMarkedImages() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteMarkedImages", reflect.TypeOf((*MockImageMetadataProvider)(nil).DeleteMarkedImages))
+}
+
+// ImageMetadata mocks base method
+func (m *MockImageMetadataProvider) ImageMetadata(arg0 string) (datastore.ImageMetaData, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ImageMetadata", arg0)
+	ret0, _ := ret[0].(datastore.ImageMetaData)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ImageMetadata indicates an expected call of ImageMetadata
+func (mr *MockImageMetadataProviderMockRecorder) ImageMetadata(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImageMetadata", reflect.TypeOf((*MockImageMetadataProvider)(nil).ImageMetadata), arg0)
+}
+
+// ImageMetadataAll mocks base method
+func (m *MockImageMetadataProvider) ImageMetadataAll() ([]datastore.ImageMetaData, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ImageMetadataAll")
+	ret0, _ := ret[0].([]datastore.ImageMetaData)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ImageMetadataAll indicates an expected call of ImageMetadataAll
+func (mr *MockImageMetadataProviderMockRecorder) ImageMetadataAll() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImageMetadataAll", reflect.TypeOf((*MockImageMetadataProvider)(nil).ImageMetadataAll))
+}
+
+// ImageMetadataByStrongSum mocks base method
+func (m *MockImageMetadataProvider) ImageMetadataByStrongSum(arg0 string) ([]datastore.ImageMetaData, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ImageMetadataByStrongSum", arg0)
+	ret0, _ := ret[0].([]datastore.ImageMetaData)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ImageMetadataByStrongSum indicates an expected call of ImageMetadataByStrongSum
+func (mr *MockImageMetadataProviderMockRecorder) ImageMetadataByStrongSum(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImageMetadataByStrongSum", reflect.TypeOf((*MockImageMetadataProvider)(nil).ImageMetadataByStrongSum), arg0)
+}
+
+// ImageMetadataByVerification mocks base method
+func (m *MockImageMetadataProvider) ImageMetadataByVerification(arg0, arg1 int) ([]datastore.ImageMetaData, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ImageMetadataByVerification", arg0, arg1)
+	ret0, _ := ret[0].([]datastore.ImageMetaData)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ImageMetadataByVerification indicates an expected call of ImageMetadataByVerification
+func (mr *MockImageMetadataProviderMockRecorder) ImageMetadataByVerification(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImageMetadataByVerification", reflect.TypeOf((*MockImageMetadataProvider)(nil).ImageMetadataByVerification), arg0, arg1)
+}
+
+// ImageMetadataToDelete mocks base method
+func (m *MockImageMetadataProvider) ImageMetadataToDelete() ([]datastore.ImageMetaData, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ImageMetadataToDelete")
+	ret0, _ := ret[0].([]datastore.ImageMetaData)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ImageMetadataToDelete indicates an expected call of ImageMetadataToDelete
+func (mr *MockImageMetadataProviderMockRecorder) ImageMetadataToDelete() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImageMetadataToDelete", reflect.TypeOf((*MockImageMetadataProvider)(nil).ImageMetadataToDelete))
+}
+
+// ImageMetadataToUpdateInfo mocks base method
+func (m *MockImageMetadataProvider) ImageMetadataToUpdateInfo() ([]datastore.ImageMetaData, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ImageMetadataToUpdateInfo")
+	ret0, _ := ret[0].([]datastore.ImageMetaData)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ImageMetadataToUpdateInfo indicates an expected call of ImageMetadataToUpdateInfo
+func (mr *MockImageMetadataProviderMockRecorder) ImageMetadataToUpdateInfo() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImageMetadataToUpdateInfo", reflect.TypeOf((*MockImageMetadataProvider)(nil).ImageMetadataToUpdateInfo))
+}
+
+// ImageMetadataToUpload mocks base method
+func (m *MockImageMetadataProvider) ImageMetadataToUpload() ([]datastore.ImageMetaData, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ImageMetadataToUpload")
+	ret0, _ := ret[0].([]datastore.ImageMetaData)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ImageMetadataToUpload indicates an expected call of ImageMetadataToUpload
+func (mr *MockImageMetadataProviderMockRecorder) ImageMetadataToUpload() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImageMetadataToUpload", reflect.TypeOf((*MockImageMetadataProvider)(nil).ImageMetadataToUpload))
+}
+
+// MarkImagesSeen mocks base method
+func (m *MockImageMetadataProvider) MarkImagesSeen(arg0 []string, arg1 int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MarkImagesSeen", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MarkImagesSeen indicates an expected call of MarkImagesSeen
+func (mr *MockImageMetadataProviderMockRecorder) MarkImagesSeen(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkImagesSeen", reflect.TypeOf((*MockImageMetadataProvider)(nil).MarkImagesSeen), arg0, arg1)
+}
+
+// MarkUnseenImagesForDeletion mocks base method
+func (m *MockImageMetadataProvider) MarkUnseenImagesForDeletion(arg0 int64) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MarkUnseenImagesForDeletion", arg0)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MarkUnseenImagesForDeletion indicates an expected call of MarkUnseenImagesForDeletion
+func (mr *MockImageMetadataProviderMockRecorder) MarkUnseenImagesForDeletion(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkUnseenImagesForDeletion", reflect.TypeOf((*MockImageMetadataProvider)(nil).MarkUnseenImagesForDeletion), arg0)
+}
+
+// SaveImageMetadata mocks base method
+func (m *MockImageMetadataProvider) SaveImageMetadata(arg0 datastore.ImageMetaData) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveImageMetadata", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveImageMetadata indicates an expected call of SaveImageMetadata
+func (mr *MockImageMetadataProviderMockRecorder) SaveImageMetadata(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveImageMetadata", reflect.TypeOf((*MockImageMetadataProvider)(nil).SaveImageMetadata), arg0)
+}
+
+// SavePiwigoIdAndUpdateUploadFlag mocks base method
+func (m *MockImageMetadataProvider) SavePiwigoIdAndUpdateUploadFlag(arg0 string, arg1 int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SavePiwigoIdAndUpdateUploadFlag", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SavePiwigoIdAndUpdateUploadFlag indicates an expected call of SavePiwigoIdAndUpdateUploadFlag
+func (mr *MockImageMetadataProviderMockRecorder) SavePiwigoIdAndUpdateUploadFlag(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SavePiwigoIdAndUpdateUploadFlag", reflect.TypeOf((*MockImageMetadataProvider)(nil).SavePiwigoIdAndUpdateUploadFlag), arg0, arg1)
+}
@@ -48,6 +48,20 @@ func (mr *MockCategoryApiMockRecorder) CreateCategory(arg0, arg1 interface{}) *g
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateCategory", reflect.TypeOf((*MockCategoryApi)(nil).CreateCategory), arg0, arg1)
 }
 
+// DeleteCategory mocks base method
+func (m *MockCategoryApi) DeleteCategory(arg0 int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteCategory", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteCategory indicates an expected call of DeleteCategory
+func (mr *MockCategoryApiMockRecorder) DeleteCategory(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteCategory", reflect.TypeOf((*MockCategoryApi)(nil).DeleteCategory), arg0)
+}
+
 // GetAllCategories mocks base method
 func (m *MockCategoryApi) GetAllCategories() (map[string]*piwigo.Category, error) {
 	m.ctrl.T.Helper()
@@ -63,6 +77,20 @@ func (mr *MockCategoryApiMockRecorder) GetAllCategories() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllCategories", reflect.TypeOf((*MockCategoryApi)(nil).GetAllCategories))
 }
 
+// UpdateCategorySettings mocks base method
+func (m *MockCategoryApi) UpdateCategorySettings(arg0 int, arg1 piwigo.CategorySettings) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateCategorySettings", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateCategorySettings indicates an expected call of UpdateCategorySettings
+func (mr *MockCategoryApiMockRecorder) UpdateCategorySettings(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateCategorySettings", reflect.TypeOf((*MockCategoryApi)(nil).UpdateCategorySettings), arg0, arg1)
+}
+
 // MockImageApi is a mock of ImageApi interface
 type MockImageApi struct {
 	ctrl     *gomock.Controller
@@ -115,6 +143,21 @@ func (mr *MockImageApiMockRecorder) ImageCheckFile(arg0, arg1 interface{}) *gomo
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImageCheckFile", reflect.TypeOf((*MockImageApi)(nil).ImageCheckFile), arg0, arg1)
 }
 
+// ImageFileSize mocks base method
+func (m *MockImageApi) ImageFileSize(arg0 int) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ImageFileSize", arg0)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ImageFileSize indicates an expected call of ImageFileSize
+func (mr *MockImageApiMockRecorder) ImageFileSize(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImageFileSize", reflect.TypeOf((*MockImageApi)(nil).ImageFileSize), arg0)
+}
+
 // ImagesExistOnPiwigo mocks base method
 func (m *MockImageApi) ImagesExistOnPiwigo(arg0 []string) (map[string]int, error) {
 	m.ctrl.T.Helper()
@@ -130,6 +173,49 @@ func (mr *MockImageApiMockRecorder) ImagesExistOnPiwigo(arg0 interface{}) *gomoc
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImagesExistOnPiwigo", reflect.TypeOf((*MockImageApi)(nil).ImagesExistOnPiwigo), arg0)
 }
 
+// ImagesOfCategory mocks base method
+func (m *MockImageApi) ImagesOfCategory(arg0 int) ([]piwigo.Image, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ImagesOfCategory", arg0)
+	ret0, _ := ret[0].([]piwigo.Image)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ImagesOfCategory indicates an expected call of ImagesOfCategory
+func (mr *MockImageApiMockRecorder) ImagesOfCategory(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImagesOfCategory", reflect.TypeOf((*MockImageApi)(nil).ImagesOfCategory), arg0)
+}
+
+// SetImageCategory mocks base method
+func (m *MockImageApi) SetImageCategory(arg0, arg1 int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetImageCategory", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetImageCategory indicates an expected call of SetImageCategory
+func (mr *MockImageApiMockRecorder) SetImageCategory(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetImageCategory", reflect.TypeOf((*MockImageApi)(nil).SetImageCategory), arg0, arg1)
+}
+
+// SetImageTags mocks base method
+func (m *MockImageApi) SetImageTags(arg0 int, arg1 []string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetImageTags", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetImageTags indicates an expected call of SetImageTags
+func (mr *MockImageApiMockRecorder) SetImageTags(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetImageTags", reflect.TypeOf((*MockImageApi)(nil).SetImageTags), arg0, arg1)
+}
+
 // UploadImage mocks base method
 func (m *MockImageApi) UploadImage(arg0 int, arg1, arg2 string, arg3 int) (int, error) {
 	m.ctrl.T.Helper()
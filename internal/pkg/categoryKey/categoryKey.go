@@ -0,0 +1,70 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+// Package categoryKey builds the keys that identify a category by the names of all its parents.
+// Every name is escaped before it gets joined with the separator, so album names containing slashes
+// or other path separators do not change the hierarchy represented by a key.
+package categoryKey
+
+import (
+	"os"
+	"strings"
+)
+
+const Separator = "/"
+
+var escaper = strings.NewReplacer("%", "%25", "/", "%2F")
+var unescaper = strings.NewReplacer("%25", "%", "%2F", "/", "%2f", "/")
+
+// Join builds the key of a category using the names of all parents beginning at the root.
+func Join(names ...string) string {
+	escaped := make([]string, 0, len(names))
+	for _, name := range names {
+		escaped = append(escaped, escaper.Replace(name))
+	}
+	return strings.Join(escaped, Separator)
+}
+
+// Append adds a child category to the given parent key. An empty parent key represents the root.
+func Append(parentKey string, name string) string {
+	if parentKey == "" {
+		return Join(name)
+	}
+	return parentKey + Separator + Join(name)
+}
+
+// Split returns the unescaped names of all categories that build the given key.
+func Split(key string) []string {
+	if key == "" {
+		return nil
+	}
+	parts := strings.Split(key, Separator)
+	for i, part := range parts {
+		parts[i] = unescaper.Replace(part)
+	}
+	return parts
+}
+
+// Parent returns the key of the parent category or an empty string if the key represents a root category.
+func Parent(key string) string {
+	i := strings.LastIndex(key, Separator)
+	if i < 0 {
+		return ""
+	}
+	return key[:i]
+}
+
+// Name returns the unescaped name of the last category of the key.
+func Name(key string) string {
+	return unescaper.Replace(key[strings.LastIndex(key, Separator)+1:])
+}
+
+// FromPath converts a relative path of the local filesystem to a key. Every directory is used as one category.
+func FromPath(relativePath string) string {
+	if relativePath == "" || relativePath == "." {
+		return ""
+	}
+	return Join(strings.Split(relativePath, string(os.PathSeparator))...)
+}
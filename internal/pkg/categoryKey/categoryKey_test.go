@@ -0,0 +1,57 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package categoryKey
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_Join_keeps_plain_names_readable(t *testing.T) {
+	key := Join("2019", "Event 1")
+	if key != "2019/Event 1" {
+		t.Errorf("Got key %s but expected 2019/Event 1", key)
+	}
+}
+
+func Test_Join_escapes_separators_within_names(t *testing.T) {
+	key := Join("Concerts", "AC/DC 2019", "100%")
+	if key != "Concerts/AC%2FDC 2019/100%25" {
+		t.Errorf("Got unexpected key %s", key)
+	}
+
+	names := Split(key)
+	expected := []string{"Concerts", "AC/DC 2019", "100%"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Split returned %v but expected %v", names, expected)
+	}
+}
+
+func Test_Parent_and_Name(t *testing.T) {
+	key := Append(Join("Concerts"), "AC/DC 2019")
+
+	if parent := Parent(key); parent != "Concerts" {
+		t.Errorf("Got parent %s but expected Concerts", parent)
+	}
+	if name := Name(key); name != "AC/DC 2019" {
+		t.Errorf("Got name %s but expected AC/DC 2019", name)
+	}
+	if parent := Parent("Concerts"); parent != "" {
+		t.Errorf("A root key should not have a parent but got %s", parent)
+	}
+}
+
+func Test_FromPath_uses_directories_as_categories(t *testing.T) {
+	key := FromPath(filepath.Join("2019", "50% off", "img.jpg"))
+	if key != "2019/50%25 off/img.jpg" {
+		t.Errorf("Got unexpected key %s", key)
+	}
+
+	if key := FromPath("."); key != "" {
+		t.Errorf("Got key %s for the root path but expected an empty key", key)
+	}
+}
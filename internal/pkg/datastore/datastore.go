@@ -7,8 +7,10 @@ package datastore
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/categoryKey"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/sirupsen/logrus"
 	"time"
@@ -16,58 +18,156 @@ import (
 
 var ErrorRecordNotFound = errors.New("record not found")
 
+// DefaultTarget is the name of the piwigo target used if no other target is configured.
+const DefaultTarget = "default"
+
 type CategoryData struct {
-	CategoryId     int
-	PiwigoId       int
-	PiwigoParentId int
-	Name           string
-	Key            string
+	CategoryId         int
+	PiwigoId           int
+	PiwigoParentId     int
+	Name               string
+	Key                string
+	Description        string
+	Status             string
+	UserIds            string
+	GroupIds           string
+	CoverImagePath     string
+	Rank               int
+	InfoUpdateRequired bool
+	DeleteRequired     bool
 }
 
 func (cat *CategoryData) String() string {
-	return fmt.Sprintf("CategoryData{CategoryId:%d, PiwigoId:%d, PiwigoParentId:%d, Name:%s, Key:%s}", cat.CategoryId, cat.PiwigoId, cat.PiwigoParentId, cat.Name, cat.Key)
+	return fmt.Sprintf("CategoryData{CategoryId:%d, PiwigoId:%d, PiwigoParentId:%d, Name:%s, Key:%s, Status:%s, InfoUpdateRequired:%t, DeleteRequired:%t}", cat.CategoryId, cat.PiwigoId, cat.PiwigoParentId, cat.Name, cat.Key, cat.Status, cat.InfoUpdateRequired, cat.DeleteRequired)
 }
 
 type ImageMetaData struct {
-	ImageId          int
-	PiwigoId         int
-	FullImagePath    string
-	Filename         string
-	Md5Sum           string
-	LastChange       time.Time
-	CategoryPath     string
-	CategoryPiwigoId int
-	UploadRequired   bool
-	DeleteRequired   bool
+	ImageId            int
+	PiwigoId           int
+	FullImagePath      string
+	Filename           string
+	Md5Sum             string
+	LastChange         time.Time
+	CategoryPath       string
+	CategoryPiwigoId   int
+	UploadRequired     bool
+	DeleteRequired     bool
+	Tags               string
+	SkipUpload         bool
+	InfoUpdateRequired bool
+	// InvalidReason is set if the file is not a valid image. Invalid files are not uploaded until they change.
+	InvalidReason string
+	// The metadata read from the file and its sidecar. MetadataChange and SidecarChange are the modification times
+	// of the file and the sidecar the metadata was read from.
+	Title          string
+	Description    string
+	Author         string
+	Keywords       string
+	Rating         int
+	CaptureDate    time.Time
+	HasLocation    bool
+	Latitude       float64
+	Longitude      float64
+	MetadataChange time.Time
+	SidecarChange  time.Time
+	// StrongSum is the collision resistant checksum used to find moved and duplicated files. It is empty if the
+	// file was hashed without a strong hash configured.
+	StrongSum string
+	// The fingerprint of the file at the time it was checked the last time, together with LastChange. FileId and
+	// ChangeTime are empty if they were not known.
+	FileSize   int64
+	FileId     string
+	ChangeTime time.Time
+	// Verified is the time the checksums were compared with the file the last time by the scrub. Corrupted is set if
+	// the file did not match although it was not modified, it is not uploaded until that changes.
+	Verified  time.Time
+	Corrupted bool
+	// CategoryUpdateRequired is set if the file was moved to another album. The image on piwigo is moved to the
+	// category CategoryPiwigoId together with the info update, as uploading only adds categories.
+	CategoryUpdateRequired bool
 }
 
 func (img *ImageMetaData) String() string {
-	return fmt.Sprintf("ImageMetaData{ImageId:%d, PiwigoId:%d, CategoryPiwigoId:%d, RelPath:%s, File:%s, Md5:%s, Change:%sS, catpath:%s, UploadRequired: %t, DeleteRequired: %t}", img.ImageId, img.PiwigoId, img.CategoryPiwigoId, img.FullImagePath, img.Filename, img.Md5Sum, img.LastChange.String(), img.CategoryPath, img.UploadRequired, img.DeleteRequired)
+	return fmt.Sprintf("ImageMetaData{ImageId:%d, PiwigoId:%d, CategoryPiwigoId:%d, RelPath:%s, File:%s, Md5:%s, Change:%sS, catpath:%s, UploadRequired: %t, DeleteRequired: %t, Tags: %s, SkipUpload: %t, InfoUpdateRequired: %t, CategoryUpdateRequired: %t, InvalidReason: %s, Title: %s}", img.ImageId, img.PiwigoId, img.CategoryPiwigoId, img.FullImagePath, img.Filename, img.Md5Sum, img.LastChange.String(), img.CategoryPath, img.UploadRequired, img.DeleteRequired, img.Tags, img.SkipUpload, img.InfoUpdateRequired, img.CategoryUpdateRequired, img.InvalidReason, img.Title)
+}
+
+// DirectoryData is the listing of a local directory at the time it had the given modification time. The directories
+// do not belong to a target as the local scan is shared between all of them.
+type DirectoryData struct {
+	Path    string
+	ModTime time.Time
+	Entries []DirectoryEntry
+	ScanId  int64
+}
+
+type DirectoryEntry struct {
+	Name       string
+	IsDir      bool
+	Size       int64
+	ModTime    time.Time
+	FileId     string
+	ChangeTime time.Time
+}
+
+// ScanData records a local scan that completed successfully.
+type ScanData struct {
+	ScanId    int64
+	FullScan  bool
+	Completed time.Time
 }
 
 type CategoryProvider interface {
 	SaveCategory(category CategoryData) error
 	GetCategoryByPiwigoId(piwigoId int) (CategoryData, error)
 	GetCategoryByKey(key string) (CategoryData, error)
-	GetCategoriesToCreate() ([]CategoryData, error)
+	GetCategoriesToUpdate() ([]CategoryData, error)
+	GetCategoriesToDelete() ([]CategoryData, error)
+	MarkEmptyCategoriesForDeletion() (int64, error)
+	DeleteCategory(categoryId int) error
 }
 
 type ImageMetadataProvider interface {
 	ImageMetadata(fullImagePath string) (ImageMetaData, error)
+	ImageMetadataByStrongSum(strongSum string) ([]ImageMetaData, error)
+	ImageMetadataByVerification(offset int, count int) ([]ImageMetaData, error)
 	ImageMetadataToUpload() ([]ImageMetaData, error)
 	ImageMetadataToDelete() ([]ImageMetaData, error)
 	ImageMetadataAll() ([]ImageMetaData, error)
+	ImageMetadataToUpdateInfo() ([]ImageMetaData, error)
 	SaveImageMetadata(m ImageMetaData) error
 	SavePiwigoIdAndUpdateUploadFlag(md5Sum string, piwigoId int) error
 	DeleteMarkedImages() error
+	MarkImagesSeen(fullImagePaths []string, scanId int64) error
+	MarkUnseenImagesForDeletion(scanId int64) (int64, error)
+}
+
+type DirectoryProvider interface {
+	DirectoryData(path string) (DirectoryData, error)
+	SaveDirectoryData(directory DirectoryData) error
+	LastCompletedScan() (ScanData, error)
+	LastCompletedFullScan() (ScanData, error)
+	SaveCompletedScan(scan ScanData) error
 }
 
+const imageColumns = "imageId, piwigoId, fullImagePath, fileName, md5sum, lastChanged, categoryPath, categoryPiwigoId, uploadRequired, deleteRequired, tags, skipUpload, infoUpdateRequired, invalidReason, title, description, author, keywords, rating, captureDate, hasLocation, latitude, longitude, metadataChanged, sidecarChanged, strongSum, fileSize, fileId, fileChanged, verified, corrupted, categoryUpdateRequired"
+const categoryColumns = "categoryId, piwigoId, piwigoParentId, name, key, description, status, userIds, groupIds, coverImagePath, rank, infoUpdateRequired, deleteRequired"
+
 type LocalDataStore struct {
 	connectionString string
+	target           string
 }
 
 func NewLocalDataStore() *LocalDataStore {
-	return &LocalDataStore{}
+	return &LocalDataStore{target: DefaultTarget}
+}
+
+// ForTarget returns a data store using the same database but reading and writing only the records
+// that belong to the given piwigo target. This way one local scan is able to feed multiple servers.
+func (d *LocalDataStore) ForTarget(target string) *LocalDataStore {
+	return &LocalDataStore{
+		connectionString: d.connectionString,
+		target:           target,
+	}
 }
 
 func (d *LocalDataStore) Initialize(connectionString string) error {
@@ -76,6 +176,9 @@ func (d *LocalDataStore) Initialize(connectionString string) error {
 	}
 
 	d.connectionString = connectionString
+	if d.target == "" {
+		d.target = DefaultTarget
+	}
 
 	db, err := d.openDatabase()
 	if err != nil {
@@ -98,12 +201,12 @@ func (d *LocalDataStore) ImageMetadata(fullImagePath string) (ImageMetaData, err
 	}
 	defer db.Close()
 
-	stmt, err := db.Prepare("SELECT imageId, piwigoId, fullImagePath, fileName, md5sum, lastChanged, categoryPath, categoryPiwigoId, uploadRequired, deleteRequired FROM image WHERE fullImagePath = ?")
+	stmt, err := db.Prepare("SELECT " + imageColumns + " FROM image WHERE target = ? AND fullImagePath = ?")
 	if err != nil {
 		return img, err
 	}
 
-	rows, err := stmt.Query(fullImagePath)
+	rows, err := stmt.Query(d.target, fullImagePath)
 	if err != nil {
 		return img, err
 	}
@@ -122,6 +225,67 @@ func (d *LocalDataStore) ImageMetadata(fullImagePath string) (ImageMetaData, err
 	return img, err
 }
 
+// ImageMetadataByStrongSum returns the images of the target with the given strong checksum.
+func (d *LocalDataStore) ImageMetadataByStrongSum(strongSum string) ([]ImageMetaData, error) {
+	logrus.Tracef("Query image metadata with the strong checksum %s", strongSum)
+
+	db, err := d.openDatabase()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT "+imageColumns+" FROM image WHERE target = ? AND strongSum = ? order by fullImagePath asc", d.target, strongSum)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var images []ImageMetaData
+	for rows.Next() {
+		img := &ImageMetaData{}
+		err = readImageMetadataFromRow(rows, img)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, *img)
+	}
+	err = rows.Err()
+
+	return images, err
+}
+
+// ImageMetadataByVerification returns the hashed images of the target ordered by the time they were verified the last
+// time. The images with a possibly corrupted file come first, so they are checked again before any other.
+func (d *LocalDataStore) ImageMetadataByVerification(offset int, count int) ([]ImageMetaData, error) {
+	logrus.Tracef("Query %d image metadata by verification starting at %d", count, offset)
+
+	db, err := d.openDatabase()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT "+imageColumns+" FROM image WHERE target = ? AND md5sum != '' AND deleteRequired = 0 AND invalidReason = '' order by corrupted desc, verified asc, imageId asc LIMIT ? OFFSET ?", d.target, count, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var images []ImageMetaData
+	for rows.Next() {
+		img := &ImageMetaData{}
+		err = readImageMetadataFromRow(rows, img)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, *img)
+	}
+	err = rows.Err()
+
+	return images, err
+}
+
 func (d *LocalDataStore) ImageMetadataAll() ([]ImageMetaData, error) {
 	logrus.Tracef("Query all image metadata that represent files on the disk")
 
@@ -131,7 +295,7 @@ func (d *LocalDataStore) ImageMetadataAll() ([]ImageMetaData, error) {
 	}
 	defer db.Close()
 
-	rows, err := db.Query("SELECT imageId, piwigoId, fullImagePath, fileName, md5sum, lastChanged, categoryPath, categoryPiwigoId, uploadRequired, deleteRequired FROM image")
+	rows, err := db.Query("SELECT "+imageColumns+" FROM image WHERE target = ?", d.target)
 	if err != nil {
 		return nil, err
 	}
@@ -160,7 +324,7 @@ func (d *LocalDataStore) ImageMetadataToDelete() ([]ImageMetaData, error) {
 	}
 	defer db.Close()
 
-	rows, err := db.Query("SELECT imageId, piwigoId, fullImagePath, fileName, md5sum, lastChanged, categoryPath, categoryPiwigoId, uploadRequired, deleteRequired FROM image WHERE deleteRequired = 1")
+	rows, err := db.Query("SELECT "+imageColumns+" FROM image WHERE target = ? AND deleteRequired = 1", d.target)
 	if err != nil {
 		return nil, err
 	}
@@ -189,7 +353,36 @@ func (d *LocalDataStore) ImageMetadataToUpload() ([]ImageMetaData, error) {
 	}
 	defer db.Close()
 
-	rows, err := db.Query("SELECT imageId, piwigoId, fullImagePath, fileName, md5sum, lastChanged, categoryPath, categoryPiwigoId, uploadRequired, deleteRequired FROM image WHERE uploadRequired = 1 and deleteRequired = 0 order by fullImagePath asc")
+	rows, err := db.Query("SELECT "+imageColumns+" FROM image WHERE target = ? AND uploadRequired = 1 and deleteRequired = 0 AND invalidReason = '' AND corrupted = 0 order by fullImagePath asc", d.target)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var images []ImageMetaData
+	for rows.Next() {
+		img := &ImageMetaData{}
+		err = readImageMetadataFromRow(rows, img)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, *img)
+	}
+	err = rows.Err()
+
+	return images, err
+}
+
+func (d *LocalDataStore) ImageMetadataToUpdateInfo() ([]ImageMetaData, error) {
+	logrus.Tracef("Query all image metadata of uploaded images with changed information")
+
+	db, err := d.openDatabase()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT "+imageColumns+" FROM image WHERE target = ? AND (infoUpdateRequired = 1 OR categoryUpdateRequired = 1) AND piwigoId > 0 AND deleteRequired = 0 order by fullImagePath asc", d.target)
 	if err != nil {
 		return nil, err
 	}
@@ -259,12 +452,12 @@ func (d *LocalDataStore) SavePiwigoIdAndUpdateUploadFlag(md5Sum string, piwigoId
 		uploadRequired = 0
 	}
 
-	stmt, err := tx.Prepare("UPDATE image SET piwigoId = ?, uploadRequired = ? WHERE md5sum = ?")
+	stmt, err := tx.Prepare("UPDATE image SET piwigoId = ?, uploadRequired = ? WHERE target = ? AND md5sum = ?")
 	if err != nil {
 		return err
 	}
 
-	_, err = stmt.Exec(piwigoId, uploadRequired, md5Sum)
+	_, err = stmt.Exec(piwigoId, uploadRequired, d.target, md5Sum)
 	if err != nil {
 		logrus.Errorf("Rolling back transaction for piwigo id update of file %s", md5Sum)
 		errTx := tx.Rollback()
@@ -291,7 +484,7 @@ func (d *LocalDataStore) DeleteMarkedImages() error {
 		return err
 	}
 
-	_, err = tx.Exec("DELETE FROM image WHERE deleteRequired = 1")
+	_, err = tx.Exec("DELETE FROM image WHERE target = ? AND deleteRequired = 1", d.target)
 	if err != nil {
 		logrus.Errorf("Rolling back transaction of deleting marked images")
 		errTx := tx.Rollback()
@@ -305,6 +498,58 @@ func (d *LocalDataStore) DeleteMarkedImages() error {
 	return tx.Commit()
 }
 
+// Marks the images as found by the scan with the given id.
+func (d *LocalDataStore) MarkImagesSeen(fullImagePaths []string, scanId int64) error {
+	logrus.Tracef("Marking %d images as seen by scan %d", len(fullImagePaths), scanId)
+	db, err := d.openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare("UPDATE image SET lastScan = ? WHERE target = ? AND fullImagePath = ?")
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	for _, fullImagePath := range fullImagePaths {
+		_, err = stmt.Exec(scanId, d.target, fullImagePath)
+		if err != nil {
+			logrus.Errorf("Rolling back transaction of marking images as seen")
+			errTx := tx.Rollback()
+			if errTx != nil {
+				logrus.Errorf("Rollback of transaction of marking images as seen failed!")
+			}
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Marks all images that were not found by the scan with the given id for deletion and returns their number.
+func (d *LocalDataStore) MarkUnseenImagesForDeletion(scanId int64) (int64, error) {
+	logrus.Tracef("Marking images not seen by scan %d for deletion", scanId)
+	db, err := d.openDatabase()
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	result, err := db.Exec("UPDATE image SET uploadRequired = 0, deleteRequired = 1 WHERE target = ? AND lastScan <> ? AND deleteRequired = 0", d.target, scanId)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 func (d *LocalDataStore) SaveCategory(category CategoryData) error {
 	logrus.Tracef("Saving category: %s", category.String())
 	db, err := d.openDatabase()
@@ -347,12 +592,12 @@ func (d *LocalDataStore) GetCategoryByPiwigoId(piwigoId int) (CategoryData, erro
 	}
 	defer db.Close()
 
-	stmt, err := db.Prepare("SELECT categoryId, piwigoId, piwigoParentId, name, key FROM category WHERE piwigoId = ?")
+	stmt, err := db.Prepare("SELECT " + categoryColumns + " FROM category WHERE target = ? AND piwigoId = ?")
 	if err != nil {
 		return cat, err
 	}
 
-	rows, err := stmt.Query(piwigoId)
+	rows, err := stmt.Query(d.target, piwigoId)
 	if err != nil {
 		return cat, err
 	}
@@ -381,12 +626,12 @@ func (d *LocalDataStore) GetCategoryByKey(key string) (CategoryData, error) {
 	}
 	defer db.Close()
 
-	stmt, err := db.Prepare("SELECT categoryId, piwigoId, piwigoParentId, name, key FROM category WHERE key = ?")
+	stmt, err := db.Prepare("SELECT " + categoryColumns + " FROM category WHERE target = ? AND key = ?")
 	if err != nil {
 		return cat, err
 	}
 
-	rows, err := stmt.Query(key)
+	rows, err := stmt.Query(d.target, key)
 	if err != nil {
 		return cat, err
 	}
@@ -405,8 +650,8 @@ func (d *LocalDataStore) GetCategoryByKey(key string) (CategoryData, error) {
 	return cat, err
 }
 
-func (d *LocalDataStore) GetCategoriesToCreate() ([]CategoryData, error) {
-	logrus.Trace("Query categories to create on piwigo")
+func (d *LocalDataStore) GetCategoriesToUpdate() ([]CategoryData, error) {
+	logrus.Trace("Query categories with changed information to update on piwigo")
 
 	db, err := d.openDatabase()
 	if err != nil {
@@ -414,12 +659,12 @@ func (d *LocalDataStore) GetCategoriesToCreate() ([]CategoryData, error) {
 	}
 	defer db.Close()
 
-	stmt, err := db.Prepare("SELECT categoryId, piwigoId, piwigoParentId, name, key FROM category WHERE piwigoId = 0 ORDER BY key")
+	stmt, err := db.Prepare("SELECT " + categoryColumns + " FROM category WHERE target = ? AND piwigoId > 0 AND infoUpdateRequired = 1 ORDER BY key")
 	if err != nil {
 		return nil, err
 	}
 
-	rows, err := stmt.Query()
+	rows, err := stmt.Query(d.target)
 	if err != nil {
 		return nil, err
 	}
@@ -439,56 +684,256 @@ func (d *LocalDataStore) GetCategoriesToCreate() ([]CategoryData, error) {
 	return categories, err
 }
 
-func (d *LocalDataStore) openDatabase() (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", d.connectionString)
+// Returns the categories marked for deletion. Sub categories are returned before their parents.
+func (d *LocalDataStore) GetCategoriesToDelete() ([]CategoryData, error) {
+	logrus.Trace("Query categories to delete on piwigo")
+
+	db, err := d.openDatabase()
 	if err != nil {
-		logrus.Warnf("Could not open database %s", d.connectionString)
 		return nil, err
 	}
-	db.SetMaxOpenConns(1)
+	defer db.Close()
 
-	return db, err
+	rows, err := db.Query("SELECT "+categoryColumns+" FROM category WHERE target = ? AND deleteRequired = 1 ORDER BY key DESC", d.target)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var categories []CategoryData
+	for rows.Next() {
+		cat := CategoryData{}
+		err = readCategoryFromRow(rows, &cat)
+		if err != nil {
+			return nil, err
+		}
+		categories = append(categories, cat)
+	}
+	err = rows.Err()
+
+	return categories, err
 }
 
-func (d *LocalDataStore) createTablesIfNeeded(db *sql.DB) error {
-	_, err := db.Exec("CREATE TABLE IF NOT EXISTS image (" +
-		"imageId INTEGER PRIMARY KEY," +
-		"piwigoId INTEGER NULL," +
-		"fullImagePath NVARCHAR(1000) NOT NULL," +
-		"fileName NVARCHAR(255) NOT NULL," +
-		"md5sum NVARCHAR(50) NOT NULL," +
-		"lastChanged DATETIME NOT NULL," +
-		"categoryPath NVARCHAR(1000) NOT NULL," +
-		"categoryPiwigoId INTEGER NULL," +
-		"uploadRequired BIT NOT NULL," +
-		"deleteRequired BIT NOT NULL" +
-		");")
+// Marks the categories that contained images which are all gone now for deletion and returns their number.
+// Categories that never contained any image are left alone, as they might be managed on piwigo. A category
+// gets unmarked as soon as its tree contains an image again.
+func (d *LocalDataStore) MarkEmptyCategoriesForDeletion() (int64, error) {
+	logrus.Trace("Marking empty categories for deletion")
+
+	db, err := d.openDatabase()
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	usedKeys, err := d.categoryKeysWithImages(db, "")
+	if err != nil {
+		return 0, err
+	}
+	// images already on piwigo are kept even if they are no longer uploaded
+	activeKeys, err := d.categoryKeysWithImages(db, "AND deleteRequired = 0 AND (piwigoId > 0 OR (skipUpload = 0 AND invalidReason = ''))")
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := db.Query("SELECT categoryId, key, deleteRequired FROM category WHERE target = ?", d.target)
+	if err != nil {
+		return 0, err
+	}
+	changes := make(map[int]bool)
+	var marked int64
+	for rows.Next() {
+		var categoryId int
+		var key string
+		var deleteRequired bool
+		err = rows.Scan(&categoryId, &key, &deleteRequired)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		_, used := usedKeys[key]
+		_, active := activeKeys[key]
+		empty := (used || deleteRequired) && !active
+		if empty {
+			marked++
+		}
+		if empty != deleteRequired {
+			changes[categoryId] = empty
+		}
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	for categoryId, empty := range changes {
+		_, err = tx.Exec("UPDATE category SET deleteRequired = ? WHERE categoryId = ? AND target = ?", empty, categoryId, d.target)
+		if err != nil {
+			_ = tx.Rollback()
+			return 0, err
+		}
+	}
+	return marked, tx.Commit()
+}
+
+// Returns the keys of the categories containing at least one matching image directly or within a sub category.
+func (d *LocalDataStore) categoryKeysWithImages(db *sql.DB, condition string) (map[string]struct{}, error) {
+	rows, err := db.Query("SELECT DISTINCT categoryPath FROM image WHERE target = ? "+condition, d.target)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make(map[string]struct{})
+	for rows.Next() {
+		var categoryPath string
+		err = rows.Scan(&categoryPath)
+		if err != nil {
+			return nil, err
+		}
+		for key := categoryPath; key != ""; key = categoryKey.Parent(key) {
+			if _, exists := keys[key]; exists {
+				break
+			}
+			keys[key] = struct{}{}
+		}
+	}
+	return keys, rows.Err()
+}
+
+func (d *LocalDataStore) DeleteCategory(categoryId int) error {
+	logrus.Tracef("Deleting category %d", categoryId)
+
+	db, err := d.openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec("DELETE FROM category WHERE categoryId = ? AND target = ?", categoryId, d.target)
+	return err
+}
+
+// Returns the stored listing of the directory. A listing with a broken list of entries is reported as not found, so
+// the directory gets listed again.
+func (d *LocalDataStore) DirectoryData(path string) (DirectoryData, error) {
+	logrus.Tracef("Query directory %s", path)
+	directory := DirectoryData{}
+
+	db, err := d.openDatabase()
+	if err != nil {
+		return directory, err
+	}
+	defer db.Close()
+
+	var modTime int64
+	var entryCount int
+	var entries string
+	err = db.QueryRow("SELECT path, modTime, entryCount, entries, scanId FROM directory WHERE path = ?", path).Scan(&directory.Path, &modTime, &entryCount, &entries, &directory.ScanId)
+	if err == sql.ErrNoRows {
+		return directory, ErrorRecordNotFound
+	}
+	if err != nil {
+		return directory, err
+	}
+
+	err = json.Unmarshal([]byte(entries), &directory.Entries)
+	if err != nil || len(directory.Entries) != entryCount {
+		logrus.Warnf("Ignoring the stored entries of directory %s as they are broken", path)
+		return DirectoryData{}, ErrorRecordNotFound
+	}
+
+	directory.ModTime = time.Unix(0, modTime)
+	return directory, nil
+}
+
+func (d *LocalDataStore) SaveDirectoryData(directory DirectoryData) error {
+	logrus.Tracef("Saving directory %s with %d entries", directory.Path, len(directory.Entries))
+
+	entries, err := json.Marshal(directory.Entries)
 	if err != nil {
 		return err
 	}
 
-	_, err = db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS UX_ImageFullImagePath ON image (fullImagePath);")
+	db, err := d.openDatabase()
 	if err != nil {
 		return err
 	}
+	defer db.Close()
+
+	_, err = db.Exec("INSERT OR REPLACE INTO directory (path, modTime, entryCount, entries, scanId) VALUES (?,?,?,?,?)",
+		directory.Path, directory.ModTime.UnixNano(), len(directory.Entries), string(entries), directory.ScanId)
+	return err
+}
+
+func (d *LocalDataStore) LastCompletedScan() (ScanData, error) {
+	return d.lastCompletedScan("SELECT scanId, fullScan, completed FROM scan ORDER BY scanId DESC LIMIT 1")
+}
+
+func (d *LocalDataStore) LastCompletedFullScan() (ScanData, error) {
+	return d.lastCompletedScan("SELECT scanId, fullScan, completed FROM scan WHERE fullScan = 1 ORDER BY scanId DESC LIMIT 1")
+}
+
+func (d *LocalDataStore) lastCompletedScan(query string) (ScanData, error) {
+	scan := ScanData{}
+
+	db, err := d.openDatabase()
+	if err != nil {
+		return scan, err
+	}
+	defer db.Close()
+
+	err = db.QueryRow(query).Scan(&scan.ScanId, &scan.FullScan, &scan.Completed)
+	if err == sql.ErrNoRows {
+		return scan, ErrorRecordNotFound
+	}
+	return scan, err
+}
 
-	_, err = db.Exec("CREATE TABLE IF NOT EXISTS category (" +
-		"categoryId INTEGER PRIMARY KEY," +
-		"piwigoId INTEGER NULL," +
-		"piwigoParentId INTEGER NULL," +
-		"name NVARCHAR(255) NOT NULL," +
-		"key NVARCHAR(1000) NOT NULL" +
-		");")
+func (d *LocalDataStore) SaveCompletedScan(scan ScanData) error {
+	logrus.Tracef("Saving completed scan %d", scan.ScanId)
+	db, err := d.openDatabase()
 	if err != nil {
 		return err
 	}
+	defer db.Close()
+
+	_, err = db.Exec("INSERT INTO scan (scanId, fullScan, completed) VALUES (?,?,?)", scan.ScanId, scan.FullScan, scan.Completed)
+	return err
+}
+
+func (d *LocalDataStore) openDatabase() (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", d.connectionString)
+	if err != nil {
+		logrus.Warnf("Could not open database %s", d.connectionString)
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
 
-	_, err = db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS UX_Category_Key ON category (key);")
+	return db, err
+}
+
+func (d *LocalDataStore) createTablesIfNeeded(db *sql.DB) error {
+	version, err := schemaVersion(db)
 	if err != nil {
 		return err
 	}
 
-	_, err = db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS UX_Category_PiwigoId ON category (piwigoId) WHERE piwigoId > 0;")
+	// the initial schema is only created for new databases. Everything else is handled by the migrations.
+	if version == 0 {
+		err = createInitialSchema(db)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = migrateSchema(db, version)
 	if err != nil {
 		return err
 	}
@@ -498,47 +943,47 @@ func (d *LocalDataStore) createTablesIfNeeded(db *sql.DB) error {
 }
 
 func readImageMetadataFromRow(rows *sql.Rows, img *ImageMetaData) error {
-	err := rows.Scan(&img.ImageId, &img.PiwigoId, &img.FullImagePath, &img.Filename, &img.Md5Sum, &img.LastChange, &img.CategoryPath, &img.CategoryPiwigoId, &img.UploadRequired, &img.DeleteRequired)
+	err := rows.Scan(&img.ImageId, &img.PiwigoId, &img.FullImagePath, &img.Filename, &img.Md5Sum, &img.LastChange, &img.CategoryPath, &img.CategoryPiwigoId, &img.UploadRequired, &img.DeleteRequired, &img.Tags, &img.SkipUpload, &img.InfoUpdateRequired, &img.InvalidReason, &img.Title, &img.Description, &img.Author, &img.Keywords, &img.Rating, &img.CaptureDate, &img.HasLocation, &img.Latitude, &img.Longitude, &img.MetadataChange, &img.SidecarChange, &img.StrongSum, &img.FileSize, &img.FileId, &img.ChangeTime, &img.Verified, &img.Corrupted, &img.CategoryUpdateRequired)
 	return err
 }
 
 func (d *LocalDataStore) insertImageMetaData(tx *sql.Tx, data ImageMetaData) error {
-	stmt, err := tx.Prepare("INSERT INTO image (piwigoId, fullImagePath, fileName, md5sum, lastChanged, categoryPath, categoryPiwigoId, uploadRequired, deleteRequired) VALUES (?,?,?,?,?,?,?,?,?)")
+	stmt, err := tx.Prepare("INSERT INTO image (target, piwigoId, fullImagePath, fileName, md5sum, lastChanged, categoryPath, categoryPiwigoId, uploadRequired, deleteRequired, tags, skipUpload, infoUpdateRequired, invalidReason, title, description, author, keywords, rating, captureDate, hasLocation, latitude, longitude, metadataChanged, sidecarChanged, strongSum, fileSize, fileId, fileChanged, verified, corrupted, categoryUpdateRequired) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)")
 	if err != nil {
 		return err
 	}
-	_, err = stmt.Exec(data.PiwigoId, data.FullImagePath, data.Filename, data.Md5Sum, data.LastChange, data.CategoryPath, data.CategoryPiwigoId, data.UploadRequired, data.DeleteRequired)
+	_, err = stmt.Exec(d.target, data.PiwigoId, data.FullImagePath, data.Filename, data.Md5Sum, data.LastChange, data.CategoryPath, data.CategoryPiwigoId, data.UploadRequired, data.DeleteRequired, data.Tags, data.SkipUpload, data.InfoUpdateRequired, data.InvalidReason, data.Title, data.Description, data.Author, data.Keywords, data.Rating, data.CaptureDate, data.HasLocation, data.Latitude, data.Longitude, data.MetadataChange, data.SidecarChange, data.StrongSum, data.FileSize, data.FileId, data.ChangeTime, data.Verified, data.Corrupted, data.CategoryUpdateRequired)
 	return err
 }
 
 func (d *LocalDataStore) updateImageMetaData(tx *sql.Tx, data ImageMetaData) error {
-	stmt, err := tx.Prepare("UPDATE image SET piwigoId = ?, fullImagePath = ?, fileName = ?, md5sum = ?, lastChanged = ?, categoryPath = ?, categoryPiwigoId = ?, uploadRequired = ?, deleteRequired = ? WHERE imageId = ?")
+	stmt, err := tx.Prepare("UPDATE image SET piwigoId = ?, fullImagePath = ?, fileName = ?, md5sum = ?, lastChanged = ?, categoryPath = ?, categoryPiwigoId = ?, uploadRequired = ?, deleteRequired = ?, tags = ?, skipUpload = ?, infoUpdateRequired = ?, invalidReason = ?, title = ?, description = ?, author = ?, keywords = ?, rating = ?, captureDate = ?, hasLocation = ?, latitude = ?, longitude = ?, metadataChanged = ?, sidecarChanged = ?, strongSum = ?, fileSize = ?, fileId = ?, fileChanged = ?, verified = ?, corrupted = ?, categoryUpdateRequired = ? WHERE imageId = ? AND target = ?")
 	if err != nil {
 		return err
 	}
-	_, err = stmt.Exec(data.PiwigoId, data.FullImagePath, data.Filename, data.Md5Sum, data.LastChange, data.CategoryPath, data.CategoryPiwigoId, data.UploadRequired, data.DeleteRequired, data.ImageId)
+	_, err = stmt.Exec(data.PiwigoId, data.FullImagePath, data.Filename, data.Md5Sum, data.LastChange, data.CategoryPath, data.CategoryPiwigoId, data.UploadRequired, data.DeleteRequired, data.Tags, data.SkipUpload, data.InfoUpdateRequired, data.InvalidReason, data.Title, data.Description, data.Author, data.Keywords, data.Rating, data.CaptureDate, data.HasLocation, data.Latitude, data.Longitude, data.MetadataChange, data.SidecarChange, data.StrongSum, data.FileSize, data.FileId, data.ChangeTime, data.Verified, data.Corrupted, data.CategoryUpdateRequired, data.ImageId, d.target)
 	return err
 }
 
 func readCategoryFromRow(rows *sql.Rows, cat *CategoryData) error {
-	err := rows.Scan(&cat.CategoryId, &cat.PiwigoId, &cat.PiwigoParentId, &cat.Name, &cat.Key)
+	err := rows.Scan(&cat.CategoryId, &cat.PiwigoId, &cat.PiwigoParentId, &cat.Name, &cat.Key, &cat.Description, &cat.Status, &cat.UserIds, &cat.GroupIds, &cat.CoverImagePath, &cat.Rank, &cat.InfoUpdateRequired, &cat.DeleteRequired)
 	return err
 }
 
 func (d *LocalDataStore) updateCategoryData(tx *sql.Tx, data CategoryData) error {
-	stmt, err := tx.Prepare("UPDATE category SET piwigoId = ?, piwigoParentId = ?, name = ?, key = ? WHERE categoryId = ?")
+	stmt, err := tx.Prepare("UPDATE category SET piwigoId = ?, piwigoParentId = ?, name = ?, key = ?, description = ?, status = ?, userIds = ?, groupIds = ?, coverImagePath = ?, rank = ?, infoUpdateRequired = ?, deleteRequired = ? WHERE categoryId = ? AND target = ?")
 	if err != nil {
 		return err
 	}
-	_, err = stmt.Exec(data.PiwigoId, data.PiwigoParentId, data.Name, data.Key, data.CategoryId)
+	_, err = stmt.Exec(data.PiwigoId, data.PiwigoParentId, data.Name, data.Key, data.Description, data.Status, data.UserIds, data.GroupIds, data.CoverImagePath, data.Rank, data.InfoUpdateRequired, data.DeleteRequired, data.CategoryId, d.target)
 	return err
 }
 
 func (d *LocalDataStore) insertCategoryData(tx *sql.Tx, data CategoryData) error {
-	stmt, err := tx.Prepare("INSERT INTO category (piwigoId, piwigoParentId, name, key) VALUES (?,?,?,?)")
+	stmt, err := tx.Prepare("INSERT INTO category (target, piwigoId, piwigoParentId, name, key, description, status, userIds, groupIds, coverImagePath, rank, infoUpdateRequired, deleteRequired) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?)")
 	if err != nil {
 		return err
 	}
-	_, err = stmt.Exec(data.PiwigoId, data.PiwigoParentId, data.Name, data.Key)
+	_, err = stmt.Exec(d.target, data.PiwigoId, data.PiwigoParentId, data.Name, data.Key, data.Description, data.Status, data.UserIds, data.GroupIds, data.CoverImagePath, data.Rank, data.InfoUpdateRequired, data.DeleteRequired)
 	return err
 }
@@ -6,6 +6,7 @@
 package datastore
 
 import (
+	"database/sql"
 	"os"
 	"strings"
 	"testing"
@@ -136,6 +137,111 @@ func Test_save_and_query_for_upload_records_do_not_contain_images_to_delete(t *t
 	ensureMetadataAreEqual("toupload", img1, imgLoad, t)
 }
 
+func Test_save_and_query_for_upload_records_do_not_contain_corrupted_images(t *testing.T) {
+	if !dbinitOk {
+		t.Skip("Skipping test as TestDataStoreInitialize failed!")
+	}
+	dataStore := setupDatabase(t)
+	defer cleanupDatabase(t)
+
+	img1 := getExampleImageMetadata("blah/foo/bar.jpg")
+
+	img2 := getExampleImageMetadata("blah/foo/corrupted.jpg")
+	img2.Corrupted = true
+
+	saveImageShouldNotFail("toupload1", dataStore, img1, t)
+	img1.ImageId = 1
+
+	saveImageShouldNotFail("corrupted", dataStore, img2, t)
+
+	images, err := dataStore.ImageMetadataToUpload()
+	if err != nil {
+		t.Fatalf("Could not query images to upload! %s", err)
+	}
+	if len(images) != 1 {
+		t.Fatalf("Expected only the intact image to upload but got %d", len(images))
+	}
+	ensureMetadataAreEqual("toupload", img1, images[0], t)
+}
+
+func Test_save_and_query_for_upload_records_do_not_contain_invalid_images(t *testing.T) {
+	if !dbinitOk {
+		t.Skip("Skipping test as TestDataStoreInitialize failed!")
+	}
+	dataStore := setupDatabase(t)
+	defer cleanupDatabase(t)
+
+	img1 := getExampleImageMetadata("blah/foo/bar.jpg")
+
+	img2 := getExampleImageMetadata("blah/foo/truncated.jpg")
+	img2.InvalidReason = "broken jpeg: unexpected EOF"
+
+	saveImageShouldNotFail("toupload1", dataStore, img1, t)
+	img1.ImageId = 1
+
+	saveImageShouldNotFail("invalid", dataStore, img2, t)
+	img2.ImageId = 2
+
+	images, err := dataStore.ImageMetadataToUpload()
+	if err != nil {
+		t.Fatalf("Could not query images to upload! %s", err)
+	}
+	if len(images) != 1 {
+		t.Fatalf("Expected only the valid image to upload but got %d", len(images))
+	}
+	ensureMetadataAreEqual("toupload", img1, images[0], t)
+
+	invalid := loadMetadataShouldNotFail("invalid", dataStore, img2.FullImagePath, t)
+	ensureMetadataAreEqual("invalid", img2, invalid, t)
+}
+
+func Test_save_and_load_should_keep_the_file_metadata(t *testing.T) {
+	if !dbinitOk {
+		t.Skip("Skipping test as TestDataStoreInitialize failed!")
+	}
+	dataStore := setupDatabase(t)
+	defer cleanupDatabase(t)
+
+	img := getExampleImageMetadata("blah/foo/bar.jpg")
+	img.Title = "Sunset"
+	img.Description = "Sunset at the lake"
+	img.Author = "Jane"
+	img.Keywords = "lake,sunset"
+	img.Rating = 4
+	img.CaptureDate = time.Date(2019, 5, 3, 12, 34, 56, 0, time.UTC)
+	img.HasLocation = true
+	img.Latitude = 47.375
+	img.Longitude = -8.5
+	img.MetadataChange = img.LastChange
+	img.SidecarChange = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	img.FileSize = 4096
+	img.FileId = "2049:1234"
+	img.ChangeTime = time.Date(2020, 1, 2, 3, 4, 6, 0, time.UTC)
+	img.Verified = time.Date(2020, 1, 3, 3, 4, 6, 0, time.UTC)
+	img.Corrupted = true
+
+	saveImageShouldNotFail("metadata", dataStore, img, t)
+	img.ImageId = 1
+
+	loaded := loadMetadataShouldNotFail("metadata", dataStore, img.FullImagePath, t)
+	if loaded.Description != img.Description || loaded.Author != img.Author || loaded.Keywords != img.Keywords || loaded.Rating != img.Rating {
+		t.Errorf("Expected the metadata %s but got %s", img.String(), loaded.String())
+	}
+	if !loaded.CaptureDate.Equal(img.CaptureDate) || !loaded.MetadataChange.Equal(img.MetadataChange) || !loaded.SidecarChange.Equal(img.SidecarChange) {
+		t.Errorf("Expected the dates %s, %s and %s but got %s, %s and %s", img.CaptureDate, img.MetadataChange, img.SidecarChange, loaded.CaptureDate, loaded.MetadataChange, loaded.SidecarChange)
+	}
+	if !loaded.HasLocation || loaded.Latitude != img.Latitude || loaded.Longitude != img.Longitude {
+		t.Errorf("Expected the location %f,%f but got %t %f,%f", img.Latitude, img.Longitude, loaded.HasLocation, loaded.Latitude, loaded.Longitude)
+	}
+	if loaded.FileSize != img.FileSize || loaded.FileId != img.FileId || !loaded.ChangeTime.Equal(img.ChangeTime) {
+		t.Errorf("Expected the fingerprint %d %s %s but got %d %s %s", img.FileSize, img.FileId, img.ChangeTime, loaded.FileSize, loaded.FileId, loaded.ChangeTime)
+	}
+	if !loaded.Verified.Equal(img.Verified) || !loaded.Corrupted {
+		t.Errorf("Expected the verification %s %t but got %s %t", img.Verified, img.Corrupted, loaded.Verified, loaded.Corrupted)
+	}
+	ensureMetadataAreEqual("metadata", img, loaded, t)
+}
+
 func Test_save_and_query_for_deleted_records_do_contain_images(t *testing.T) {
 	if !dbinitOk {
 		t.Skip("Skipping test as TestDataStoreInitialize failed!")
@@ -288,6 +394,108 @@ func Test_deleteMarkedImages_should_remove_records(t *testing.T) {
 	}
 }
 
+func Test_markUnseenImagesForDeletion_should_only_mark_images_of_older_scans(t *testing.T) {
+	if !dbinitOk {
+		t.Skip("Skipping test as TestDataStoreInitialize failed!")
+	}
+	dataStore := setupDatabase(t)
+	defer cleanupDatabase(t)
+
+	seen := getExampleImageMetadata("blah/foo/seen.jpg")
+	saveImageShouldNotFail("markUnseen", dataStore, seen, t)
+	removed := getExampleImageMetadata("blah/foo/removed.jpg")
+	saveImageShouldNotFail("markUnseen", dataStore, removed, t)
+
+	err := dataStore.MarkImagesSeen([]string{seen.FullImagePath}, 42)
+	if err != nil {
+		t.Fatalf("Could not mark images as seen! %s", err)
+	}
+
+	marked, err := dataStore.MarkUnseenImagesForDeletion(42)
+	if err != nil {
+		t.Fatalf("Could not mark unseen images! %s", err)
+	}
+	if marked != 1 {
+		t.Errorf("Expected one marked image but got %d", marked)
+	}
+
+	images, err := dataStore.ImageMetadataToDelete()
+	if err != nil {
+		t.Fatalf("Could not query images! %s", err)
+	}
+	if len(images) != 1 || images[0].FullImagePath != removed.FullImagePath || images[0].UploadRequired {
+		t.Errorf("Got unexpected images to delete %v", images)
+	}
+}
+
+func Test_saveDirectoryData_should_store_and_replace_entries(t *testing.T) {
+	if !dbinitOk {
+		t.Skip("Skipping test as TestDataStoreInitialize failed!")
+	}
+	dataStore := setupDatabase(t)
+	defer cleanupDatabase(t)
+
+	modTime := time.Date(2020, 2, 3, 4, 5, 6, 789, time.UTC)
+	directory := DirectoryData{
+		Path:    "/photos/2019",
+		ModTime: modTime,
+		Entries: []DirectoryEntry{{Name: "Event", IsDir: true, ModTime: modTime}, {Name: "img.jpg", Size: 42, ModTime: modTime}},
+		ScanId:  1,
+	}
+	for i := 0; i < 2; i++ {
+		err := dataStore.SaveDirectoryData(directory)
+		if err != nil {
+			t.Fatalf("Could not save directory! %s", err)
+		}
+	}
+
+	loaded, err := dataStore.DirectoryData(directory.Path)
+	if err != nil {
+		t.Fatalf("Could not load directory! %s", err)
+	}
+	if !loaded.ModTime.Equal(modTime) || loaded.ScanId != 1 || len(loaded.Entries) != 2 || loaded.Entries[1].Name != "img.jpg" || loaded.Entries[1].Size != 42 || !loaded.Entries[1].ModTime.Equal(modTime) {
+		t.Errorf("Got unexpected directory %+v", loaded)
+	}
+
+	_, err = dataStore.DirectoryData("/photos/2020")
+	if err != ErrorRecordNotFound {
+		t.Errorf("Expected record not found but got %v", err)
+	}
+}
+
+func Test_lastCompletedScan_should_return_the_latest_scans(t *testing.T) {
+	if !dbinitOk {
+		t.Skip("Skipping test as TestDataStoreInitialize failed!")
+	}
+	dataStore := setupDatabase(t)
+	defer cleanupDatabase(t)
+
+	_, err := dataStore.LastCompletedScan()
+	if err != ErrorRecordNotFound {
+		t.Errorf("Expected record not found but got %v", err)
+	}
+
+	scans := []ScanData{
+		{ScanId: 1, FullScan: true, Completed: time.Now().UTC()},
+		{ScanId: 2, FullScan: false, Completed: time.Now().UTC()},
+	}
+	for _, scan := range scans {
+		err = dataStore.SaveCompletedScan(scan)
+		if err != nil {
+			t.Fatalf("Could not save scan! %s", err)
+		}
+	}
+
+	last, err := dataStore.LastCompletedScan()
+	if err != nil || last.ScanId != 2 {
+		t.Errorf("Got unexpected last scan %+v - %v", last, err)
+	}
+	lastFull, err := dataStore.LastCompletedFullScan()
+	if err != nil || lastFull.ScanId != 1 || !lastFull.FullScan {
+		t.Errorf("Got unexpected last full scan %+v - %v", lastFull, err)
+	}
+}
+
 func Test_saveCategory_should_store_records(t *testing.T) {
 	if !dbinitOk {
 		t.Skip("Skipping test as TestDataStoreInitialize failed!")
@@ -380,7 +588,7 @@ func Test_GetCategoryByPiwigoId_should_return_category(t *testing.T) {
 	ensureLoadedCategoryIsExpectedCategory(loadedCategory, category, t)
 }
 
-func Test_GetCategoriesToCreate(t *testing.T) {
+func Test_GetCategoriesToUpdate(t *testing.T) {
 	if !dbinitOk {
 		t.Skip("Skipping test as TestDataStoreInitialize failed!")
 	}
@@ -388,21 +596,284 @@ func Test_GetCategoriesToCreate(t *testing.T) {
 	defer cleanupDatabase(t)
 
 	category := getExampleCategoryData("2019")
-	category.PiwigoId = 0
-
-	saveCategoryShouldNotFail("getCategoriesToCreate", dataStore, category, t)
-	category.CategoryId = 1
-
-	categories, err := dataStore.GetCategoriesToCreate()
+	category.Description = "Holidays"
+	category.Status = "private"
+	category.UserIds = "1,2"
+	category.CoverImagePath = "/tmp/2019/cover.jpg"
+	category.Rank = 3
+	category.InfoUpdateRequired = true
+	saveCategoryShouldNotFail("getCategoriesToUpdate", dataStore, category, t)
+
+	unchanged := getExampleCategoryData("2020")
+	unchanged.PiwigoId = 2
+	saveCategoryShouldNotFail("getCategoriesToUpdate", dataStore, unchanged, t)
+
+	categories, err := dataStore.GetCategoriesToUpdate()
 	if err != nil {
 		t.Fatalf("Could not query category! %s", err)
 	}
 
 	if len(categories) != 1 {
-		t.Error("Did not load categories to create correctly!")
+		t.Fatalf("Expected one category to update but got %d", len(categories))
+	}
+
+	category.CategoryId = categories[0].CategoryId
+	if categories[0] != category {
+		t.Errorf("Got %+v but expected %+v", categories[0], category)
+	}
+}
+
+func Test_MarkEmptyCategoriesForDeletion_should_only_mark_categories_whose_images_are_gone(t *testing.T) {
+	if !dbinitOk {
+		t.Skip("Skipping test as TestDataStoreInitialize failed!")
+	}
+	dataStore := setupDatabase(t)
+	defer cleanupDatabase(t)
+
+	for i, key := range []string{"2019", "2019/a", "2019/b", "Manual"} {
+		category := getExampleCategoryData(key)
+		category.PiwigoId = i + 1
+		saveCategoryShouldNotFail("markEmptyCategories", dataStore, category, t)
+	}
+
+	img1 := getExampleImageMetadata("2019/a/img.jpg")
+	img1.CategoryPath = "2019/a"
+	saveImageShouldNotFail("markEmptyCategories", dataStore, img1, t)
+	img1.ImageId = 1
+
+	img2 := getExampleImageMetadata("2019/b/img.jpg")
+	img2.CategoryPath = "2019/b"
+	img2.DeleteRequired = true
+	saveImageShouldNotFail("markEmptyCategories", dataStore, img2, t)
+	img2.ImageId = 2
+
+	ensureCategoriesToDelete(t, dataStore, "2019/b")
+
+	img1.DeleteRequired = true
+	saveImageShouldNotFail("markEmptyCategories", dataStore, img1, t)
+	ensureCategoriesToDelete(t, dataStore, "2019/b", "2019/a", "2019")
+
+	// the marks stay even if the images are removed from the database
+	err := dataStore.DeleteMarkedImages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensureCategoriesToDelete(t, dataStore, "2019/b", "2019/a", "2019")
+
+	img2.ImageId = 0
+	img2.DeleteRequired = false
+	saveImageShouldNotFail("markEmptyCategories", dataStore, img2, t)
+	ensureCategoriesToDelete(t, dataStore, "2019/a")
+
+	category, err := dataStore.GetCategoryByKey("2019/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = dataStore.DeleteCategory(category.CategoryId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ensureCategoriesToDelete(t, dataStore)
+}
+
+func ensureCategoriesToDelete(t *testing.T, dataStore *LocalDataStore, expectedKeys ...string) {
+	marked, err := dataStore.MarkEmptyCategoriesForDeletion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if marked != int64(len(expectedKeys)) {
+		t.Errorf("Expected %d marked categories but got %d", len(expectedKeys), marked)
+	}
+
+	categories, err := dataStore.GetCategoriesToDelete()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, category := range categories {
+		keys = append(keys, category.Key)
+	}
+	if strings.Join(keys, ",") != strings.Join(expectedKeys, ",") {
+		t.Errorf("Expected the categories %v to be deleted but got %v", expectedKeys, keys)
+	}
+}
+
+func Test_ImageMetadataToUpdateInfo_contains_only_uploaded_images(t *testing.T) {
+	if !dbinitOk {
+		t.Skip("Skipping test as TestDataStoreInitialize failed!")
+	}
+	dataStore := setupDatabase(t)
+	defer cleanupDatabase(t)
+
+	img := getExampleImageMetadata("blah/foo/uploaded.jpg")
+	img.Tags = "summer,beach"
+	img.InfoUpdateRequired = true
+	saveImageShouldNotFail("imageMetadataToUpdateInfo", dataStore, img, t)
+
+	notUploaded := getExampleImageMetadata("blah/foo/new.jpg")
+	notUploaded.PiwigoId = 0
+	notUploaded.InfoUpdateRequired = true
+	saveImageShouldNotFail("imageMetadataToUpdateInfo", dataStore, notUploaded, t)
+
+	images, err := dataStore.ImageMetadataToUpdateInfo()
+	if err != nil {
+		t.Fatalf("Could not query images! %s", err)
+	}
+
+	if len(images) != 1 {
+		t.Fatalf("Expected one image to update but got %d", len(images))
+	}
+
+	img.ImageId = images[0].ImageId
+	ensureMetadataAreEqual("imageMetadataToUpdateInfo", img, images[0], t)
+}
+
+func Test_ImageMetadataToUpdateInfo_contains_moved_images(t *testing.T) {
+	if !dbinitOk {
+		t.Skip("Skipping test as TestDataStoreInitialize failed!")
+	}
+	dataStore := setupDatabase(t)
+	defer cleanupDatabase(t)
+
+	img := getExampleImageMetadata("blah/foo/moved.jpg")
+	img.CategoryUpdateRequired = true
+	saveImageShouldNotFail("imageMetadataToUpdateInfo", dataStore, img, t)
+
+	unchanged := getExampleImageMetadata("blah/foo/unchanged.jpg")
+	saveImageShouldNotFail("imageMetadataToUpdateInfo", dataStore, unchanged, t)
+
+	images, err := dataStore.ImageMetadataToUpdateInfo()
+	if err != nil {
+		t.Fatalf("Could not query images! %s", err)
+	}
+
+	if len(images) != 1 {
+		t.Fatalf("Expected one image to update but got %d", len(images))
+	}
+
+	img.ImageId = images[0].ImageId
+	ensureMetadataAreEqual("imageMetadataToUpdateInfo", img, images[0], t)
+}
+
+func Test_ImageMetadataByStrongSum_returns_images_with_the_same_content(t *testing.T) {
+	if !dbinitOk {
+		t.Skip("Skipping test as TestDataStoreInitialize failed!")
+	}
+	dataStore := setupDatabase(t)
+	defer cleanupDatabase(t)
+
+	img := getExampleImageMetadata("blah/foo/bar.jpg")
+	img.StrongSum = "sha256:aabbcc"
+	saveImageShouldNotFail("imageMetadataByStrongSum", dataStore, img, t)
+
+	other := getExampleImageMetadata("blah/foo/other.jpg")
+	other.StrongSum = "sha256:ddeeff"
+	saveImageShouldNotFail("imageMetadataByStrongSum", dataStore, other, t)
+
+	images, err := dataStore.ImageMetadataByStrongSum(img.StrongSum)
+	if err != nil {
+		t.Fatalf("Could not query images! %s", err)
 	}
 
-	ensureLoadedCategoryIsExpectedCategory(categories[0], category, t)
+	if len(images) != 1 {
+		t.Fatalf("Expected one image with the strong sum but got %d", len(images))
+	}
+
+	img.ImageId = images[0].ImageId
+	ensureMetadataAreEqual("imageMetadataByStrongSum", img, images[0], t)
+	if images[0].StrongSum != img.StrongSum {
+		t.Errorf("Expected the strong sum %s but got %s", img.StrongSum, images[0].StrongSum)
+	}
+}
+
+func Test_ImageMetadataByVerification_returns_corrupted_and_oldest_verified_images_first(t *testing.T) {
+	if !dbinitOk {
+		t.Skip("Skipping test as TestDataStoreInitialize failed!")
+	}
+	dataStore := setupDatabase(t)
+	defer cleanupDatabase(t)
+
+	recent := getExampleImageMetadata("blah/foo/recent.jpg")
+	recent.Verified = time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)
+	saveImageShouldNotFail("imageMetadataByVerification", dataStore, recent, t)
+
+	corrupted := getExampleImageMetadata("blah/foo/corrupted.jpg")
+	corrupted.Verified = time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	corrupted.Corrupted = true
+	saveImageShouldNotFail("imageMetadataByVerification", dataStore, corrupted, t)
+
+	never := getExampleImageMetadata("blah/foo/never.jpg")
+	saveImageShouldNotFail("imageMetadataByVerification", dataStore, never, t)
+
+	old := getExampleImageMetadata("blah/foo/old.jpg")
+	old.Verified = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	saveImageShouldNotFail("imageMetadataByVerification", dataStore, old, t)
+
+	notHashed := getExampleImageMetadata("blah/foo/notHashed.jpg")
+	notHashed.Md5Sum = ""
+	saveImageShouldNotFail("imageMetadataByVerification", dataStore, notHashed, t)
+
+	expected := []string{corrupted.FullImagePath, never.FullImagePath, old.FullImagePath, recent.FullImagePath}
+
+	first, err := dataStore.ImageMetadataByVerification(0, 3)
+	if err != nil {
+		t.Fatalf("Could not query images! %s", err)
+	}
+	rest, err := dataStore.ImageMetadataByVerification(3, 3)
+	if err != nil {
+		t.Fatalf("Could not query images! %s", err)
+	}
+
+	images := append(first, rest...)
+	if len(images) != len(expected) {
+		t.Fatalf("Expected %d images but got %d", len(expected), len(images))
+	}
+	for i, img := range images {
+		if img.FullImagePath != expected[i] {
+			t.Errorf("Expected %s at position %d but got %s", expected[i], i, img.FullImagePath)
+		}
+	}
+}
+
+func Test_targets_do_not_share_records(t *testing.T) {
+	if !dbinitOk {
+		t.Skip("Skipping test as TestDataStoreInitialize failed!")
+	}
+	dataStore := setupDatabase(t)
+	defer cleanupDatabase(t)
+
+	otherTarget := dataStore.ForTarget("other")
+
+	img := getExampleImageMetadata("blah/foo/bar.jpg")
+	saveImageShouldNotFail("target default", dataStore, img, t)
+	saveImageShouldNotFail("target other", otherTarget, img, t)
+
+	category := getExampleCategoryData("2019")
+	saveCategoryShouldNotFail("target default", dataStore, category, t)
+	saveCategoryShouldNotFail("target other", otherTarget, category, t)
+
+	err := otherTarget.SavePiwigoIdAndUpdateUploadFlag(img.Md5Sum, 4711)
+	if err != nil {
+		t.Fatalf("Could not update piwigo id! %s", err)
+	}
+
+	imgDefault := loadMetadataShouldNotFail("target default", dataStore, img.FullImagePath, t)
+	if imgDefault.PiwigoId != img.PiwigoId {
+		t.Errorf("Piwigo id of the default target got changed by another target: %d", imgDefault.PiwigoId)
+	}
+
+	imgOther := loadMetadataShouldNotFail("target other", otherTarget, img.FullImagePath, t)
+	if imgOther.PiwigoId != 4711 {
+		t.Errorf("Piwigo id of the other target was not saved. Got %d", imgOther.PiwigoId)
+	}
+
+	images, err := otherTarget.ImageMetadataAll()
+	if err != nil {
+		t.Fatalf("Could not query images! %s", err)
+	}
+	if len(images) != 1 {
+		t.Errorf("Got incorrect number of images (%d) for the other target. Expected one.", len(images))
+	}
 }
 
 func saveImageShouldNotFail(action string, dataStore *LocalDataStore, img ImageMetaData, t *testing.T) {
@@ -489,3 +960,30 @@ func ensureLoadedCategoryIsExpectedCategory(loaded CategoryData, expected Catego
 		t.Errorf("category update failed. Got: %d - want: %d", loaded.PiwigoParentId, expected.PiwigoParentId)
 	}
 }
+
+func Test_migration_escapes_existing_category_keys(t *testing.T) {
+	db, err := sql.Open("sqlite3", databaseFile)
+	if err != nil {
+		t.Fatalf("Could not open database: %s", err)
+	}
+
+	err = createInitialSchema(db)
+	if err == nil {
+		_, err = db.Exec("INSERT INTO category (piwigoId, piwigoParentId, name, key) VALUES (1, 0, '100%', '2019/100%')")
+	}
+	_ = db.Close()
+	if err != nil {
+		t.Fatalf("Could not prepare database in the initial version: %s", err)
+	}
+
+	dataStore := setupDatabase(t)
+	defer cleanupDatabase(t)
+
+	category, err := dataStore.GetCategoryByPiwigoId(1)
+	if err != nil {
+		t.Fatalf("Could not load migrated category: %s", err)
+	}
+	if category.Key != "2019/100%25" {
+		t.Errorf("Category key was not migrated. Got %s", category.Key)
+	}
+}
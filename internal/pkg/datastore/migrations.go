@@ -0,0 +1,277 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package datastore
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+)
+
+type schemaMigration func(tx *sql.Tx) error
+
+// All schema changes after the initial version are applied in this order. The sqlite user_version
+// is used to remember how many of them are already applied to the database.
+var schemaMigrations = []schemaMigration{
+	migrateAddTargets,
+	migrateEscapeCategoryKeys,
+	migrateAddSettings,
+	migrateAddLastScan,
+	migrateAddDirectories,
+	migrateAddInvalidReason,
+	migrateAddCategoryDeletion,
+	migrateAddFileMetadata,
+	migrateAddStrongSum,
+	migrateAddFileFingerprint,
+	migrateAddVerification,
+	migrateAddCategoryUpdate,
+}
+
+func schemaVersion(db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRow("PRAGMA user_version").Scan(&version)
+	return version, err
+}
+
+func createInitialSchema(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS image (" +
+		"imageId INTEGER PRIMARY KEY," +
+		"piwigoId INTEGER NULL," +
+		"fullImagePath NVARCHAR(1000) NOT NULL," +
+		"fileName NVARCHAR(255) NOT NULL," +
+		"md5sum NVARCHAR(50) NOT NULL," +
+		"lastChanged DATETIME NOT NULL," +
+		"categoryPath NVARCHAR(1000) NOT NULL," +
+		"categoryPiwigoId INTEGER NULL," +
+		"uploadRequired BIT NOT NULL," +
+		"deleteRequired BIT NOT NULL" +
+		");")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS UX_ImageFullImagePath ON image (fullImagePath);")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS category (" +
+		"categoryId INTEGER PRIMARY KEY," +
+		"piwigoId INTEGER NULL," +
+		"piwigoParentId INTEGER NULL," +
+		"name NVARCHAR(255) NOT NULL," +
+		"key NVARCHAR(1000) NOT NULL" +
+		");")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS UX_Category_Key ON category (key);")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS UX_Category_PiwigoId ON category (piwigoId) WHERE piwigoId > 0;")
+	return err
+}
+
+func migrateSchema(db *sql.DB, version int) error {
+	for i := version; i < len(schemaMigrations); i++ {
+		logrus.Infof("Migrating database schema to version %d", i+1)
+
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+
+		err = schemaMigrations[i](tx)
+		if err == nil {
+			// pragma statements do not support parameters
+			_, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1))
+		}
+
+		if err != nil {
+			logrus.Errorf("Rolling back schema migration to version %d", i+1)
+			errTx := tx.Rollback()
+			if errTx != nil {
+				logrus.Errorf("Rollback of schema migration to version %d failed!", i+1)
+			}
+			return err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Existing records belong to the default target. The unique indexes have to include the target as the
+// same file or category is stored once per target.
+func migrateAddTargets(tx *sql.Tx) error {
+	statements := []string{
+		"ALTER TABLE image ADD COLUMN target NVARCHAR(255) NOT NULL DEFAULT '" + DefaultTarget + "';",
+		"DROP INDEX IF EXISTS UX_ImageFullImagePath;",
+		"CREATE UNIQUE INDEX UX_Image_Target_FullImagePath ON image (target, fullImagePath);",
+		"ALTER TABLE category ADD COLUMN target NVARCHAR(255) NOT NULL DEFAULT '" + DefaultTarget + "';",
+		"DROP INDEX IF EXISTS UX_Category_Key;",
+		"CREATE UNIQUE INDEX UX_Category_Target_Key ON category (target, key);",
+		"DROP INDEX IF EXISTS UX_Category_PiwigoId;",
+		"CREATE UNIQUE INDEX UX_Category_Target_PiwigoId ON category (target, piwigoId) WHERE piwigoId > 0;",
+	}
+	return executeStatements(tx, statements)
+}
+
+// The category keys used to be the plain names joined by the path separator. Now the names get escaped
+// (see package categoryKey). Keys built from local directories only need the escape character to be
+// escaped. Categories loaded from piwigo get their key rebuilt on the next sync using their piwigo id.
+func migrateEscapeCategoryKeys(tx *sql.Tx) error {
+	statements := []string{
+		"UPDATE category SET key = REPLACE(key, '%', '%25');",
+		"UPDATE image SET categoryPath = REPLACE(categoryPath, '%', '%25');",
+	}
+
+	if os.PathSeparator != '/' {
+		statements = append(statements,
+			"UPDATE category SET key = REPLACE(key, '"+string(os.PathSeparator)+"', '/');",
+			"UPDATE image SET categoryPath = REPLACE(categoryPath, '"+string(os.PathSeparator)+"', '/');",
+		)
+	}
+
+	return executeStatements(tx, statements)
+}
+
+// The settings of the local directories are stored to detect changes that have to be sent to piwigo.
+func migrateAddSettings(tx *sql.Tx) error {
+	statements := []string{
+		"ALTER TABLE category ADD COLUMN description NVARCHAR(4000) NOT NULL DEFAULT '';",
+		"ALTER TABLE category ADD COLUMN status NVARCHAR(50) NOT NULL DEFAULT '';",
+		"ALTER TABLE category ADD COLUMN userIds NVARCHAR(1000) NOT NULL DEFAULT '';",
+		"ALTER TABLE category ADD COLUMN groupIds NVARCHAR(1000) NOT NULL DEFAULT '';",
+		"ALTER TABLE category ADD COLUMN coverImagePath NVARCHAR(1000) NOT NULL DEFAULT '';",
+		"ALTER TABLE category ADD COLUMN rank INTEGER NOT NULL DEFAULT 0;",
+		"ALTER TABLE category ADD COLUMN infoUpdateRequired BIT NOT NULL DEFAULT 0;",
+		"ALTER TABLE image ADD COLUMN tags NVARCHAR(1000) NOT NULL DEFAULT '';",
+		"ALTER TABLE image ADD COLUMN skipUpload BIT NOT NULL DEFAULT 0;",
+		"ALTER TABLE image ADD COLUMN infoUpdateRequired BIT NOT NULL DEFAULT 0;",
+	}
+	return executeStatements(tx, statements)
+}
+
+// The images found by a scan are marked with its id, so the images of the previous scans can be marked for
+// deletion without loading all of them into memory.
+func migrateAddLastScan(tx *sql.Tx) error {
+	statements := []string{
+		"ALTER TABLE image ADD COLUMN lastScan INTEGER NOT NULL DEFAULT 0;",
+	}
+	return executeStatements(tx, statements)
+}
+
+// The listings of the local directories are stored to skip listing directories that did not change since the
+// last completed scan.
+func migrateAddDirectories(tx *sql.Tx) error {
+	statements := []string{
+		"CREATE TABLE directory (" +
+			"directoryId INTEGER PRIMARY KEY," +
+			"path NVARCHAR(1000) NOT NULL," +
+			"modTime INTEGER NOT NULL," +
+			"entryCount INTEGER NOT NULL," +
+			"entries TEXT NOT NULL," +
+			"scanId INTEGER NOT NULL" +
+			");",
+		"CREATE UNIQUE INDEX UX_Directory_Path ON directory (path);",
+		"CREATE TABLE scan (" +
+			"scanId INTEGER PRIMARY KEY," +
+			"fullScan BIT NOT NULL," +
+			"completed DATETIME NOT NULL" +
+			");",
+	}
+	return executeStatements(tx, statements)
+}
+
+// Files that are not valid images get the reason stored and are kept out of the upload until they change.
+func migrateAddInvalidReason(tx *sql.Tx) error {
+	statements := []string{
+		"ALTER TABLE image ADD COLUMN invalidReason NVARCHAR(1000) NOT NULL DEFAULT '';",
+	}
+	return executeStatements(tx, statements)
+}
+
+// Categories without any image left get marked to be deleted on piwigo.
+func migrateAddCategoryDeletion(tx *sql.Tx) error {
+	statements := []string{
+		"ALTER TABLE category ADD COLUMN deleteRequired BIT NOT NULL DEFAULT 0;",
+	}
+	return executeStatements(tx, statements)
+}
+
+// The metadata of the files and their sidecars is stored with the modification times it was read from, so it only
+// has to be read again if one of them changes.
+func migrateAddFileMetadata(tx *sql.Tx) error {
+	statements := []string{
+		"ALTER TABLE image ADD COLUMN title NVARCHAR(1000) NOT NULL DEFAULT '';",
+		"ALTER TABLE image ADD COLUMN description TEXT NOT NULL DEFAULT '';",
+		"ALTER TABLE image ADD COLUMN author NVARCHAR(1000) NOT NULL DEFAULT '';",
+		"ALTER TABLE image ADD COLUMN keywords TEXT NOT NULL DEFAULT '';",
+		"ALTER TABLE image ADD COLUMN rating INTEGER NOT NULL DEFAULT 0;",
+		"ALTER TABLE image ADD COLUMN captureDate DATETIME NOT NULL DEFAULT '0001-01-01 00:00:00+00:00';",
+		"ALTER TABLE image ADD COLUMN hasLocation BIT NOT NULL DEFAULT 0;",
+		"ALTER TABLE image ADD COLUMN latitude REAL NOT NULL DEFAULT 0;",
+		"ALTER TABLE image ADD COLUMN longitude REAL NOT NULL DEFAULT 0;",
+		"ALTER TABLE image ADD COLUMN metadataChanged DATETIME NOT NULL DEFAULT '0001-01-01 00:00:00+00:00';",
+		"ALTER TABLE image ADD COLUMN sidecarChanged DATETIME NOT NULL DEFAULT '0001-01-01 00:00:00+00:00';",
+	}
+	return executeStatements(tx, statements)
+}
+
+// A collision resistant checksum is stored next to the md5 sum to detect moved files by their content.
+func migrateAddStrongSum(tx *sql.Tx) error {
+	statements := []string{
+		"ALTER TABLE image ADD COLUMN strongSum NVARCHAR(200) NOT NULL DEFAULT '';",
+		"CREATE INDEX IX_Image_Target_StrongSum ON image (target, strongSum);",
+	}
+	return executeStatements(tx, statements)
+}
+
+// The size, file id and change time complete the fingerprint used to detect changes without reading the files.
+func migrateAddFileFingerprint(tx *sql.Tx) error {
+	statements := []string{
+		"ALTER TABLE image ADD COLUMN fileSize INTEGER NOT NULL DEFAULT 0;",
+		"ALTER TABLE image ADD COLUMN fileId NVARCHAR(100) NOT NULL DEFAULT '';",
+		"ALTER TABLE image ADD COLUMN fileChanged DATETIME NOT NULL DEFAULT '0001-01-01 00:00:00+00:00';",
+	}
+	return executeStatements(tx, statements)
+}
+
+// The time of the last verification is stored, so the scrub reads the files verified longest ago first.
+func migrateAddVerification(tx *sql.Tx) error {
+	statements := []string{
+		"ALTER TABLE image ADD COLUMN verified DATETIME NOT NULL DEFAULT '0001-01-01 00:00:00+00:00';",
+		"ALTER TABLE image ADD COLUMN corrupted BIT NOT NULL DEFAULT 0;",
+		"CREATE INDEX IX_Image_Target_Verified ON image (target, corrupted, verified);",
+	}
+	return executeStatements(tx, statements)
+}
+
+// Moved files keep their image on piwigo, which has to be moved to the category of the new album.
+func migrateAddCategoryUpdate(tx *sql.Tx) error {
+	statements := []string{
+		"ALTER TABLE image ADD COLUMN categoryUpdateRequired BIT NOT NULL DEFAULT 0;",
+	}
+	return executeStatements(tx, statements)
+}
+
+func executeStatements(tx *sql.Tx, statements []string) error {
+	for _, statement := range statements {
+		_, err := tx.Exec(statement)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package images
+
+import (
+	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/datastore"
+	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/metadata"
+	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/piwigo"
+	"github.com/sirupsen/logrus"
+	"os"
+	"strings"
+)
+
+// AdoptOptions define which properties besides the album and the filename have to match
+// to adopt an image that already exists on the server.
+type AdoptOptions struct {
+	MatchSize bool
+	MatchDate bool
+}
+
+// Images that got uploaded by another tool or with server side resizing do not have the same md5 sum as the local file.
+// This function looks up these images in the album of the local file by filename and stores the piwigo id
+// without uploading the image again.
+func AdoptExistingImages(piwigoCtx piwigo.ImageApi, metadataProvider datastore.ImageMetadataProvider, options AdoptOptions) error {
+	logrus.Info("Adopting images that already exist on piwigo but have a different checksum...")
+	defer logrus.Info("Finished adopting images that already exist on piwigo")
+
+	images, err := metadataProvider.ImageMetadataToUpload()
+	if err != nil {
+		return err
+	}
+
+	imagesByCategory := make(map[int][]datastore.ImageMetaData)
+	for _, img := range images {
+		if img.PiwigoId == 0 && img.CategoryPiwigoId > 0 {
+			imagesByCategory[img.CategoryPiwigoId] = append(imagesByCategory[img.CategoryPiwigoId], img)
+		}
+	}
+
+	if len(imagesByCategory) == 0 {
+		logrus.Info("There are no images without piwigo id to adopt.")
+		return nil
+	}
+
+	adopted := 0
+	for categoryId, localImages := range imagesByCategory {
+		serverImages, err := piwigoCtx.ImagesOfCategory(categoryId)
+		if err != nil {
+			return err
+		}
+
+		serverImagesByName := make(map[string][]piwigo.Image, len(serverImages))
+		for _, serverImage := range serverImages {
+			name := strings.ToLower(serverImage.Filename)
+			serverImagesByName[name] = append(serverImagesByName[name], serverImage)
+		}
+
+		for _, img := range localImages {
+			candidates := serverImagesByName[strings.ToLower(img.Filename)]
+			if len(candidates) == 0 {
+				logrus.Tracef("%s: no image with the same name found in category %d", img.FullImagePath, categoryId)
+				continue
+			}
+			if len(candidates) > 1 {
+				logrus.Warnf("%s: found %d images with the same name in category %d. Not adopting any of them.", img.FullImagePath, len(candidates), categoryId)
+				continue
+			}
+
+			if !adoptMatches(piwigoCtx, img, candidates[0], options) {
+				continue
+			}
+
+			logrus.Infof("%s: adopting existing piwigo image %d", img.FullImagePath, candidates[0].Id)
+			img.PiwigoId = candidates[0].Id
+			img.UploadRequired = false
+			err = metadataProvider.SaveImageMetadata(img)
+			if err != nil {
+				logrus.Warnf("%s: could not save adopted piwigo id %d", img.FullImagePath, img.PiwigoId)
+				continue
+			}
+
+			// the same server image must not be adopted by another local file
+			delete(serverImagesByName, strings.ToLower(img.Filename))
+			adopted++
+		}
+	}
+
+	logrus.Infof("Adopted %d existing images", adopted)
+	return nil
+}
+
+func adoptMatches(piwigoCtx piwigo.ImageApi, img datastore.ImageMetaData, serverImage piwigo.Image, options AdoptOptions) bool {
+	if options.MatchDate {
+		localDate, err := metadata.ReadCaptureDate(img.FullImagePath)
+		if err != nil {
+			logrus.Debugf("%s: could not read capture date - %s", img.FullImagePath, err)
+			return false
+		}
+		if !localDate.Equal(serverImage.DateCreation) {
+			logrus.Debugf("%s: capture date %s differs from piwigo image %d with %s", img.FullImagePath, localDate, serverImage.Id, serverImage.DateCreation)
+			return false
+		}
+	}
+
+	if options.MatchSize {
+		fileInfo, err := os.Stat(img.FullImagePath)
+		if err != nil {
+			logrus.Warnf("%s: could not read file size - %s", img.FullImagePath, err)
+			return false
+		}
+
+		serverSize, err := piwigoCtx.ImageFileSize(serverImage.Id)
+		if err != nil {
+			logrus.Warnf("%s: could not get file size of piwigo image %d - %s", img.FullImagePath, serverImage.Id, err)
+			return false
+		}
+
+		// piwigo stores the size in KB, so we have to allow a difference of one KB
+		difference := fileInfo.Size() - serverSize
+		if difference < 0 || difference >= 1024 {
+			logrus.Debugf("%s: size %d differs from piwigo image %d with %d", img.FullImagePath, fileInfo.Size(), serverImage.Id, serverSize)
+			return false
+		}
+	}
+
+	return true
+}
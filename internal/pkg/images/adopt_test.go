@@ -0,0 +1,79 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package images
+
+import (
+	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/datastore"
+	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/piwigo"
+	"github.com/golang/mock/gomock"
+	"testing"
+)
+
+func Test_adoptExistingImages_saves_piwigo_id_of_image_with_same_name(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	img := createTestImageMetaData(0)
+	img.Filename = "file.jpg"
+	images := []datastore.ImageMetaData{img}
+
+	imgToSave := img
+	imgToSave.PiwigoId = 7
+	imgToSave.UploadRequired = false
+
+	dbmock := NewMockImageMetadataProvider(mockCtrl)
+	dbmock.EXPECT().ImageMetadataToUpload().Times(1).Return(images, nil)
+	dbmock.EXPECT().SaveImageMetadata(imgToSave).Times(1)
+
+	serverImages := []piwigo.Image{{Id: 6, Filename: "other.jpg"}, {Id: 7, Filename: "FILE.jpg"}}
+	piwigomock := NewMockImageApi(mockCtrl)
+	piwigomock.EXPECT().ImagesOfCategory(img.CategoryPiwigoId).Times(1).Return(serverImages, nil)
+
+	err := AdoptExistingImages(piwigomock, dbmock, AdoptOptions{})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_adoptExistingImages_does_not_adopt_ambiguous_names(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	img := createTestImageMetaData(0)
+	img.Filename = "file.jpg"
+	images := []datastore.ImageMetaData{img}
+
+	dbmock := NewMockImageMetadataProvider(mockCtrl)
+	dbmock.EXPECT().ImageMetadataToUpload().Times(1).Return(images, nil)
+	dbmock.EXPECT().SaveImageMetadata(gomock.Any()).Times(0)
+
+	serverImages := []piwigo.Image{{Id: 6, Filename: "file.jpg"}, {Id: 7, Filename: "file.jpg"}}
+	piwigomock := NewMockImageApi(mockCtrl)
+	piwigomock.EXPECT().ImagesOfCategory(img.CategoryPiwigoId).Times(1).Return(serverImages, nil)
+
+	err := AdoptExistingImages(piwigomock, dbmock, AdoptOptions{})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_adoptExistingImages_does_not_call_piwigo_for_images_with_piwigo_id(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	images := []datastore.ImageMetaData{createTestImageMetaData(5)}
+
+	dbmock := NewMockImageMetadataProvider(mockCtrl)
+	dbmock.EXPECT().ImageMetadataToUpload().Times(1).Return(images, nil)
+
+	piwigomock := NewMockImageApi(mockCtrl)
+	piwigomock.EXPECT().ImagesOfCategory(gomock.Any()).Times(0)
+
+	err := AdoptExistingImages(piwigomock, dbmock, AdoptOptions{})
+	if err != nil {
+		t.Error(err)
+	}
+}
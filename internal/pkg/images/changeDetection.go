@@ -0,0 +1,122 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package images
+
+import (
+	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/datastore"
+	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/localFileStructure"
+)
+
+const (
+	// ChangeDetectionModTime hashes the files whose modification time changed.
+	ChangeDetectionModTime = "mtime"
+	// ChangeDetectionFingerprint hashes the files whose modification time, size, inode or change time changed. This
+	// finds changes of tools keeping the modification time and restored backups.
+	ChangeDetectionFingerprint = "fingerprint"
+	// ChangeDetectionHash hashes all files on every run.
+	ChangeDetectionHash = "hash"
+)
+
+func fileDidNotChange(metadata *datastore.ImageMetaData, file *localFileStructure.FilesystemNode, changeDetection string) bool {
+	if metadata.DeleteRequired {
+		return false
+	}
+
+	switch changeDetection {
+	case ChangeDetectionHash:
+		return false
+	case ChangeDetectionFingerprint:
+		return metadata.LastChange.Equal(file.ModTime) && fingerprintMatches(metadata, file)
+	default:
+		return metadata.LastChange.Equal(file.ModTime)
+	}
+}
+
+// Parts of the fingerprint that are not known on both sides are not compared. This covers platforms without inodes
+// or change times, older directory cache entries and the images stored before the fingerprint was.
+func fingerprintMatches(metadata *datastore.ImageMetaData, file *localFileStructure.FilesystemNode) bool {
+	if !hasFingerprint(metadata) {
+		return true
+	}
+	if metadata.FileSize != file.Size {
+		return false
+	}
+	if metadata.FileId != "" && file.FileId != "" && metadata.FileId != file.FileId {
+		return false
+	}
+	if !metadata.ChangeTime.IsZero() && !file.ChangeTime.IsZero() && !metadata.ChangeTime.Equal(file.ChangeTime) {
+		return false
+	}
+	return true
+}
+
+func hasFingerprint(metadata *datastore.ImageMetaData) bool {
+	return metadata.FileSize != 0 || metadata.FileId != "" || !metadata.ChangeTime.IsZero()
+}
+
+// Stores the parts of the fingerprint the record does not know yet and reports if any were added. Parts that differ
+// are kept, otherwise a change missed by the modification time would be hidden from the fingerprint policy once it
+// gets switched on.
+func completeFingerprint(metadata *datastore.ImageMetaData, file *localFileStructure.FilesystemNode) bool {
+	changed := false
+	if !hasFingerprint(metadata) && file.Size != 0 {
+		metadata.FileSize = file.Size
+		changed = true
+	}
+	if metadata.FileId == "" && file.FileId != "" {
+		metadata.FileId = file.FileId
+		changed = true
+	}
+	if metadata.ChangeTime.IsZero() && !file.ChangeTime.IsZero() {
+		metadata.ChangeTime = file.ChangeTime
+		changed = true
+	}
+	return changed
+}
+
+// Stores the known parts of the fingerprint of the hashed file and reports if any of them changed.
+func applyFingerprint(metadata *datastore.ImageMetaData, file *localFileStructure.FilesystemNode) bool {
+	changed := false
+	if metadata.FileSize != file.Size {
+		metadata.FileSize = file.Size
+		changed = true
+	}
+	if file.FileId != "" && metadata.FileId != file.FileId {
+		metadata.FileId = file.FileId
+		changed = true
+	}
+	if !file.ChangeTime.IsZero() && !metadata.ChangeTime.Equal(file.ChangeTime) {
+		metadata.ChangeTime = file.ChangeTime
+		changed = true
+	}
+	return changed
+}
+
+// Decides if the hashed file has to be uploaded. Using the modification time, every modified file is uploaded and
+// piwigo is asked later on if it really differs. The other policies hash files that did not change at all, so
+// only files with a different checksum are uploaded.
+func contentChanged(metadata *datastore.ImageMetaData, file *localFileStructure.FilesystemNode, content *fileContent, changeDetection string) bool {
+	if metadata.PiwigoId == 0 {
+		return true
+	}
+	if changeDetection == ChangeDetectionModTime {
+		return !metadata.LastChange.Equal(file.ModTime)
+	}
+	return metadata.UploadRequired || metadata.Md5Sum != content.checksums.Md5
+}
+
+// Hashing all files finds content that changed while the modification time and the fingerprint stayed the same. No
+// program changed such a file, so it is treated like a mismatch found by the scrub. Returns nil if the file was
+// modified or its content matches the stored checksum.
+func unmodifiedContentMismatch(metadata *datastore.ImageMetaData, file *localFileStructure.FilesystemNode, content *fileContent, changeDetection string) *ChecksumMismatch {
+	if changeDetection != ChangeDetectionHash || metadata.Md5Sum == "" || metadata.InvalidReason != "" || metadata.DeleteRequired {
+		return nil
+	}
+	if !metadata.LastChange.Equal(file.ModTime) || !fingerprintMatches(metadata, file) {
+		return nil
+	}
+	return compareChecksums(metadata, content.checksums)
+}
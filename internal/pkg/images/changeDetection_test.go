@@ -0,0 +1,158 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package images
+
+import (
+	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/datastore"
+	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/localFileStructure"
+	"github.com/golang/mock/gomock"
+	"testing"
+	"time"
+)
+
+func Test_synchronize_local_image_metadata_should_find_changes_keeping_the_modification_time_by_the_fingerprint(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	testFileSystemNode := createFingerprintTestNode()
+	fileSystemNodes := map[string]*localFileStructure.FilesystemNode{testFileSystemNode.Key: testFileSystemNode}
+
+	imageStored := createImageMetaDataFromFilesystem(testFileSystemNode, 5, false, false)
+	imageStored.Md5Sum = "old content"
+	imageStored.FileSize = 1024
+	imageStored.FileId = testFileSystemNode.FileId
+	imageStored.ChangeTime = testFileSystemNode.ChangeTime
+
+	imageExpected := createImageMetaDataFromFilesystem(testFileSystemNode, 5, true, false)
+	imageExpected.FileSize = testFileSystemNode.Size
+	imageExpected.FileId = testFileSystemNode.FileId
+	imageExpected.ChangeTime = testFileSystemNode.ChangeTime
+
+	db := NewMockImageMetadataProvider(mockCtrl)
+	db.EXPECT().ImageMetadata(testFileSystemNode.Path).Return(imageStored, nil).Times(1)
+	db.EXPECT().SaveImageMetadata(imageExpected).Times(1)
+	db.EXPECT().MarkImagesSeen([]string{testFileSystemNode.Path}, testScanId).Times(1)
+
+	_, err := SynchronizeLocalImageMetadata([]MetadataTarget{{ImageDb: db}}, streamTestNodes(fileSystemNodes), testWorkers, testScanId, ChangeDetectionFingerprint, testChecksumCalculator, nil)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_synchronize_local_image_metadata_should_only_store_the_missing_fingerprint_of_unchanged_files(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	testFileSystemNode := createFingerprintTestNode()
+	fileSystemNodes := map[string]*localFileStructure.FilesystemNode{testFileSystemNode.Key: testFileSystemNode}
+
+	// stored before the fingerprint was
+	imageStored := createImageMetaDataFromFilesystem(testFileSystemNode, 5, false, false)
+
+	imageExpected := imageStored
+	imageExpected.FileSize = testFileSystemNode.Size
+	imageExpected.FileId = testFileSystemNode.FileId
+	imageExpected.ChangeTime = testFileSystemNode.ChangeTime
+
+	db := NewMockImageMetadataProvider(mockCtrl)
+	db.EXPECT().ImageMetadata(testFileSystemNode.Path).Return(imageStored, nil).Times(1)
+	db.EXPECT().SaveImageMetadata(imageExpected).Times(1)
+	db.EXPECT().MarkImagesSeen([]string{testFileSystemNode.Path}, testScanId).Times(1)
+
+	checksumCalculator := func(file string) (localFileStructure.FileChecksums, error) {
+		t.Errorf("Expected %s not to be hashed as it did not change", file)
+		return localFileStructure.FileChecksums{}, nil
+	}
+
+	_, err := SynchronizeLocalImageMetadata([]MetadataTarget{{ImageDb: db}}, streamTestNodes(fileSystemNodes), testWorkers, testScanId, ChangeDetectionFingerprint, checksumCalculator, nil)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_synchronize_local_image_metadata_should_keep_the_outdated_fingerprint_of_files_unchanged_by_mtime(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	testFileSystemNode := createFingerprintTestNode()
+	fileSystemNodes := map[string]*localFileStructure.FilesystemNode{testFileSystemNode.Key: testFileSystemNode}
+
+	// changed by a tool keeping the modification time
+	imageStored := createImageMetaDataFromFilesystem(testFileSystemNode, 5, false, false)
+	imageStored.FileSize = 1024
+	imageStored.FileId = testFileSystemNode.FileId
+	imageStored.ChangeTime = testFileSystemNode.ChangeTime.Add(-time.Hour)
+
+	db := NewMockImageMetadataProvider(mockCtrl)
+	db.EXPECT().ImageMetadata(testFileSystemNode.Path).Return(imageStored, nil).Times(1)
+	db.EXPECT().SaveImageMetadata(gomock.Any()).Times(0)
+	db.EXPECT().MarkImagesSeen([]string{testFileSystemNode.Path}, testScanId).Times(1)
+
+	_, err := SynchronizeLocalImageMetadata([]MetadataTarget{{ImageDb: db}}, streamTestNodes(fileSystemNodes), testWorkers, testScanId, ChangeDetectionModTime, testChecksumCalculator, nil)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_synchronize_local_image_metadata_should_not_upload_hashed_files_with_the_same_checksum(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	testFileSystemNode := createFingerprintTestNode()
+	fileSystemNodes := map[string]*localFileStructure.FilesystemNode{testFileSystemNode.Key: testFileSystemNode}
+
+	// the file got touched, so only the modification time changed
+	imageStored := createImageMetaDataFromFilesystem(testFileSystemNode, 5, false, false)
+	imageStored.LastChange = testFileSystemNode.ModTime.Add(-time.Hour)
+
+	imageExpected := createImageMetaDataFromFilesystem(testFileSystemNode, 5, false, false)
+	imageExpected.FileSize = testFileSystemNode.Size
+	imageExpected.FileId = testFileSystemNode.FileId
+	imageExpected.ChangeTime = testFileSystemNode.ChangeTime
+
+	db := NewMockImageMetadataProvider(mockCtrl)
+	db.EXPECT().ImageMetadata(testFileSystemNode.Path).Return(imageStored, nil).Times(1)
+	db.EXPECT().SaveImageMetadata(imageExpected).Times(1)
+	db.EXPECT().MarkImagesSeen([]string{testFileSystemNode.Path}, testScanId).Times(1)
+
+	_, err := SynchronizeLocalImageMetadata([]MetadataTarget{{ImageDb: db}}, streamTestNodes(fileSystemNodes), testWorkers, testScanId, ChangeDetectionHash, testChecksumCalculator, nil)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_fingerprintMatches_should_skip_unknown_parts(t *testing.T) {
+	file := createFingerprintTestNode()
+	stored := datastore.ImageMetaData{FileSize: file.Size, FileId: file.FileId, ChangeTime: file.ChangeTime}
+
+	tests := map[string]struct {
+		file     localFileStructure.FilesystemNode
+		expected bool
+	}{
+		"same":            {*file, true},
+		"other size":      {localFileStructure.FilesystemNode{Size: 1, FileId: file.FileId, ChangeTime: file.ChangeTime}, false},
+		"other inode":     {localFileStructure.FilesystemNode{Size: file.Size, FileId: "2049:2", ChangeTime: file.ChangeTime}, false},
+		"other ctime":     {localFileStructure.FilesystemNode{Size: file.Size, FileId: file.FileId, ChangeTime: file.ChangeTime.Add(time.Second)}, false},
+		"cached listing":  {localFileStructure.FilesystemNode{Size: file.Size}, true},
+		"cached and size": {localFileStructure.FilesystemNode{Size: 1}, false},
+	}
+	for name, test := range tests {
+		if fingerprintMatches(&stored, &test.file) != test.expected {
+			t.Errorf("%s: expected the fingerprint to match: %t", name, test.expected)
+		}
+	}
+}
+
+func createFingerprintTestNode() *localFileStructure.FilesystemNode {
+	return &localFileStructure.FilesystemNode{
+		Key:        "2019/shooting1/abc.jpg",
+		ModTime:    time.Date(2019, 01, 01, 01, 0, 0, 0, time.UTC),
+		Name:       "abc.jpg",
+		Path:       "2019/shooting1/abc.jpg",
+		Size:       2048,
+		FileId:     "2049:1",
+		ChangeTime: time.Date(2019, 01, 02, 01, 0, 0, 0, time.UTC)}
+}
@@ -77,6 +77,36 @@ func (mr *MockImageMetadataProviderMockRecorder) ImageMetadataAll() *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImageMetadataAll", reflect.TypeOf((*MockImageMetadataProvider)(nil).ImageMetadataAll))
 }
 
+// ImageMetadataByStrongSum mocks base method
+func (m *MockImageMetadataProvider) ImageMetadataByStrongSum(arg0 string) ([]datastore.ImageMetaData, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ImageMetadataByStrongSum", arg0)
+	ret0, _ := ret[0].([]datastore.ImageMetaData)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ImageMetadataByStrongSum indicates an expected call of ImageMetadataByStrongSum
+func (mr *MockImageMetadataProviderMockRecorder) ImageMetadataByStrongSum(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImageMetadataByStrongSum", reflect.TypeOf((*MockImageMetadataProvider)(nil).ImageMetadataByStrongSum), arg0)
+}
+
+// ImageMetadataByVerification mocks base method
+func (m *MockImageMetadataProvider) ImageMetadataByVerification(arg0, arg1 int) ([]datastore.ImageMetaData, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ImageMetadataByVerification", arg0, arg1)
+	ret0, _ := ret[0].([]datastore.ImageMetaData)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ImageMetadataByVerification indicates an expected call of ImageMetadataByVerification
+func (mr *MockImageMetadataProviderMockRecorder) ImageMetadataByVerification(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImageMetadataByVerification", reflect.TypeOf((*MockImageMetadataProvider)(nil).ImageMetadataByVerification), arg0, arg1)
+}
+
 // ImageMetadataToDelete mocks base method
 func (m *MockImageMetadataProvider) ImageMetadataToDelete() ([]datastore.ImageMetaData, error) {
 	m.ctrl.T.Helper()
@@ -92,6 +122,21 @@ func (mr *MockImageMetadataProviderMockRecorder) ImageMetadataToDelete() *gomock
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImageMetadataToDelete", reflect.TypeOf((*MockImageMetadataProvider)(nil).ImageMetadataToDelete))
 }
 
+// ImageMetadataToUpdateInfo mocks base method
+func (m *MockImageMetadataProvider) ImageMetadataToUpdateInfo() ([]datastore.ImageMetaData, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ImageMetadataToUpdateInfo")
+	ret0, _ := ret[0].([]datastore.ImageMetaData)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ImageMetadataToUpdateInfo indicates an expected call of ImageMetadataToUpdateInfo
+func (mr *MockImageMetadataProviderMockRecorder) ImageMetadataToUpdateInfo() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImageMetadataToUpdateInfo", reflect.TypeOf((*MockImageMetadataProvider)(nil).ImageMetadataToUpdateInfo))
+}
+
 // ImageMetadataToUpload mocks base method
 func (m *MockImageMetadataProvider) ImageMetadataToUpload() ([]datastore.ImageMetaData, error) {
 	m.ctrl.T.Helper()
@@ -107,6 +152,35 @@ func (mr *MockImageMetadataProviderMockRecorder) ImageMetadataToUpload() *gomock
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImageMetadataToUpload", reflect.TypeOf((*MockImageMetadataProvider)(nil).ImageMetadataToUpload))
 }
 
+// MarkImagesSeen mocks base method
+func (m *MockImageMetadataProvider) MarkImagesSeen(arg0 []string, arg1 int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MarkImagesSeen", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MarkImagesSeen indicates an expected call of MarkImagesSeen
+func (mr *MockImageMetadataProviderMockRecorder) MarkImagesSeen(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkImagesSeen", reflect.TypeOf((*MockImageMetadataProvider)(nil).MarkImagesSeen), arg0, arg1)
+}
+
+// MarkUnseenImagesForDeletion mocks base method
+func (m *MockImageMetadataProvider) MarkUnseenImagesForDeletion(arg0 int64) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MarkUnseenImagesForDeletion", arg0)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MarkUnseenImagesForDeletion indicates an expected call of MarkUnseenImagesForDeletion
+func (mr *MockImageMetadataProviderMockRecorder) MarkUnseenImagesForDeletion(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkUnseenImagesForDeletion", reflect.TypeOf((*MockImageMetadataProvider)(nil).MarkUnseenImagesForDeletion), arg0)
+}
+
 // SaveImageMetadata mocks base method
 func (m *MockImageMetadataProvider) SaveImageMetadata(arg0 datastore.ImageMetaData) error {
 	m.ctrl.T.Helper()
@@ -158,19 +232,48 @@ func (m *MockCategoryProvider) EXPECT() *MockCategoryProviderMockRecorder {
 	return m.recorder
 }
 
-// GetCategoriesToCreate mocks base method
-func (m *MockCategoryProvider) GetCategoriesToCreate() ([]datastore.CategoryData, error) {
+// DeleteCategory mocks base method
+func (m *MockCategoryProvider) DeleteCategory(arg0 int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteCategory", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteCategory indicates an expected call of DeleteCategory
+func (mr *MockCategoryProviderMockRecorder) DeleteCategory(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteCategory", reflect.TypeOf((*MockCategoryProvider)(nil).DeleteCategory), arg0)
+}
+
+// GetCategoriesToDelete mocks base method
+func (m *MockCategoryProvider) GetCategoriesToDelete() ([]datastore.CategoryData, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetCategoriesToCreate")
+	ret := m.ctrl.Call(m, "GetCategoriesToDelete")
 	ret0, _ := ret[0].([]datastore.CategoryData)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// GetCategoriesToCreate indicates an expected call of GetCategoriesToCreate
-func (mr *MockCategoryProviderMockRecorder) GetCategoriesToCreate() *gomock.Call {
+// GetCategoriesToDelete indicates an expected call of GetCategoriesToDelete
+func (mr *MockCategoryProviderMockRecorder) GetCategoriesToDelete() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCategoriesToCreate", reflect.TypeOf((*MockCategoryProvider)(nil).GetCategoriesToCreate))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCategoriesToDelete", reflect.TypeOf((*MockCategoryProvider)(nil).GetCategoriesToDelete))
+}
+
+// GetCategoriesToUpdate mocks base method
+func (m *MockCategoryProvider) GetCategoriesToUpdate() ([]datastore.CategoryData, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetCategoriesToUpdate")
+	ret0, _ := ret[0].([]datastore.CategoryData)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCategoriesToUpdate indicates an expected call of GetCategoriesToUpdate
+func (mr *MockCategoryProviderMockRecorder) GetCategoriesToUpdate() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCategoriesToUpdate", reflect.TypeOf((*MockCategoryProvider)(nil).GetCategoriesToUpdate))
 }
 
 // GetCategoryByKey mocks base method
@@ -203,6 +306,21 @@ func (mr *MockCategoryProviderMockRecorder) GetCategoryByPiwigoId(arg0 interface
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCategoryByPiwigoId", reflect.TypeOf((*MockCategoryProvider)(nil).GetCategoryByPiwigoId), arg0)
 }
 
+// MarkEmptyCategoriesForDeletion mocks base method
+func (m *MockCategoryProvider) MarkEmptyCategoriesForDeletion() (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MarkEmptyCategoriesForDeletion")
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MarkEmptyCategoriesForDeletion indicates an expected call of MarkEmptyCategoriesForDeletion
+func (mr *MockCategoryProviderMockRecorder) MarkEmptyCategoriesForDeletion() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkEmptyCategoriesForDeletion", reflect.TypeOf((*MockCategoryProvider)(nil).MarkEmptyCategoriesForDeletion))
+}
+
 // SaveCategory mocks base method
 func (m *MockCategoryProvider) SaveCategory(arg0 datastore.CategoryData) error {
 	m.ctrl.T.Helper()
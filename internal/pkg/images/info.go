@@ -0,0 +1,65 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package images
+
+import (
+	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/datastore"
+	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/piwigo"
+	"github.com/sirupsen/logrus"
+	"strings"
+)
+
+// Sends the changed information like the tags of already uploaded images to piwigo. The images of moved files are
+// moved to the category of their new album.
+func UpdateImageInfo(piwigoCtx piwigo.ImageApi, metadataProvider datastore.ImageMetadataProvider) error {
+	logrus.Debug("Entering UpdateImageInfo")
+	defer logrus.Debug("Leaving UpdateImageInfo")
+
+	images, err := metadataProvider.ImageMetadataToUpdateInfo()
+	if err != nil {
+		return err
+	}
+
+	if len(images) == 0 {
+		logrus.Info("There are no image infos to update.")
+		return nil
+	}
+
+	logrus.Infof("Updating the info of %d images", len(images))
+
+	for _, img := range images {
+		if img.InfoUpdateRequired {
+			var tags []string
+			if img.Tags != "" {
+				tags = strings.Split(img.Tags, ",")
+			}
+
+			err = piwigoCtx.SetImageTags(img.PiwigoId, tags)
+			if err != nil {
+				logrus.Warnf("%s: could not update the tags of piwigo image %d - %s", img.FullImagePath, img.PiwigoId, err)
+				continue
+			}
+		}
+
+		if img.CategoryUpdateRequired {
+			err = piwigoCtx.SetImageCategory(img.PiwigoId, img.CategoryPiwigoId)
+			if err != nil {
+				logrus.Warnf("%s: could not move piwigo image %d to category %d - %s", img.FullImagePath, img.PiwigoId, img.CategoryPiwigoId, err)
+				continue
+			}
+			logrus.Infof("%s: moved piwigo image %d to category %d", img.FullImagePath, img.PiwigoId, img.CategoryPiwigoId)
+		}
+
+		img.InfoUpdateRequired = false
+		img.CategoryUpdateRequired = false
+		err = metadataProvider.SaveImageMetadata(img)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package images
+
+import (
+	"errors"
+	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/datastore"
+	"github.com/golang/mock/gomock"
+	"testing"
+)
+
+func Test_updateImageInfo_sets_tags_and_resets_flag(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	img := createTestImageMetaData(5)
+	img.Tags = "summer,beach"
+	img.InfoUpdateRequired = true
+
+	imgToSave := img
+	imgToSave.InfoUpdateRequired = false
+
+	dbmock := NewMockImageMetadataProvider(mockCtrl)
+	dbmock.EXPECT().ImageMetadataToUpdateInfo().Times(1).Return([]datastore.ImageMetaData{img}, nil)
+	dbmock.EXPECT().SaveImageMetadata(imgToSave).Times(1)
+
+	piwigomock := NewMockImageApi(mockCtrl)
+	piwigomock.EXPECT().SetImageTags(5, []string{"summer", "beach"}).Times(1).Return(nil)
+
+	err := UpdateImageInfo(piwigomock, dbmock)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_updateImageInfo_keeps_flag_on_error(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	img := createTestImageMetaData(5)
+	img.InfoUpdateRequired = true
+
+	dbmock := NewMockImageMetadataProvider(mockCtrl)
+	dbmock.EXPECT().ImageMetadataToUpdateInfo().Times(1).Return([]datastore.ImageMetaData{img}, nil)
+	dbmock.EXPECT().SaveImageMetadata(gomock.Any()).Times(0)
+
+	piwigomock := NewMockImageApi(mockCtrl)
+	piwigomock.EXPECT().SetImageTags(5, gomock.Nil()).Times(1).Return(errors.New("failed"))
+
+	err := UpdateImageInfo(piwigomock, dbmock)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_updateImageInfo_keeps_flag_if_the_move_fails(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	img := createTestImageMetaData(5)
+	img.CategoryUpdateRequired = true
+
+	dbmock := NewMockImageMetadataProvider(mockCtrl)
+	dbmock.EXPECT().ImageMetadataToUpdateInfo().Times(1).Return([]datastore.ImageMetaData{img}, nil)
+	dbmock.EXPECT().SaveImageMetadata(gomock.Any()).Times(0)
+
+	piwigomock := NewMockImageApi(mockCtrl)
+	piwigomock.EXPECT().SetImageTags(gomock.Any(), gomock.Any()).Times(0)
+	piwigomock.EXPECT().SetImageCategory(5, 2).Times(1).Return(errors.New("failed"))
+
+	err := UpdateImageInfo(piwigomock, dbmock)
+	if err != nil {
+		t.Error(err)
+	}
+}
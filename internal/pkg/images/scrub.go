@@ -0,0 +1,189 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package images
+
+import (
+	"errors"
+	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/datastore"
+	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/localFileStructure"
+	"github.com/sirupsen/logrus"
+	"os"
+	"time"
+)
+
+// Number of images loaded at once while looking for the images to scrub.
+const scrubPageSize = 100
+
+// The state of a scrub shared between the targets, so every file is read at most once.
+type scrubRun struct {
+	targets            []MetadataTarget
+	read               int64
+	checksumCalculator fileChecksumCalculator
+	// the ids of the images per target that got the result of a file hashed within this run
+	applied    []map[int]struct{}
+	reported   map[string]struct{}
+	verified   int
+	mismatches []ChecksumMismatch
+}
+
+// ScrubChecksums hashes the files of the images verified longest ago until budget bytes are read and compares them
+// with the stored checksums. Only files whose modification time did not change since they were hashed are compared,
+// so a mismatch means that the file got corrupted. Such images are flagged and not uploaded over the intact copy on
+// piwigo until the file gets modified or a later scrub finds the stored content again. Images never verified before
+// count as the oldest ones. Every target gets an equal share of the budget and the result of a hashed file is applied
+// to the images of all targets holding it. Returns the mismatches and the number of verified files.
+func ScrubChecksums(targets []MetadataTarget, budget int64, checksumCalculator fileChecksumCalculator) ([]ChecksumMismatch, int, error) {
+	logrus.Debug("Starting ScrubChecksums")
+	defer logrus.Debug("Leaving ScrubChecksums")
+
+	logrus.Infof("Scrubbing up to %d MiB of the local files", budget/1024/1024)
+
+	run := &scrubRun{targets: targets, checksumCalculator: checksumCalculator, reported: make(map[string]struct{})}
+	for range targets {
+		run.applied = append(run.applied, make(map[int]struct{}))
+	}
+	for i := range targets {
+		// the share is taken from what is left, so the budget a target does not use goes to the following ones
+		remaining := len(targets) - i
+		limit := run.read + (budget-run.read+int64(remaining)-1)/int64(remaining)
+		err := run.scrubTarget(i, limit)
+		if err != nil {
+			return run.mismatches, run.verified, err
+		}
+	}
+
+	logrus.Infof("Scrubbed %d files reading %d MiB", run.verified, run.read/1024/1024)
+	return run.mismatches, run.verified, nil
+}
+
+// The verified images move to the end of the order, so the next page starts after the images that kept their place.
+// The scrub ends as soon as an image shows up again, as all images got verified within this run then.
+func (run *scrubRun) scrubTarget(index int, limit int64) error {
+	imageDb := run.targets[index].ImageDb
+	seen := make(map[int]struct{})
+	offset := 0
+	for run.read < limit {
+		page, err := imageDb.ImageMetadataByVerification(offset, scrubPageSize)
+		if err != nil {
+			return err
+		}
+		if len(page) == 0 {
+			return nil
+		}
+
+		for i := range page {
+			metadata := &page[i]
+			if _, ok := seen[metadata.ImageId]; ok || run.read >= limit {
+				return nil
+			}
+			seen[metadata.ImageId] = struct{}{}
+
+			// the images that already got the result of another target are sorted like the scrubbed ones
+			kept := metadata.Corrupted
+			if _, ok := run.applied[index][metadata.ImageId]; !ok {
+				kept, err = run.scrubImage(index, metadata)
+				if err != nil {
+					return err
+				}
+			}
+			if kept {
+				offset++
+			}
+		}
+	}
+	return nil
+}
+
+// Returns true if the image keeps its place in the order, as it was skipped or still does not match.
+func (run *scrubRun) scrubImage(index int, metadata *datastore.ImageMetaData) (bool, error) {
+	checksums := run.checksums(metadata)
+	if checksums == nil {
+		return true, nil
+	}
+	run.verified++
+
+	err := run.applyChecksums(index, metadata, *checksums)
+	if err != nil {
+		return false, err
+	}
+
+	// the other targets hold their own image of the same file
+	for i, target := range run.targets {
+		if i == index {
+			continue
+		}
+		other, err := target.ImageDb.ImageMetadata(metadata.FullImagePath)
+		if err == datastore.ErrorRecordNotFound {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if _, ok := run.applied[i][other.ImageId]; ok || !isScrubbable(&other, metadata.LastChange) {
+			continue
+		}
+		err = run.applyChecksums(i, &other, *checksums)
+		if err != nil {
+			return false, err
+		}
+	}
+	return metadata.Corrupted, nil
+}
+
+// Only images the scrub would pick itself and that were hashed from the same version of the file get the result.
+func isScrubbable(metadata *datastore.ImageMetaData, lastChange time.Time) bool {
+	return metadata.Md5Sum != "" && !metadata.DeleteRequired && metadata.InvalidReason == "" && metadata.LastChange.Equal(lastChange)
+}
+
+// Compares the checksums with the stored ones and saves the result. Every mismatching file is reported once.
+func (run *scrubRun) applyChecksums(index int, metadata *datastore.ImageMetaData, checksums localFileStructure.FileChecksums) error {
+	mismatch := compareChecksums(metadata, checksums)
+	if mismatch != nil {
+		if _, ok := run.reported[mismatch.Path]; !ok {
+			logrus.Warnf("The content of %s changed without being modified, it is possibly corrupted and will not be uploaded. Stored %s, calculated %s", mismatch.Path, mismatch.Stored, mismatch.Calculated)
+			run.mismatches = append(run.mismatches, *mismatch)
+			run.reported[mismatch.Path] = struct{}{}
+		}
+	} else if metadata.Corrupted {
+		logrus.Infof("The content of %s matches the stored checksum again", metadata.FullImagePath)
+	}
+
+	metadata.Verified = time.Now().UTC()
+	metadata.Corrupted = mismatch != nil
+	run.applied[index][metadata.ImageId] = struct{}{}
+	err := run.targets[index].ImageDb.SaveImageMetadata(*metadata)
+	if err != nil {
+		logrus.Errorf("Error during save of metadata of %s - %s", metadata.FullImagePath, err)
+	}
+	return err
+}
+
+// Files that were modified since they were hashed, are gone or are still being written are left to the
+// synchronization and return nil.
+func (run *scrubRun) checksums(metadata *datastore.ImageMetaData) *localFileStructure.FileChecksums {
+	info, err := os.Stat(metadata.FullImagePath)
+	if err != nil {
+		logrus.Debugf("Skipping the scrub of %s - %s", metadata.FullImagePath, err)
+		return nil
+	}
+	if !info.ModTime().Equal(metadata.LastChange) {
+		logrus.Debugf("Skipping the scrub of %s as it was modified since it was hashed", metadata.FullImagePath)
+		return nil
+	}
+
+	run.read += info.Size()
+	calculated, err := run.checksumCalculator(metadata.FullImagePath)
+	if errors.Is(err, localFileStructure.ErrorFileNotStable) {
+		logrus.Infof("Skipping the scrub of %s as it is still being written", metadata.FullImagePath)
+		return nil
+	}
+	if err != nil {
+		logrus.Warnf("Could not scrub %s - %s", metadata.FullImagePath, err)
+		return nil
+	}
+
+	return &calculated
+}
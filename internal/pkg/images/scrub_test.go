@@ -0,0 +1,311 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package images
+
+import (
+	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/datastore"
+	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/localFileStructure"
+	"github.com/golang/mock/gomock"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var scrubTestModTime = time.Date(2019, 01, 01, 01, 0, 0, 0, time.UTC)
+
+func Test_ScrubChecksums_should_flag_unmodified_files_with_another_checksum(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	rootPath := createScrubTestTree(t, "corrupted.jpg", "intact.jpg", "modified.jpg")
+	defer os.RemoveAll(rootPath)
+
+	corrupted := createScrubTestMetadata(1, filepath.Join(rootPath, "corrupted.jpg"))
+	corrupted.Md5Sum = "good content"
+	intact := createScrubTestMetadata(2, filepath.Join(rootPath, "intact.jpg"))
+	modified := createScrubTestMetadata(3, filepath.Join(rootPath, "modified.jpg"))
+	modified.Md5Sum = "old content"
+	modified.LastChange = scrubTestModTime.Add(-time.Hour)
+
+	var saved []datastore.ImageMetaData
+	db := NewMockImageMetadataProvider(mockCtrl)
+	db.EXPECT().ImageMetadataByVerification(0, scrubPageSize).Return([]datastore.ImageMetaData{corrupted, intact, modified}, nil).Times(1)
+	// the corrupted and the modified image keep their place
+	db.EXPECT().ImageMetadataByVerification(2, scrubPageSize).Return(nil, nil).Times(1)
+	db.EXPECT().SaveImageMetadata(gomock.Any()).Do(func(img datastore.ImageMetaData) {
+		saved = append(saved, img)
+	}).Return(nil).Times(2)
+
+	mismatches, verified, err := ScrubChecksums([]MetadataTarget{{ImageDb: db}}, 1024*1024, testChecksumCalculator)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := ChecksumMismatch{Path: corrupted.FullImagePath, Stored: "good content", Calculated: corrupted.FullImagePath}
+	if len(mismatches) != 1 || mismatches[0] != expected {
+		t.Errorf("Expected the mismatch %v but got %v", expected, mismatches)
+	}
+	if verified != 2 || len(saved) != 2 {
+		t.Fatalf("Expected the two unmodified files to be verified but got %d verified and %d saved", verified, len(saved))
+	}
+	if saved[0].FullImagePath != corrupted.FullImagePath || !saved[0].Corrupted || saved[0].Md5Sum != "good content" || saved[0].UploadRequired {
+		t.Errorf("Expected the corrupted image to be flagged keeping its checksum but got %s", saved[0].String())
+	}
+	if saved[1].FullImagePath != intact.FullImagePath || saved[1].Corrupted || saved[1].Verified.IsZero() {
+		t.Errorf("Expected the intact image to be verified but got %s", saved[1].String())
+	}
+}
+
+func Test_ScrubChecksums_should_stop_once_the_budget_is_read(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	rootPath := createScrubTestTree(t, "first.jpg", "second.jpg")
+	defer os.RemoveAll(rootPath)
+
+	first := createScrubTestMetadata(1, filepath.Join(rootPath, "first.jpg"))
+	second := createScrubTestMetadata(2, filepath.Join(rootPath, "second.jpg"))
+
+	db := NewMockImageMetadataProvider(mockCtrl)
+	db.EXPECT().ImageMetadataByVerification(0, scrubPageSize).Return([]datastore.ImageMetaData{first, second}, nil).Times(1)
+	db.EXPECT().SaveImageMetadata(gomock.Any()).Return(nil).Times(1)
+
+	_, verified, err := ScrubChecksums([]MetadataTarget{{ImageDb: db}}, 1, testChecksumCalculator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified != 1 {
+		t.Errorf("Expected only the first file to be verified but got %d", verified)
+	}
+}
+
+func Test_ScrubChecksums_should_stop_once_all_images_are_verified(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	rootPath := createScrubTestTree(t, "img.jpg")
+	defer os.RemoveAll(rootPath)
+
+	img := createScrubTestMetadata(1, filepath.Join(rootPath, "img.jpg"))
+
+	db := NewMockImageMetadataProvider(mockCtrl)
+	// the verified image is the last one and therefore the first one again
+	db.EXPECT().ImageMetadataByVerification(0, scrubPageSize).Return([]datastore.ImageMetaData{img}, nil).Times(2)
+	db.EXPECT().SaveImageMetadata(gomock.Any()).Return(nil).Times(1)
+
+	_, verified, err := ScrubChecksums([]MetadataTarget{{ImageDb: db}}, 1024*1024, testChecksumCalculator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified != 1 {
+		t.Errorf("Expected the file to be verified once but got %d", verified)
+	}
+}
+
+func Test_ScrubChecksums_should_apply_the_result_to_all_targets_holding_the_file(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	rootPath := createScrubTestTree(t, "corrupted.jpg")
+	defer os.RemoveAll(rootPath)
+
+	corrupted := createScrubTestMetadata(1, filepath.Join(rootPath, "corrupted.jpg"))
+	corrupted.Md5Sum = "good content"
+	corruptedOther := corrupted
+	corruptedOther.ImageId = 11
+
+	var saved []datastore.ImageMetaData
+	saveImage := func(img datastore.ImageMetaData) {
+		saved = append(saved, img)
+	}
+
+	first := NewMockImageMetadataProvider(mockCtrl)
+	first.EXPECT().ImageMetadataByVerification(0, scrubPageSize).Return([]datastore.ImageMetaData{corrupted}, nil).Times(1)
+	first.EXPECT().SaveImageMetadata(gomock.Any()).Do(saveImage).Return(nil).Times(1)
+
+	// the budget is used up by the first target, which shares its result with the second one
+	second := NewMockImageMetadataProvider(mockCtrl)
+	second.EXPECT().ImageMetadata(corrupted.FullImagePath).Return(corruptedOther, nil).Times(1)
+	second.EXPECT().ImageMetadataByVerification(gomock.Any(), gomock.Any()).Times(0)
+	second.EXPECT().SaveImageMetadata(gomock.Any()).Do(saveImage).Return(nil).Times(1)
+
+	calculations := 0
+	countingChecksumCalculator := func(file string) (localFileStructure.FileChecksums, error) {
+		calculations++
+		return testChecksumCalculator(file)
+	}
+
+	mismatches, verified, err := ScrubChecksums([]MetadataTarget{{ImageDb: first}, {ImageDb: second}}, 4, countingChecksumCalculator)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mismatches) != 1 || mismatches[0].Path != corrupted.FullImagePath {
+		t.Errorf("Expected the corrupted file to be reported once but got %v", mismatches)
+	}
+	if verified != 1 || calculations != 1 {
+		t.Errorf("Expected the file to be hashed once but got %d verified and %d calculations", verified, calculations)
+	}
+	if len(saved) != 2 || saved[0].ImageId != corrupted.ImageId || saved[1].ImageId != corruptedOther.ImageId || !saved[0].Corrupted || !saved[1].Corrupted {
+		t.Errorf("Expected the image of both targets to be flagged but got %v", saved)
+	}
+}
+
+func Test_ScrubChecksums_should_share_the_budget_between_the_targets(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	rootPath := createScrubTestTree(t, "first.jpg", "second.jpg", "third.jpg")
+	defer os.RemoveAll(rootPath)
+
+	firstImage := createScrubTestMetadata(1, filepath.Join(rootPath, "first.jpg"))
+	secondImage := createScrubTestMetadata(2, filepath.Join(rootPath, "second.jpg"))
+	sharedImage := createScrubTestMetadata(11, firstImage.FullImagePath)
+	thirdImage := createScrubTestMetadata(12, filepath.Join(rootPath, "third.jpg"))
+
+	var saved []datastore.ImageMetaData
+	saveImage := func(img datastore.ImageMetaData) {
+		saved = append(saved, img)
+	}
+
+	first := NewMockImageMetadataProvider(mockCtrl)
+	first.EXPECT().ImageMetadataByVerification(0, scrubPageSize).Return([]datastore.ImageMetaData{firstImage, secondImage}, nil).Times(1)
+	first.EXPECT().ImageMetadata(thirdImage.FullImagePath).Return(datastore.ImageMetaData{}, datastore.ErrorRecordNotFound).Times(1)
+	first.EXPECT().SaveImageMetadata(gomock.Any()).Do(saveImage).Return(nil).Times(1)
+
+	// the image of the already hashed file is skipped without being read again
+	second := NewMockImageMetadataProvider(mockCtrl)
+	second.EXPECT().ImageMetadata(firstImage.FullImagePath).Return(sharedImage, nil).Times(1)
+	second.EXPECT().ImageMetadataByVerification(0, scrubPageSize).Return([]datastore.ImageMetaData{sharedImage, thirdImage}, nil).Times(1)
+	second.EXPECT().SaveImageMetadata(gomock.Any()).Do(saveImage).Return(nil).Times(2)
+
+	_, verified, err := ScrubChecksums([]MetadataTarget{{ImageDb: first}, {ImageDb: second}}, 8, testChecksumCalculator)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if verified != 2 {
+		t.Errorf("Expected one file of each target to be verified but got %d", verified)
+	}
+	paths := make([]string, 0, len(saved))
+	for _, img := range saved {
+		paths = append(paths, filepath.Base(img.FullImagePath))
+	}
+	if len(saved) != 3 || saved[0].ImageId != firstImage.ImageId || saved[1].ImageId != sharedImage.ImageId || saved[2].ImageId != thirdImage.ImageId {
+		t.Errorf("Expected the first file to be verified on both targets and the third one on the second target but got %v", paths)
+	}
+}
+
+func Test_synchronize_local_image_metadata_should_not_upload_corrupted_files(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	testFileSystemNode := createFingerprintTestNode()
+	fileSystemNodes := map[string]*localFileStructure.FilesystemNode{testFileSystemNode.Key: testFileSystemNode}
+
+	imageStored := createImageMetaDataFromFilesystem(testFileSystemNode, 5, false, false)
+	imageStored.Md5Sum = "good content"
+	imageStored.Corrupted = true
+
+	db := NewMockImageMetadataProvider(mockCtrl)
+	db.EXPECT().ImageMetadata(testFileSystemNode.Path).Return(imageStored, nil).Times(1)
+	db.EXPECT().SaveImageMetadata(gomock.Any()).Times(0)
+	db.EXPECT().MarkImagesSeen([]string{testFileSystemNode.Path}, testScanId).Times(1)
+
+	_, err := SynchronizeLocalImageMetadata([]MetadataTarget{{ImageDb: db}}, streamTestNodes(fileSystemNodes), testWorkers, testScanId, ChangeDetectionHash, testChecksumCalculator, nil)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_synchronize_local_image_metadata_should_flag_unmodified_files_with_another_checksum(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	testFileSystemNode := createFingerprintTestNode()
+	fileSystemNodes := map[string]*localFileStructure.FilesystemNode{testFileSystemNode.Key: testFileSystemNode}
+
+	// neither the modification time nor the fingerprint changed
+	imageStored := createImageMetaDataFromFilesystem(testFileSystemNode, 5, false, false)
+	imageStored.Md5Sum = "good content"
+	imageStored.FileSize = testFileSystemNode.Size
+	imageStored.FileId = testFileSystemNode.FileId
+	imageStored.ChangeTime = testFileSystemNode.ChangeTime
+
+	imageExpected := imageStored
+	imageExpected.Corrupted = true
+
+	db := NewMockImageMetadataProvider(mockCtrl)
+	db.EXPECT().ImageMetadata(testFileSystemNode.Path).Return(imageStored, nil).Times(1)
+	db.EXPECT().SaveImageMetadata(imageExpected).Times(1)
+	db.EXPECT().MarkImagesSeen([]string{testFileSystemNode.Path}, testScanId).Times(1)
+
+	_, err := SynchronizeLocalImageMetadata([]MetadataTarget{{ImageDb: db}}, streamTestNodes(fileSystemNodes), testWorkers, testScanId, ChangeDetectionHash, testChecksumCalculator, nil)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_synchronize_local_image_metadata_should_upload_corrupted_files_once_modified(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	testFileSystemNode := createFingerprintTestNode()
+	fileSystemNodes := map[string]*localFileStructure.FilesystemNode{testFileSystemNode.Key: testFileSystemNode}
+
+	imageStored := createImageMetaDataFromFilesystem(testFileSystemNode, 5, false, false)
+	imageStored.Md5Sum = "good content"
+	imageStored.Corrupted = true
+	imageStored.LastChange = testFileSystemNode.ModTime.Add(-time.Hour)
+
+	imageExpected := createImageMetaDataFromFilesystem(testFileSystemNode, 5, true, false)
+	imageExpected.FileSize = testFileSystemNode.Size
+	imageExpected.FileId = testFileSystemNode.FileId
+	imageExpected.ChangeTime = testFileSystemNode.ChangeTime
+
+	db := NewMockImageMetadataProvider(mockCtrl)
+	db.EXPECT().ImageMetadata(testFileSystemNode.Path).Return(imageStored, nil).Times(1)
+	db.EXPECT().SaveImageMetadata(imageExpected).Times(1)
+	db.EXPECT().MarkImagesSeen([]string{testFileSystemNode.Path}, testScanId).Times(1)
+
+	_, err := SynchronizeLocalImageMetadata([]MetadataTarget{{ImageDb: db}}, streamTestNodes(fileSystemNodes), testWorkers, testScanId, ChangeDetectionHash, testChecksumCalculator, nil)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func createScrubTestTree(t *testing.T, files ...string) string {
+	rootPath, err := ioutil.TempDir("", "scrub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		path := filepath.Join(rootPath, file)
+		err = ioutil.WriteFile(path, []byte("test"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.Chtimes(path, scrubTestModTime, scrubTestModTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rootPath
+}
+
+// The test checksum calculator uses the path as checksum, so the image matches its file.
+func createScrubTestMetadata(imageId int, path string) datastore.ImageMetaData {
+	return datastore.ImageMetaData{
+		ImageId:       imageId,
+		PiwigoId:      imageId,
+		FullImagePath: path,
+		Filename:      filepath.Base(path),
+		Md5Sum:        path,
+		LastChange:    scrubTestModTime,
+	}
+}
@@ -6,140 +6,397 @@
 package images
 
 import (
+	"errors"
+	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/categoryKey"
 	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/datastore"
 	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/localFileStructure"
+	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/metadata"
 	"github.com/sirupsen/logrus"
 	"os"
-	"path/filepath"
-	"runtime"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
-type fileChecksumCalculator func(filePath string) (string, error)
+// Calculates the md5 sum piwigo uses and, if configured, the strong checksum of a file within one read.
+type fileChecksumCalculator func(filePath string) (localFileStructure.FileChecksums, error)
 
-// Update the local image metadata by walking through all found files and check if the modification date has changed
-// or if they are new to the local database. If the files is new or changed, the md5sum will be rebuilt as well.
-func SynchronizeLocalImageMetadata(imageDb datastore.ImageMetadataProvider, categoryDb datastore.CategoryProvider, fileSystemNodes map[string]*localFileStructure.FilesystemNode, checksumCalculator fileChecksumCalculator) error {
+// Reads the metadata of a file and its sidecar. The sidecar path is empty if there is none.
+type fileMetadataReader func(filePath string, sidecarPath string) (metadata.Metadata, error)
+
+// MetadataTarget bundles the local metadata stores of one piwigo server that get updated during the local sync.
+type MetadataTarget struct {
+	ImageDb    datastore.ImageMetadataProvider
+	CategoryDb datastore.CategoryProvider
+}
+
+// Number of files a worker marks as seen within one database transaction.
+const seenBatchSize = 500
+
+// Update the local image metadata by walking through all found files and check if they changed according to the
+// change detection or if they are new to the local database. If the files is new or changed, the md5sum will be
+// rebuilt as well.
+// All targets are updated within the same pass so every file gets read and hashed at most once.
+// The files are processed as they arrive and get marked with the scan id, so MarkRemovedImages is able to find the
+// images that are gone once the scan is done.
+// The files are checked by the given number of workers, which should match the number of files hashed in parallel.
+// Files the checksum calculator reports as not stable are left unchanged and returned as pending, they are checked
+// again on the next run.
+// New files with the strong checksum of an image whose file is gone take over the record of that image, so moved
+// files are not uploaded a second time.
+// If a metadata reader is given, the metadata is read again whenever the file or its sidecar changed. A changed
+// sidecar alone only updates the stored metadata and does not upload the image again.
+// Images the scrub flagged as possibly corrupted are left alone until their file gets modified. Hashing all files
+// flags the images whose content changed without their file being modified the same way.
+func SynchronizeLocalImageMetadata(targets []MetadataTarget, files <-chan *localFileStructure.FilesystemNode, workers int, scanId int64, changeDetection string, checksumCalculator fileChecksumCalculator, metadataReader fileMetadataReader) ([]string, error) {
 	logrus.Debug("Starting SynchronizeLocalImageMetadata")
 	defer logrus.Debug("Leaving SynchronizeLocalImageMetadata")
 
 	logrus.Info("Synchronizing local image metadata database with local available images")
 
-	err := synchronizeLocalImageMetadataScanNewFiles(fileSystemNodes, imageDb, categoryDb, checksumCalculator)
-	if err != nil {
-		return err
-	}
+	workerErrors := make(chan error, workers)
+	workerPending := make(chan []string, workers)
+	wg := sync.WaitGroup{}
 
-	err = synchronizeLocalImageMetadataFindFilesToDelete(imageDb)
-	if err != nil {
-		return err
+	for i := 0; i < workers; i++ {
+		logrus.Debugf("Starting image change detection worker %d", i)
+		wg.Add(1)
+		go checkFileForChangesWorker(files, &wg, targets, scanId, changeDetection, checksumCalculator, metadataReader, workerErrors, workerPending)
 	}
-	return nil
-}
 
-func synchronizeLocalImageMetadataScanNewFiles(fileSystemNodes map[string]*localFileStructure.FilesystemNode, imageDb datastore.ImageMetadataProvider, categoryDb datastore.CategoryProvider, checksumCalculator fileChecksumCalculator) error {
-	logrus.Debug("Entering synchronizeLocalImageMetadataScanNewFiles")
-	defer logrus.Debug("Leaving synchronizeLocalImageMetadataScanNewFiles")
+	wg.Wait()
+	close(workerErrors)
+	close(workerPending)
 
-	workQueue := make(chan localFileStructure.FilesystemNode, 128)
+	var pending []string
+	for workerPendingFiles := range workerPending {
+		pending = append(pending, workerPendingFiles...)
+	}
+	sort.Strings(pending)
 
-	wg := sync.WaitGroup{}
+	// an error of any worker makes the scan incomplete, so the first one is enough
+	return pending, <-workerErrors
+}
 
-	wg.Add(1)
-	logrus.Debug("Starting change detection producer")
-	go checkFileForChangesProducer(fileSystemNodes, workQueue, &wg)
+// MarkRemovedImages marks all images that were not found by the scan with the given id for deletion. This covers
+// removed files as well as files that got excluded by the configuration or an ignore file after they were uploaded.
+func MarkRemovedImages(imageDb datastore.ImageMetadataProvider, scanId int64) error {
+	logrus.Debug("Entering MarkRemovedImages")
+	defer logrus.Debug("Leaving MarkRemovedImages")
 
-	for i := 0; i < runtime.NumCPU(); i++ {
-		logrus.Debugf("Starting image change detection worker %d", i)
-		wg.Add(1)
-		go checkFileForChangesWorker(workQueue, &wg, imageDb, categoryDb, checksumCalculator)
+	marked, err := imageDb.MarkUnseenImagesForDeletion(scanId)
+	if err != nil {
+		return err
 	}
 
-	wg.Wait()
+	logrus.Infof("Marked %d images that are no longer part of the local files for deletion", marked)
 	return nil
 }
 
-func checkFileForChangesProducer(fileSystemNodes map[string]*localFileStructure.FilesystemNode, workQueue chan<- localFileStructure.FilesystemNode, waitGroup *sync.WaitGroup) {
-	for _, file := range fileSystemNodes {
-		workQueue <- *file
-	}
-	waitGroup.Done()
-	close(workQueue)
-}
+func checkFileForChangesWorker(files <-chan *localFileStructure.FilesystemNode, waitGroup *sync.WaitGroup, targets []MetadataTarget, scanId int64, changeDetection string, checksumCalculator fileChecksumCalculator, metadataReader fileMetadataReader, workerErrors chan<- error, workerPending chan<- []string) {
+	defer waitGroup.Done()
+
+	seen := make([]string, 0, seenBatchSize)
+	var pending []string
+	failed := false
 
-func checkFileForChangesWorker(workQueue <-chan localFileStructure.FilesystemNode, waitGroup *sync.WaitGroup, imageDb datastore.ImageMetadataProvider, categoryDb datastore.CategoryProvider, checksumCalculator fileChecksumCalculator) {
-	for file := range workQueue {
+	for file := range files {
 		if file.IsDir {
 			// we are only interested in files not directories
 			logrus.Tracef("Skipping file check as %s is a directory", file.Path)
 			continue
 		}
 
-		metadata, err := imageDb.ImageMetadata(file.Path)
-		if err == datastore.ErrorRecordNotFound {
-			logrus.Debugf("Creating new metadata entry for %s.", file.Path)
-			metadata = datastore.ImageMetaData{}
-			metadata.Filename = file.Name
-			metadata.FullImagePath = file.Path
-			metadata.CategoryPath = filepath.Dir(file.Key)
-
-			var category datastore.CategoryData
-			category, err = categoryDb.GetCategoryByKey(metadata.CategoryPath)
-			if err == nil {
-				metadata.CategoryPiwigoId = category.PiwigoId
-			} else {
-				logrus.Warnf("No category found for image %s - %s", file.Path, err)
+		// the content is shared between all targets and only read if at least one of them needs it
+		content := &fileContent{}
+		for _, target := range targets {
+			err := checkFileForChanges(file, target, content, changeDetection, checksumCalculator, metadataReader)
+			if errors.Is(err, localFileStructure.ErrorFileNotStable) {
+				pending = append(pending, file.Path)
+			}
+			if err != nil {
+				// the file could not be read, so there is no point in checking the other targets
+				break
 			}
+		}
 
-		} else if err != nil {
-			logrus.Errorf("Could not get metadata due to trouble. Cancelling - %s", err)
+		// the remaining files still have to be read so the scan does not block
+		if failed {
 			continue
 		}
 
-		if fileDidNotChange(&metadata, &file) {
-			logrus.Debugf("No changes found for file %s", file.Path)
-			continue
+		seen = append(seen, file.Path)
+		if len(seen) == seenBatchSize {
+			failed = !markImagesSeen(targets, seen, scanId, workerErrors)
+			seen = seen[:0]
 		}
+	}
+
+	if !failed && len(seen) > 0 {
+		markImagesSeen(targets, seen, scanId, workerErrors)
+	}
+	workerPending <- pending
+}
 
-		metadata.UploadRequired = !metadata.LastChange.Equal(file.ModTime) || metadata.PiwigoId == 0
-		metadata.DeleteRequired = false
-		metadata.LastChange = file.ModTime
-		metadata.Md5Sum, err = checksumCalculator(file.Path)
+func markImagesSeen(targets []MetadataTarget, fullImagePaths []string, scanId int64, workerErrors chan<- error) bool {
+	for _, target := range targets {
+		err := target.ImageDb.MarkImagesSeen(fullImagePaths, scanId)
 		if err != nil {
-			logrus.Warnf("Could not calculate checksum for file %s. Skipping...", file.Path)
-			continue
+			logrus.Errorf("Could not mark images as seen - %s", err)
+			workerErrors <- err
+			return false
+		}
+	}
+	return true
+}
+
+// The result of reading a file.
+type fileContent struct {
+	read          bool
+	checksums     localFileStructure.FileChecksums
+	invalidReason string
+	metadataRead  bool
+	metadata      metadata.Metadata
+}
+
+func checkFileForChanges(file *localFileStructure.FilesystemNode, target MetadataTarget, content *fileContent, changeDetection string, checksumCalculator fileChecksumCalculator, metadataReader fileMetadataReader) error {
+	metadata, err := target.ImageDb.ImageMetadata(file.Path)
+	if err == datastore.ErrorRecordNotFound && len(file.Variants) > 0 {
+		metadata, err = takeOverVariant(file, target)
+	}
+	if err == datastore.ErrorRecordNotFound {
+		// new files are hashed anyway, so the content is read before to find the image it was moved from
+		if !content.read {
+			err = readFileContent(file.Path, content, checksumCalculator)
+			if err != nil {
+				return err
+			}
+		}
+		metadata, err = takeOverMovedImage(file, target, content.checksums.Strong)
+	}
+	if err == datastore.ErrorRecordNotFound {
+		logrus.Debugf("Creating new metadata entry for %s.", file.Path)
+		metadata = datastore.ImageMetaData{}
+		metadata.Filename = file.Name
+		metadata.FullImagePath = file.Path
+		assignCategory(&metadata, file, target)
+
+	} else if err != nil {
+		logrus.Errorf("Could not get metadata due to trouble. Cancelling - %s", err)
+		return nil
+	}
+
+	// the checksum of a corrupted file must not replace the intact image on piwigo
+	if metadata.Corrupted && metadata.LastChange.Equal(file.ModTime) {
+		logrus.Debugf("Skipping %s as it is possibly corrupted", file.Path)
+		return nil
+	}
+
+	settingsChanged := applyDirectorySettings(&metadata, file)
+	metadataOutdated := metadataReader != nil && fileMetadataIsOutdated(&metadata, file)
+	if fileDidNotChange(&metadata, file, changeDetection) {
+		fingerprintChanged := completeFingerprint(&metadata, file)
+		if !settingsChanged && !metadataOutdated && !fingerprintChanged {
+			logrus.Debugf("No changes found for file %s", file.Path)
+			return nil
 		}
 
-		err = imageDb.SaveImageMetadata(metadata)
+		if settingsChanged {
+			logrus.Debugf("Settings of file %s changed", file.Path)
+			metadata.UploadRequired = (metadata.UploadRequired || metadata.PiwigoId == 0) && !metadata.SkipUpload && metadata.InvalidReason == ""
+		}
+		if metadataOutdated {
+			logrus.Debugf("Metadata of file %s changed", file.Path)
+			applyFileMetadata(&metadata, file, content, metadataReader)
+		}
+		err = target.ImageDb.SaveImageMetadata(metadata)
 		if err != nil {
 			logrus.Errorf("Error during save of metadata of %s - %s", file.Path, err)
 		}
+		return nil
 	}
-	waitGroup.Done()
+
+	if !content.read {
+		err = readFileContent(file.Path, content, checksumCalculator)
+		if err != nil {
+			return err
+		}
+	}
+
+	if content.invalidReason != "" {
+		// the file is checked again as soon as it changes
+		logrus.Warnf("Skipping %s as it is not a valid image - %s", file.Path, content.invalidReason)
+		metadata.UploadRequired = false
+	} else if mismatch := unmodifiedContentMismatch(&metadata, file, content, changeDetection); mismatch != nil {
+		// the stored checksum is kept, so the scrub compares against the intact content as well
+		logrus.Warnf("The content of %s changed without being modified, it is possibly corrupted and will not be uploaded. Stored %s, calculated %s", mismatch.Path, mismatch.Stored, mismatch.Calculated)
+		metadata.Corrupted = true
+	} else {
+		metadata.UploadRequired = contentChanged(&metadata, file, content, changeDetection) && !metadata.SkipUpload
+		metadata.Md5Sum = content.checksums.Md5
+		metadata.StrongSum = content.checksums.Strong
+		if metadataOutdated {
+			applyFileMetadata(&metadata, file, content, metadataReader)
+		}
+	}
+	if !metadata.LastChange.Equal(file.ModTime) {
+		// a modified file replaces the content the scrub found to be corrupted
+		metadata.Corrupted = false
+	}
+	metadata.InvalidReason = content.invalidReason
+	metadata.DeleteRequired = false
+	metadata.LastChange = file.ModTime
+	applyFingerprint(&metadata, file)
+
+	err = target.ImageDb.SaveImageMetadata(metadata)
+	if err != nil {
+		logrus.Errorf("Error during save of metadata of %s - %s", file.Path, err)
+	}
+	return nil
+}
+
+// A file that became the preferred variant of its group takes over the record of a variant that is already uploaded.
+// This way the image on piwigo gets replaced instead of a second one being uploaded next to it.
+func takeOverVariant(file *localFileStructure.FilesystemNode, target MetadataTarget) (datastore.ImageMetaData, error) {
+	for _, variant := range file.Variants {
+		metadata, err := target.ImageDb.ImageMetadata(variant)
+		if err == datastore.ErrorRecordNotFound {
+			continue
+		}
+		if err != nil {
+			return datastore.ImageMetaData{}, err
+		}
+		if metadata.PiwigoId == 0 {
+			continue
+		}
+
+		logrus.Infof("%s replaces the uploaded variant %s", file.Path, variant)
+		metadata.FullImagePath = file.Path
+		metadata.Filename = file.Name
+		// resetting the change date makes sure the file gets hashed and uploaded
+		metadata.LastChange = time.Time{}
+		return metadata, nil
+	}
+	return datastore.ImageMetaData{}, datastore.ErrorRecordNotFound
 }
 
-func synchronizeLocalImageMetadataFindFilesToDelete(imageDb datastore.ImageMetadataProvider) error {
-	logrus.Debug("Entering SynchronizeLocalImageMetadataFindFilesToDelete")
-	defer logrus.Debug("Leaving SynchronizeLocalImageMetadataFindFilesToDelete")
+// A new file with the content of an image whose file is gone was moved or renamed. It takes over the record of the
+// image, so the image on piwigo gets replaced and assigned to the new album instead of being uploaded a second time.
+// Files with the content of an image that still exists are duplicates and get their own record.
+func takeOverMovedImage(file *localFileStructure.FilesystemNode, target MetadataTarget, strongSum string) (datastore.ImageMetaData, error) {
+	if strongSum == "" {
+		return datastore.ImageMetaData{}, datastore.ErrorRecordNotFound
+	}
 
-	images, err := imageDb.ImageMetadataAll()
+	images, err := target.ImageDb.ImageMetadataByStrongSum(strongSum)
 	if err != nil {
-		return err
+		return datastore.ImageMetaData{}, err
 	}
 
-	for _, img := range images {
-		if _, err = os.Stat(img.FullImagePath); os.IsNotExist(err) {
-			img.UploadRequired = false
-			img.DeleteRequired = true
-			err = imageDb.SaveImageMetadata(img)
-			if err != nil {
-				return err
-			}
+	for _, metadata := range images {
+		if _, err := os.Stat(metadata.FullImagePath); !os.IsNotExist(err) {
+			logrus.Infof("%s is a duplicate of %s", file.Path, metadata.FullImagePath)
+			continue
+		}
+
+		logrus.Infof("%s was moved from %s", file.Path, metadata.FullImagePath)
+		previousCategory := metadata.CategoryPiwigoId
+		metadata.FullImagePath = file.Path
+		metadata.Filename = file.Name
+		assignCategory(&metadata, file, target)
+		// piwigo reports the content as unchanged and uploading only adds a category, so the image is moved explicitly
+		if metadata.PiwigoId > 0 && metadata.CategoryPiwigoId > 0 && metadata.CategoryPiwigoId != previousCategory {
+			metadata.CategoryUpdateRequired = true
 		}
+		// resetting the change date and the checksum makes sure the file gets hashed and checked against piwigo
+		metadata.LastChange = time.Time{}
+		metadata.Md5Sum = ""
+		return metadata, nil
+	}
+	return datastore.ImageMetaData{}, datastore.ErrorRecordNotFound
+}
+
+func assignCategory(metadata *datastore.ImageMetaData, file *localFileStructure.FilesystemNode, target MetadataTarget) {
+	metadata.CategoryPath = categoryKey.Parent(file.Key)
+	metadata.CategoryPiwigoId = 0
+
+	category, err := target.CategoryDb.GetCategoryByKey(metadata.CategoryPath)
+	if err == nil {
+		metadata.CategoryPiwigoId = category.PiwigoId
+	} else {
+		logrus.Warnf("No category found for image %s - %s", file.Path, err)
+	}
+}
+
+// Calculates the checksum of the file. Files that are not valid images are no error, they are only recorded with
+// the reason to keep them from being uploaded.
+func readFileContent(filePath string, content *fileContent, checksumCalculator fileChecksumCalculator) error {
+	checksums, err := checksumCalculator(filePath)
+
+	var invalid *localFileStructure.InvalidImageError
+	if errors.As(err, &invalid) {
+		content.read = true
+		content.invalidReason = invalid.Reason
+		return nil
+	}
+	if errors.Is(err, localFileStructure.ErrorFileNotStable) {
+		logrus.Infof("File %s is still being written. Checking it again on the next run", filePath)
+		return err
+	}
+	if err != nil {
+		logrus.Warnf("Could not calculate checksum for file %s. Skipping...", filePath)
+		return err
 	}
+
+	content.read = true
+	content.checksums = checksums
 	return nil
 }
 
-func fileDidNotChange(metadata *datastore.ImageMetaData, file *localFileStructure.FilesystemNode) bool {
-	return metadata.LastChange.Equal(file.ModTime) && !metadata.DeleteRequired
+// Copies the settings of the directory the file is in and reports if any of them changed. The info of the image
+// on piwigo has to be updated if the tags changed.
+func applyDirectorySettings(metadata *datastore.ImageMetaData, file *localFileStructure.FilesystemNode) bool {
+	tags := strings.Join(file.Settings.Tags, ",")
+	if metadata.Tags == tags && metadata.SkipUpload == file.Settings.SkipUpload {
+		return false
+	}
+
+	if metadata.Tags != tags {
+		metadata.Tags = tags
+		metadata.InfoUpdateRequired = true
+	}
+	metadata.SkipUpload = file.Settings.SkipUpload
+	return true
+}
+
+// The metadata is read again if the file or its sidecar changed since it was read the last time. This covers
+// sidecars that got added or removed as well.
+func fileMetadataIsOutdated(img *datastore.ImageMetaData, file *localFileStructure.FilesystemNode) bool {
+	return !img.MetadataChange.Equal(file.ModTime) || !img.SidecarChange.Equal(file.SidecarModTime)
+}
+
+// Reads the metadata once for all targets and copies it to the image. Broken metadata is no reason to skip the
+// image, so read errors are only logged and the image gets empty metadata.
+func applyFileMetadata(img *datastore.ImageMetaData, file *localFileStructure.FilesystemNode, content *fileContent, metadataReader fileMetadataReader) {
+	if !content.metadataRead {
+		fileMetadata, err := metadataReader(file.Path, file.SidecarPath)
+		if err != nil {
+			logrus.Warnf("Could not read the metadata of %s - %s", file.Path, err)
+		}
+		content.metadata = fileMetadata
+		content.metadataRead = true
+	}
+
+	img.Title = content.metadata.Title
+	img.Description = content.metadata.Description
+	img.Author = content.metadata.Author
+	img.Keywords = strings.Join(content.metadata.Keywords, ",")
+	img.Rating = content.metadata.Rating
+	img.CaptureDate = content.metadata.CaptureDate
+	img.HasLocation = content.metadata.HasLocation
+	img.Latitude = content.metadata.Latitude
+	img.Longitude = content.metadata.Longitude
+	img.MetadataChange = file.ModTime
+	img.SidecarChange = file.SidecarModTime
 }
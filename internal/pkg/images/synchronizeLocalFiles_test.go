@@ -9,9 +9,14 @@ package images
 //go:generate mockgen -destination=./datastore_mock_test.go -package=images git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/datastore ImageMetadataProvider,CategoryProvider
 
 import (
+	"errors"
+	"fmt"
 	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/datastore"
 	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/localFileStructure"
+	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/metadata"
 	"github.com/golang/mock/gomock"
+	"runtime"
+	"sync"
 	"testing"
 	"time"
 )
@@ -25,12 +30,12 @@ func Test_synchronize_local_image_metadata_should_find_nothing_if_empty(t *testi
 	categoryMock.EXPECT().GetCategoryByKey(category.Key).Return(category, nil).Times(0)
 
 	db := NewMockImageMetadataProvider(mockCtrl)
-	db.EXPECT().ImageMetadataAll().Times(1)
+	db.EXPECT().MarkImagesSeen(gomock.Any(), gomock.Any()).Times(0)
 	db.EXPECT().SaveImageMetadata(gomock.Any()).Times(0)
 
 	fileSystemNodes := map[string]*localFileStructure.FilesystemNode{}
 
-	err := SynchronizeLocalImageMetadata(db, categoryMock, fileSystemNodes, testChecksumCalculator)
+	_, err := SynchronizeLocalImageMetadata([]MetadataTarget{{ImageDb: db, CategoryDb: categoryMock}}, streamTestNodes(fileSystemNodes), testWorkers, testScanId, ChangeDetectionModTime, testChecksumCalculator, nil)
 	if err != nil {
 		t.Error(err)
 	}
@@ -59,12 +64,12 @@ func Test_synchronize_local_image_metadata_should_add_new_metadata(t *testing.T)
 	image.CategoryPath = category.Key
 
 	db := NewMockImageMetadataProvider(mockCtrl)
-	db.EXPECT().ImageMetadataAll().Times(1)
+	db.EXPECT().MarkImagesSeen([]string{testFileSystemNode.Path}, testScanId).Times(1)
 	db.EXPECT().ImageMetadata(testFileSystemNode.Key).Return(datastore.ImageMetaData{}, datastore.ErrorRecordNotFound).Times(1)
 	db.EXPECT().SaveImageMetadata(image).Times(1)
 
 	// execute the sync metadata based on the file system results
-	err := SynchronizeLocalImageMetadata(db, categoryMock, fileSystemNodes, testChecksumCalculator)
+	_, err := SynchronizeLocalImageMetadata([]MetadataTarget{{ImageDb: db, CategoryDb: categoryMock}}, streamTestNodes(fileSystemNodes), testWorkers, testScanId, ChangeDetectionModTime, testChecksumCalculator, nil)
 	if err != nil {
 		t.Error(err)
 	}
@@ -94,12 +99,12 @@ func Test_synchronize_local_image_metadata_should_mark_unchanged_entries_without
 	imageStored.DeleteRequired = true
 
 	db := NewMockImageMetadataProvider(mockCtrl)
-	db.EXPECT().ImageMetadataAll().Times(1)
+	db.EXPECT().MarkImagesSeen([]string{testFileSystemNode.Path}, testScanId).Times(1)
 	db.EXPECT().ImageMetadata(testFileSystemNode.Key).Return(imageStored, nil).Times(1)
 	db.EXPECT().SaveImageMetadata(imageExptected).Times(1)
 
 	// execute the sync metadata based on the file system results
-	err := SynchronizeLocalImageMetadata(db, categoryMock, fileSystemNodes, testChecksumCalculator)
+	_, err := SynchronizeLocalImageMetadata([]MetadataTarget{{ImageDb: db, CategoryDb: categoryMock}}, streamTestNodes(fileSystemNodes), testWorkers, testScanId, ChangeDetectionModTime, testChecksumCalculator, nil)
 	if err != nil {
 		t.Error(err)
 	}
@@ -131,12 +136,12 @@ func Test_synchronize_local_image_metadata_should_mark_changed_entries_as_upload
 	imageStored.LastChange = time.Date(2019, 01, 01, 00, 0, 0, 0, time.UTC)
 
 	db := NewMockImageMetadataProvider(mockCtrl)
-	db.EXPECT().ImageMetadataAll().Times(1)
+	db.EXPECT().MarkImagesSeen([]string{testFileSystemNode.Path}, testScanId).Times(1)
 	db.EXPECT().ImageMetadata(testFileSystemNode.Key).Return(imageStored, nil).Times(1)
 	db.EXPECT().SaveImageMetadata(imageExptected).Times(1)
 
 	// execute the sync metadata based on the file system results
-	err := SynchronizeLocalImageMetadata(db, categoryMock, fileSystemNodes, testChecksumCalculator)
+	_, err := SynchronizeLocalImageMetadata([]MetadataTarget{{ImageDb: db, CategoryDb: categoryMock}}, streamTestNodes(fileSystemNodes), testWorkers, testScanId, ChangeDetectionModTime, testChecksumCalculator, nil)
 	if err != nil {
 		t.Error(err)
 	}
@@ -168,17 +173,60 @@ func Test_synchronize_local_image_metadata_should_not_mark_unchanged_files_to_up
 	imageStored.LastChange = time.Date(2019, 01, 01, 01, 0, 0, 0, time.UTC)
 
 	db := NewMockImageMetadataProvider(mockCtrl)
-	db.EXPECT().ImageMetadataAll().Times(1)
+	db.EXPECT().MarkImagesSeen([]string{testFileSystemNode.Path}, testScanId).Times(1)
 	db.EXPECT().ImageMetadata(testFileSystemNode.Key).Return(imageStored, nil).Times(1)
 	db.EXPECT().SaveImageMetadata(imageExptected).Times(1)
 
 	// execute the sync metadata based on the file system results
-	err := SynchronizeLocalImageMetadata(db, categoryMock, fileSystemNodes, testChecksumCalculator)
+	_, err := SynchronizeLocalImageMetadata([]MetadataTarget{{ImageDb: db, CategoryDb: categoryMock}}, streamTestNodes(fileSystemNodes), testWorkers, testScanId, ChangeDetectionModTime, testChecksumCalculator, nil)
 	if err != nil {
 		t.Error(err)
 	}
 }
 
+func Test_synchronize_local_image_metadata_should_apply_changed_settings_of_unchanged_files(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	testFileSystemNode := &localFileStructure.FilesystemNode{
+		Key:      "2019/shooting1/abc.jpg",
+		ModTime:  time.Date(2019, 01, 01, 01, 0, 0, 0, time.UTC),
+		Name:     "abc.jpg",
+		Path:     "2019/shooting1/abc.jpg",
+		IsDir:    false,
+		Settings: localFileStructure.DirectorySettings{Tags: []string{"summer"}, SkipUpload: true}}
+
+	fileSystemNodes := map[string]*localFileStructure.FilesystemNode{}
+	fileSystemNodes[testFileSystemNode.Key] = testFileSystemNode
+
+	existingImage := createImageMetaDataFromFilesystem(testFileSystemNode, 0, true, false)
+
+	imageExpected := existingImage
+	imageExpected.UploadRequired = false
+	imageExpected.SkipUpload = true
+	imageExpected.Tags = "summer"
+	imageExpected.InfoUpdateRequired = true
+
+	db := NewMockImageMetadataProvider(mockCtrl)
+	db.EXPECT().MarkImagesSeen([]string{testFileSystemNode.Path}, testScanId).Times(1)
+	db.EXPECT().ImageMetadata(testFileSystemNode.Key).Return(existingImage, nil).Times(1)
+	db.EXPECT().SaveImageMetadata(imageExpected).Times(1)
+
+	calculations := 0
+	checksumCalculator := func(file string) (localFileStructure.FileChecksums, error) {
+		calculations++
+		return localFileStructure.FileChecksums{Md5: file}, nil
+	}
+
+	_, err := SynchronizeLocalImageMetadata([]MetadataTarget{{ImageDb: db}}, streamTestNodes(fileSystemNodes), testWorkers, testScanId, ChangeDetectionModTime, checksumCalculator, nil)
+	if err != nil {
+		t.Error(err)
+	}
+	if calculations != 0 {
+		t.Error("The checksum must not be calculated if only the settings changed")
+	}
+}
+
 func Test_synchronize_local_image_metadata_should_not_process_directories(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
@@ -198,40 +246,414 @@ func Test_synchronize_local_image_metadata_should_not_process_directories(t *tes
 	fileSystemNodes[testFileSystemNode.Key] = testFileSystemNode
 
 	db := NewMockImageMetadataProvider(mockCtrl)
-	db.EXPECT().ImageMetadataAll().Times(1)
+	db.EXPECT().MarkImagesSeen(gomock.Any(), gomock.Any()).Times(0)
 	db.EXPECT().SaveImageMetadata(gomock.Any()).Times(0)
 
 	// execute the sync metadata based on the file system results
-	err := SynchronizeLocalImageMetadata(db, categoryMock, fileSystemNodes, testChecksumCalculator)
+	_, err := SynchronizeLocalImageMetadata([]MetadataTarget{{ImageDb: db, CategoryDb: categoryMock}}, streamTestNodes(fileSystemNodes), testWorkers, testScanId, ChangeDetectionModTime, testChecksumCalculator, nil)
 	if err != nil {
 		t.Error(err)
 	}
 }
 
-func Test_synchronizeLocalImageMetadataFindFilesToDelete(t *testing.T) {
+func Test_synchronize_local_image_metadata_should_hash_as_many_files_in_parallel_as_there_are_workers(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
 
-	img := createTestImageMetaData(5)
-	images := []datastore.ImageMetaData{img}
+	// more workers than CPUs are used for slow storage, so the pool must not be limited by the CPUs
+	workers := runtime.NumCPU() + 2
+
+	fileSystemNodes := map[string]*localFileStructure.FilesystemNode{}
+	db := NewMockImageMetadataProvider(mockCtrl)
+	for i := 0; i < workers; i++ {
+		node := &localFileStructure.FilesystemNode{Key: fmt.Sprintf("2019/shooting1/%d.jpg", i), Name: fmt.Sprintf("%d.jpg", i), Path: fmt.Sprintf("2019/shooting1/%d.jpg", i)}
+		fileSystemNodes[node.Key] = node
+		db.EXPECT().ImageMetadata(node.Path).Return(createImageMetaDataFromFilesystem(node, 1, false, false), nil).Times(1)
+	}
+	db.EXPECT().MarkImagesSeen(gomock.Any(), testScanId).AnyTimes()
+
+	started := sync.WaitGroup{}
+	started.Add(workers)
+	allStarted := make(chan struct{})
+	go func() {
+		started.Wait()
+		close(allStarted)
+	}()
+	blockingChecksumCalculator := func(file string) (localFileStructure.FileChecksums, error) {
+		started.Done()
+		select {
+		case <-allStarted:
+		case <-time.After(5 * time.Second):
+		}
+		return localFileStructure.FileChecksums{}, localFileStructure.ErrorFileNotStable
+	}
+
+	pending, err := SynchronizeLocalImageMetadata([]MetadataTarget{{ImageDb: db}}, streamTestNodes(fileSystemNodes), workers, testScanId, ChangeDetectionHash, blockingChecksumCalculator, nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	select {
+	case <-allStarted:
+	default:
+		t.Errorf("Expected %d files to be hashed in parallel", workers)
+	}
+	if len(pending) != workers {
+		t.Errorf("Expected %d pending files but got %v", workers, pending)
+	}
+}
+
+func Test_synchronize_local_image_metadata_should_calculate_checksum_once_for_all_targets(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	category := datastore.CategoryData{CategoryId: 1, Name: "shooting1", PiwigoId: 1, Key: "2019/shooting1"}
+
+	testFileSystemNode := &localFileStructure.FilesystemNode{
+		Key:     "2019/shooting1/abc.jpg",
+		ModTime: time.Date(2019, 01, 01, 01, 0, 0, 0, time.UTC),
+		Name:    "abc.jpg",
+		Path:    "2019/shooting1/abc.jpg",
+		IsDir:   false}
+
+	fileSystemNodes := map[string]*localFileStructure.FilesystemNode{}
+	fileSystemNodes[testFileSystemNode.Key] = testFileSystemNode
+
+	image := createImageMetaDataFromFilesystem(testFileSystemNode, 0, true, false)
+	image.CategoryPiwigoId = category.PiwigoId
+	image.CategoryPath = category.Key
+
+	var targets []MetadataTarget
+	for i := 0; i < 2; i++ {
+		categoryMock := NewMockCategoryProvider(mockCtrl)
+		categoryMock.EXPECT().GetCategoryByKey(category.Key).Return(category, nil).Times(1)
+
+		db := NewMockImageMetadataProvider(mockCtrl)
+		db.EXPECT().MarkImagesSeen([]string{testFileSystemNode.Path}, testScanId).Times(1)
+		db.EXPECT().ImageMetadata(testFileSystemNode.Key).Return(datastore.ImageMetaData{}, datastore.ErrorRecordNotFound).Times(1)
+		db.EXPECT().SaveImageMetadata(image).Times(1)
+
+		targets = append(targets, MetadataTarget{ImageDb: db, CategoryDb: categoryMock})
+	}
+
+	calculations := 0
+	countingChecksumCalculator := func(file string) (localFileStructure.FileChecksums, error) {
+		calculations++
+		return testChecksumCalculator(file)
+	}
+
+	_, err := SynchronizeLocalImageMetadata(targets, streamTestNodes(fileSystemNodes), testWorkers, testScanId, ChangeDetectionModTime, countingChecksumCalculator, nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if calculations != 1 {
+		t.Errorf("Checksum got calculated %d times but expected only once for all targets", calculations)
+	}
+}
 
-	imgToSave := img
-	imgToSave.UploadRequired = false
-	imgToSave.DeleteRequired = true
+func Test_MarkRemovedImages_marks_images_of_older_scans(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
 
 	dbmock := NewMockImageMetadataProvider(mockCtrl)
-	dbmock.EXPECT().ImageMetadataAll().Times(1).Return(images, nil)
-	dbmock.EXPECT().SaveImageMetadata(imgToSave).Times(1)
+	dbmock.EXPECT().MarkUnseenImagesForDeletion(testScanId).Return(int64(1), nil).Times(1)
 
-	err := synchronizeLocalImageMetadataFindFilesToDelete(dbmock)
+	err := MarkRemovedImages(dbmock, testScanId)
 	if err != nil {
 		t.Error(err)
 	}
 }
 
+func Test_synchronize_local_image_metadata_should_fail_if_images_could_not_be_marked_as_seen(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	testFileSystemNode := &localFileStructure.FilesystemNode{
+		Key:     "2019/shooting1/abc.jpg",
+		ModTime: time.Date(2019, 01, 01, 01, 0, 0, 0, time.UTC),
+		Name:    "abc.jpg",
+		Path:    "2019/shooting1/abc.jpg",
+		IsDir:   false}
+
+	fileSystemNodes := map[string]*localFileStructure.FilesystemNode{testFileSystemNode.Key: testFileSystemNode}
+
+	image := createImageMetaDataFromFilesystem(testFileSystemNode, 1, false, false)
+
+	db := NewMockImageMetadataProvider(mockCtrl)
+	db.EXPECT().ImageMetadata(testFileSystemNode.Path).Return(image, nil).Times(1)
+	db.EXPECT().MarkImagesSeen([]string{testFileSystemNode.Path}, testScanId).Return(errors.New("database locked")).Times(1)
+
+	_, err := SynchronizeLocalImageMetadata([]MetadataTarget{{ImageDb: db}}, streamTestNodes(fileSystemNodes), testWorkers, testScanId, ChangeDetectionModTime, testChecksumCalculator, nil)
+	if err == nil {
+		t.Error("Expected an error as the deletion detection would be wrong")
+	}
+}
+
+func Test_synchronize_local_image_metadata_should_report_files_still_being_written_as_pending(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	testFileSystemNode := &localFileStructure.FilesystemNode{
+		Key:     "2019/shooting1/abc.jpg",
+		ModTime: time.Date(2019, 01, 01, 02, 0, 0, 0, time.UTC),
+		Name:    "abc.jpg",
+		Path:    "2019/shooting1/abc.jpg",
+		IsDir:   false}
+
+	fileSystemNodes := map[string]*localFileStructure.FilesystemNode{testFileSystemNode.Key: testFileSystemNode}
+
+	image := createImageMetaDataFromFilesystem(testFileSystemNode, 1, false, false)
+	image.LastChange = time.Date(2019, 01, 01, 01, 0, 0, 0, time.UTC)
+
+	db := NewMockImageMetadataProvider(mockCtrl)
+	db.EXPECT().ImageMetadata(testFileSystemNode.Path).Return(image, nil).Times(1)
+	db.EXPECT().SaveImageMetadata(gomock.Any()).Times(0)
+	// the image must not be marked for deletion while it is being replaced
+	db.EXPECT().MarkImagesSeen([]string{testFileSystemNode.Path}, testScanId).Times(1)
+
+	unstableChecksumCalculator := func(file string) (localFileStructure.FileChecksums, error) {
+		return localFileStructure.FileChecksums{}, localFileStructure.ErrorFileNotStable
+	}
+
+	pending, err := SynchronizeLocalImageMetadata([]MetadataTarget{{ImageDb: db}}, streamTestNodes(fileSystemNodes), testWorkers, testScanId, ChangeDetectionModTime, unstableChecksumCalculator, nil)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(pending) != 1 || pending[0] != testFileSystemNode.Path {
+		t.Errorf("Expected the file to be pending but got %v", pending)
+	}
+}
+
+func Test_synchronize_local_image_metadata_should_record_invalid_images_without_upload(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	testFileSystemNode := &localFileStructure.FilesystemNode{
+		Key:     "2019/shooting1/abc.jpg",
+		ModTime: time.Date(2019, 01, 01, 02, 0, 0, 0, time.UTC),
+		Name:    "abc.jpg",
+		Path:    "2019/shooting1/abc.jpg",
+		IsDir:   false}
+
+	fileSystemNodes := map[string]*localFileStructure.FilesystemNode{testFileSystemNode.Key: testFileSystemNode}
+
+	image := createImageMetaDataFromFilesystem(testFileSystemNode, 0, false, false)
+	image.LastChange = time.Date(2019, 01, 01, 01, 0, 0, 0, time.UTC)
+
+	expected := image
+	expected.LastChange = testFileSystemNode.ModTime
+	expected.UploadRequired = false
+	expected.InvalidReason = "empty file"
+
+	db := NewMockImageMetadataProvider(mockCtrl)
+	db.EXPECT().ImageMetadata(testFileSystemNode.Path).Return(image, nil).Times(1)
+	db.EXPECT().SaveImageMetadata(expected).Times(1)
+	db.EXPECT().MarkImagesSeen([]string{testFileSystemNode.Path}, testScanId).Times(1)
+
+	invalidChecksumCalculator := func(file string) (localFileStructure.FileChecksums, error) {
+		return localFileStructure.FileChecksums{}, &localFileStructure.InvalidImageError{Reason: "empty file"}
+	}
+
+	pending, err := SynchronizeLocalImageMetadata([]MetadataTarget{{ImageDb: db}}, streamTestNodes(fileSystemNodes), testWorkers, testScanId, ChangeDetectionModTime, invalidChecksumCalculator, nil)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("Expected the invalid image not to be pending but got %v", pending)
+	}
+}
+
+func Test_synchronize_local_image_metadata_should_replace_the_uploaded_image_of_a_less_preferred_variant(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	testFileSystemNode := &localFileStructure.FilesystemNode{
+		Key:      "2019/shooting1/abc-edit.jpg",
+		ModTime:  time.Date(2019, 01, 01, 02, 0, 0, 0, time.UTC),
+		Name:     "abc-edit.jpg",
+		Path:     "2019/shooting1/abc-edit.jpg",
+		IsDir:    false,
+		Variants: []string{"2019/shooting1/abc.jpg"}}
+
+	fileSystemNodes := map[string]*localFileStructure.FilesystemNode{testFileSystemNode.Key: testFileSystemNode}
+
+	variant := createTestImageMetaData(5)
+	variant.FullImagePath = "2019/shooting1/abc.jpg"
+	variant.Filename = "abc.jpg"
+
+	expected := variant
+	expected.FullImagePath = testFileSystemNode.Path
+	expected.Filename = testFileSystemNode.Name
+	expected.Md5Sum = testFileSystemNode.Path
+	expected.LastChange = testFileSystemNode.ModTime
+	expected.UploadRequired = true
+
+	db := NewMockImageMetadataProvider(mockCtrl)
+	db.EXPECT().ImageMetadata(testFileSystemNode.Path).Return(datastore.ImageMetaData{}, datastore.ErrorRecordNotFound).Times(1)
+	db.EXPECT().ImageMetadata(variant.FullImagePath).Return(variant, nil).Times(1)
+	db.EXPECT().SaveImageMetadata(expected).Times(1)
+	db.EXPECT().MarkImagesSeen([]string{testFileSystemNode.Path}, testScanId).Times(1)
+
+	_, err := SynchronizeLocalImageMetadata([]MetadataTarget{{ImageDb: db}}, streamTestNodes(fileSystemNodes), testWorkers, testScanId, ChangeDetectionModTime, testChecksumCalculator, nil)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_synchronize_local_image_metadata_should_update_the_metadata_of_a_changed_sidecar_without_upload(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	testFileSystemNode := &localFileStructure.FilesystemNode{
+		Key:            "2019/shooting1/abc.jpg",
+		ModTime:        time.Date(2019, 01, 01, 01, 0, 0, 0, time.UTC),
+		Name:           "abc.jpg",
+		Path:           "2019/shooting1/abc.jpg",
+		IsDir:          false,
+		SidecarPath:    "2019/shooting1/abc.xmp",
+		SidecarModTime: time.Date(2019, 01, 02, 01, 0, 0, 0, time.UTC)}
+
+	fileSystemNodes := map[string]*localFileStructure.FilesystemNode{testFileSystemNode.Key: testFileSystemNode}
+
+	image := createImageMetaDataFromFilesystem(testFileSystemNode, 5, false, false)
+	image.Title = "Old title"
+	image.MetadataChange = testFileSystemNode.ModTime
+	image.SidecarChange = time.Date(2019, 01, 01, 01, 0, 0, 0, time.UTC)
+
+	expected := image
+	expected.Title = "New title"
+	expected.Keywords = "lake,sunset"
+	expected.SidecarChange = testFileSystemNode.SidecarModTime
+
+	db := NewMockImageMetadataProvider(mockCtrl)
+	db.EXPECT().ImageMetadata(testFileSystemNode.Path).Return(image, nil).Times(1)
+	db.EXPECT().SaveImageMetadata(expected).Times(1)
+	db.EXPECT().MarkImagesSeen([]string{testFileSystemNode.Path}, testScanId).Times(1)
+
+	checksumCalculator := func(file string) (localFileStructure.FileChecksums, error) {
+		t.Errorf("Expected %s not to be hashed as only the sidecar changed", file)
+		return localFileStructure.FileChecksums{}, nil
+	}
+	metadataReader := func(filePath string, sidecarPath string) (metadata.Metadata, error) {
+		if sidecarPath != testFileSystemNode.SidecarPath {
+			t.Errorf("Expected the sidecar %s but got %s", testFileSystemNode.SidecarPath, sidecarPath)
+		}
+		return metadata.Metadata{Title: "New title", Keywords: []string{"lake", "sunset"}}, nil
+	}
+
+	_, err := SynchronizeLocalImageMetadata([]MetadataTarget{{ImageDb: db}}, streamTestNodes(fileSystemNodes), testWorkers, testScanId, ChangeDetectionModTime, checksumCalculator, metadataReader)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_synchronize_local_image_metadata_should_take_over_the_image_a_file_was_moved_from(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	category := datastore.CategoryData{CategoryId: 2, Name: "shooting2", PiwigoId: 7, Key: "2019/shooting2"}
+	categoryMock := NewMockCategoryProvider(mockCtrl)
+	categoryMock.EXPECT().GetCategoryByKey(category.Key).Return(category, nil).Times(1)
+
+	testFileSystemNode := &localFileStructure.FilesystemNode{
+		Key:     "2019/shooting2/abc.jpg",
+		ModTime: time.Date(2019, 01, 01, 01, 0, 0, 0, time.UTC),
+		Name:    "abc.jpg",
+		Path:    "2019/shooting2/abc.jpg",
+		IsDir:   false}
+
+	fileSystemNodes := map[string]*localFileStructure.FilesystemNode{testFileSystemNode.Key: testFileSystemNode}
+
+	moved := createTestImageMetaData(5)
+	moved.StrongSum = "sha256:" + testFileSystemNode.Path
+	moved.CategoryPath = "2019/shooting1"
+	moved.UploadRequired = false
+
+	expected := moved
+	expected.FullImagePath = testFileSystemNode.Path
+	expected.Filename = testFileSystemNode.Name
+	expected.CategoryPath = category.Key
+	expected.CategoryPiwigoId = category.PiwigoId
+	expected.Md5Sum = testFileSystemNode.Path
+	expected.LastChange = testFileSystemNode.ModTime
+	expected.UploadRequired = true
+	expected.CategoryUpdateRequired = true
+
+	db := NewMockImageMetadataProvider(mockCtrl)
+	db.EXPECT().ImageMetadata(testFileSystemNode.Path).Return(datastore.ImageMetaData{}, datastore.ErrorRecordNotFound).Times(1)
+	db.EXPECT().ImageMetadataByStrongSum(moved.StrongSum).Return([]datastore.ImageMetaData{moved}, nil).Times(1)
+	db.EXPECT().SaveImageMetadata(expected).Times(1)
+	db.EXPECT().MarkImagesSeen([]string{testFileSystemNode.Path}, testScanId).Times(1)
+
+	_, err := SynchronizeLocalImageMetadata([]MetadataTarget{{ImageDb: db, CategoryDb: categoryMock}}, streamTestNodes(fileSystemNodes), testWorkers, testScanId, ChangeDetectionModTime, testStrongChecksumCalculator, nil)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_synchronize_local_image_metadata_should_add_duplicates_as_new_images(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	category := datastore.CategoryData{CategoryId: 1, Name: "shooting1", PiwigoId: 1, Key: "2019/shooting1"}
+	categoryMock := NewMockCategoryProvider(mockCtrl)
+	categoryMock.EXPECT().GetCategoryByKey(category.Key).Return(category, nil).Times(1)
+
+	testFileSystemNode := &localFileStructure.FilesystemNode{
+		Key:     "2019/shooting1/copy.jpg",
+		ModTime: time.Date(2019, 01, 01, 01, 0, 0, 0, time.UTC),
+		Name:    "copy.jpg",
+		Path:    "2019/shooting1/copy.jpg",
+		IsDir:   false}
+
+	fileSystemNodes := map[string]*localFileStructure.FilesystemNode{testFileSystemNode.Key: testFileSystemNode}
+
+	// the file of the original still exists
+	original := createTestImageMetaData(5)
+	original.FullImagePath = "../../../test/md5testfile.txt"
+	original.StrongSum = "sha256:" + testFileSystemNode.Path
+
+	expected := createImageMetaDataFromFilesystem(testFileSystemNode, 0, true, false)
+	expected.FullImagePath = testFileSystemNode.Path
+	expected.CategoryPath = category.Key
+	expected.CategoryPiwigoId = category.PiwigoId
+	expected.StrongSum = original.StrongSum
+
+	db := NewMockImageMetadataProvider(mockCtrl)
+	db.EXPECT().ImageMetadata(testFileSystemNode.Path).Return(datastore.ImageMetaData{}, datastore.ErrorRecordNotFound).Times(1)
+	db.EXPECT().ImageMetadataByStrongSum(original.StrongSum).Return([]datastore.ImageMetaData{original}, nil).Times(1)
+	db.EXPECT().SaveImageMetadata(expected).Times(1)
+	db.EXPECT().MarkImagesSeen([]string{testFileSystemNode.Path}, testScanId).Times(1)
+
+	_, err := SynchronizeLocalImageMetadata([]MetadataTarget{{ImageDb: db, CategoryDb: categoryMock}}, streamTestNodes(fileSystemNodes), testWorkers, testScanId, ChangeDetectionModTime, testStrongChecksumCalculator, nil)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+const testScanId = int64(42)
+
+const testWorkers = 4
+
+// sends the nodes like the scanner does and closes the channel afterwards
+func streamTestNodes(fileSystemNodes map[string]*localFileStructure.FilesystemNode) <-chan *localFileStructure.FilesystemNode {
+	nodes := make(chan *localFileStructure.FilesystemNode, len(fileSystemNodes))
+	for _, node := range fileSystemNodes {
+		nodes <- node
+	}
+	close(nodes)
+	return nodes
+}
+
 // to make the sync testable, we pass in a simple mock that returns the filepath as checksum
-func testChecksumCalculator(file string) (string, error) {
-	return file, nil
+func testChecksumCalculator(file string) (localFileStructure.FileChecksums, error) {
+	return localFileStructure.FileChecksums{Md5: file}, nil
+}
+
+// like testChecksumCalculator, but with the filepath as strong checksum as well
+func testStrongChecksumCalculator(file string) (localFileStructure.FileChecksums, error) {
+	return localFileStructure.FileChecksums{Md5: file, Strong: "sha256:" + file}, nil
 }
 
 func createTestImageMetaData(piwigoId int) datastore.ImageMetaData {
@@ -7,9 +7,11 @@ package images
 
 import (
 	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/datastore"
+	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/localFileStructure"
 	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/piwigo"
 	"github.com/golang/mock/gomock"
 	"testing"
+	"time"
 )
 
 func Test_checkPiwigoForChangedImages_none_with_piwigoId(t *testing.T) {
@@ -200,3 +202,67 @@ func Test_updatePiwigoIdIfAlreadyUploaded_with_image_to_check_missing_on_server(
 		t.Error(err)
 	}
 }
+
+func Test_moved_image_should_be_moved_to_the_category_of_the_new_album(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	category := datastore.CategoryData{CategoryId: 2, Name: "shooting2", PiwigoId: 7, Key: "2019/shooting2"}
+	categoryMock := NewMockCategoryProvider(mockCtrl)
+	categoryMock.EXPECT().GetCategoryByKey(category.Key).Return(category, nil).Times(1)
+
+	testFileSystemNode := &localFileStructure.FilesystemNode{
+		Key:     "2019/shooting2/abc.jpg",
+		ModTime: time.Date(2019, 01, 01, 01, 0, 0, 0, time.UTC),
+		Name:    "abc.jpg",
+		Path:    "2019/shooting2/abc.jpg",
+		IsDir:   false}
+	fileSystemNodes := map[string]*localFileStructure.FilesystemNode{testFileSystemNode.Key: testFileSystemNode}
+
+	moved := createTestImageMetaData(5)
+	moved.StrongSum = "sha256:" + testFileSystemNode.Path
+	moved.CategoryPath = "2019/shooting1"
+	moved.UploadRequired = false
+
+	// the records saved by the local synchronization are the pending ones of the piwigo synchronization
+	var saved datastore.ImageMetaData
+	db := NewMockImageMetadataProvider(mockCtrl)
+	db.EXPECT().ImageMetadata(testFileSystemNode.Path).Return(datastore.ImageMetaData{}, datastore.ErrorRecordNotFound).Times(1)
+	db.EXPECT().ImageMetadataByStrongSum(moved.StrongSum).Return([]datastore.ImageMetaData{moved}, nil).Times(1)
+	db.EXPECT().MarkImagesSeen([]string{testFileSystemNode.Path}, testScanId).Times(1)
+	db.EXPECT().SaveImageMetadata(gomock.Any()).Do(func(img datastore.ImageMetaData) {
+		saved = img
+	}).Times(3)
+	db.EXPECT().ImageMetadataToUpload().DoAndReturn(func() ([]datastore.ImageMetaData, error) {
+		return []datastore.ImageMetaData{saved}, nil
+	}).Times(2)
+	db.EXPECT().ImageMetadataToUpdateInfo().DoAndReturn(func() ([]datastore.ImageMetaData, error) {
+		return []datastore.ImageMetaData{saved}, nil
+	}).Times(1)
+
+	piwigomock := NewMockImageApi(mockCtrl)
+	piwigomock.EXPECT().ImageCheckFile(5, testFileSystemNode.Path).Return(piwigo.ImageStateUptodate, nil).Times(1)
+	piwigomock.EXPECT().SetImageCategory(5, category.PiwigoId).Return(nil).Times(1)
+	piwigomock.EXPECT().SetImageTags(gomock.Any(), gomock.Any()).Times(0)
+
+	_, err := SynchronizeLocalImageMetadata([]MetadataTarget{{ImageDb: db, CategoryDb: categoryMock}}, streamTestNodes(fileSystemNodes), testWorkers, testScanId, ChangeDetectionModTime, testStrongChecksumCalculator, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = SynchronizePiwigoMetadata(piwigomock, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.UploadRequired || !saved.CategoryUpdateRequired {
+		t.Fatalf("Expected the unchanged image to skip the upload but keep the move, got %+v", saved)
+	}
+
+	err = UpdateImageInfo(piwigomock, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.CategoryUpdateRequired || saved.CategoryPiwigoId != category.PiwigoId {
+		t.Errorf("Expected the image to be moved to category %d, got %+v", category.PiwigoId, saved)
+	}
+}
@@ -0,0 +1,159 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package images
+
+import (
+	"errors"
+	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/datastore"
+	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/localFileStructure"
+	"github.com/sirupsen/logrus"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ChecksumMismatch is a file whose content differs from the stored checksum.
+type ChecksumMismatch struct {
+	Path       string
+	Stored     string
+	Calculated string
+	// Changed is set if the fingerprint of the file changed as well, so the next synchronization picks up the new
+	// content. Otherwise, the content changed behind the back of the uploader or the file got corrupted.
+	Changed bool
+}
+
+type verifyResult struct {
+	verified   int
+	mismatches []ChecksumMismatch
+	err        error
+}
+
+// VerifyChecksums hashes all files with a stored checksum and compares the checksums with the stored ones. Nothing
+// gets saved. New files and files that are still being written are skipped. Returns the mismatches ordered by path
+// and the number of verified files. The files are hashed by the given number of workers.
+func VerifyChecksums(targets []MetadataTarget, files <-chan *localFileStructure.FilesystemNode, workers int, checksumCalculator fileChecksumCalculator) ([]ChecksumMismatch, int, error) {
+	logrus.Debug("Starting VerifyChecksums")
+	defer logrus.Debug("Leaving VerifyChecksums")
+
+	logrus.Info("Verifying the checksums of the local files")
+
+	results := make(chan verifyResult, workers)
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go verifyChecksumsWorker(files, &wg, targets, checksumCalculator, results)
+	}
+
+	wg.Wait()
+	close(results)
+
+	var mismatches []ChecksumMismatch
+	verified := 0
+	var err error
+	for result := range results {
+		mismatches = append(mismatches, result.mismatches...)
+		verified += result.verified
+		if err == nil {
+			err = result.err
+		}
+	}
+	sort.Slice(mismatches, func(i, j int) bool {
+		return mismatches[i].Path < mismatches[j].Path
+	})
+
+	return mismatches, verified, err
+}
+
+func verifyChecksumsWorker(files <-chan *localFileStructure.FilesystemNode, waitGroup *sync.WaitGroup, targets []MetadataTarget, checksumCalculator fileChecksumCalculator, results chan<- verifyResult) {
+	defer waitGroup.Done()
+
+	result := verifyResult{}
+	for file := range files {
+		// the remaining files still have to be read so the scan does not block
+		if file.IsDir || result.err != nil {
+			continue
+		}
+
+		mismatch, verified, err := verifyFileChecksums(file, targets, checksumCalculator)
+		if err != nil {
+			logrus.Errorf("Could not verify %s - %s", file.Path, err)
+			result.err = err
+			continue
+		}
+		if verified {
+			result.verified++
+		}
+		if mismatch == nil {
+			continue
+		}
+		if mismatch.Changed {
+			logrus.Infof("The content of %s changed since the last synchronization. Stored %s, calculated %s", mismatch.Path, mismatch.Stored, mismatch.Calculated)
+		} else {
+			logrus.Warnf("The content of %s changed without changing its fingerprint. Stored %s, calculated %s", mismatch.Path, mismatch.Stored, mismatch.Calculated)
+		}
+		result.mismatches = append(result.mismatches, *mismatch)
+	}
+	results <- result
+}
+
+// The file is hashed once and compared with the records of all targets.
+func verifyFileChecksums(file *localFileStructure.FilesystemNode, targets []MetadataTarget, checksumCalculator fileChecksumCalculator) (*ChecksumMismatch, bool, error) {
+	var checksums *localFileStructure.FileChecksums
+	for _, target := range targets {
+		metadata, err := target.ImageDb.ImageMetadata(file.Path)
+		if err == datastore.ErrorRecordNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, false, err
+		}
+
+		if metadata.Md5Sum == "" || metadata.InvalidReason != "" {
+			logrus.Debugf("Skipping the verification of %s as it has no valid checksum yet", file.Path)
+			continue
+		}
+
+		if checksums == nil {
+			calculated, err := checksumCalculator(file.Path)
+			if errors.Is(err, localFileStructure.ErrorFileNotStable) {
+				logrus.Infof("Skipping the verification of %s as it is still being written", file.Path)
+				return nil, false, nil
+			}
+			if err != nil {
+				logrus.Warnf("Could not verify the checksum of %s - %s", file.Path, err)
+				return nil, false, nil
+			}
+			checksums = &calculated
+		}
+
+		mismatch := compareChecksums(&metadata, *checksums)
+		if mismatch != nil {
+			mismatch.Changed = !fileDidNotChange(&metadata, file, ChangeDetectionFingerprint)
+			return mismatch, true, nil
+		}
+	}
+	return nil, checksums != nil, nil
+}
+
+func compareChecksums(metadata *datastore.ImageMetaData, checksums localFileStructure.FileChecksums) *ChecksumMismatch {
+	if metadata.Md5Sum != checksums.Md5 {
+		return &ChecksumMismatch{Path: metadata.FullImagePath, Stored: metadata.Md5Sum, Calculated: checksums.Md5}
+	}
+	if sameStrongHash(metadata.StrongSum, checksums.Strong) && metadata.StrongSum != checksums.Strong {
+		return &ChecksumMismatch{Path: metadata.FullImagePath, Stored: metadata.StrongSum, Calculated: checksums.Strong}
+	}
+	return nil
+}
+
+// Strong checksums are only comparable if both got calculated using the same algorithm.
+func sameStrongHash(stored string, calculated string) bool {
+	separator := strings.Index(stored, ":")
+	if separator < 0 || calculated == "" {
+		return false
+	}
+	return strings.HasPrefix(calculated, stored[:separator+1])
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package images
+
+import (
+	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/localFileStructure"
+	"github.com/golang/mock/gomock"
+	"testing"
+	"time"
+)
+
+func Test_VerifyChecksums_should_report_files_with_another_checksum(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	corrupted := createFingerprintTestNode()
+	intact := createFingerprintTestNode()
+	intact.Key = "2019/shooting1/intact.jpg"
+	intact.Path = intact.Key
+	changed := createFingerprintTestNode()
+	changed.Key = "2019/shooting1/changed.jpg"
+	changed.Path = changed.Key
+
+	fileSystemNodes := map[string]*localFileStructure.FilesystemNode{corrupted.Key: corrupted, intact.Key: intact, changed.Key: changed}
+
+	corruptedStored := createImageMetaDataFromFilesystem(corrupted, 5, false, false)
+	corruptedStored.Md5Sum = "good content"
+	changedStored := createImageMetaDataFromFilesystem(changed, 5, false, false)
+	changedStored.Md5Sum = "old content"
+	changedStored.LastChange = changed.ModTime.Add(-time.Hour)
+
+	db := NewMockImageMetadataProvider(mockCtrl)
+	db.EXPECT().ImageMetadata(corrupted.Path).Return(corruptedStored, nil).Times(1)
+	db.EXPECT().ImageMetadata(intact.Path).Return(createImageMetaDataFromFilesystem(intact, 5, false, false), nil).Times(1)
+	db.EXPECT().ImageMetadata(changed.Path).Return(changedStored, nil).Times(1)
+	db.EXPECT().SaveImageMetadata(gomock.Any()).Times(0)
+
+	calculations := 0
+	checksumCalculator := func(file string) (localFileStructure.FileChecksums, error) {
+		calculations++
+		return testChecksumCalculator(file)
+	}
+
+	mismatches, verified, err := VerifyChecksums([]MetadataTarget{{ImageDb: db}}, streamTestNodes(fileSystemNodes), testWorkers, checksumCalculator)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []ChecksumMismatch{
+		{Path: corrupted.Path, Stored: "good content", Calculated: corrupted.Path},
+		{Path: changed.Path, Stored: "old content", Calculated: changed.Path, Changed: true},
+	}
+	if len(mismatches) != 2 || mismatches[0] != expected[0] || mismatches[1] != expected[1] {
+		t.Errorf("Expected the mismatches %v but got %v", expected, mismatches)
+	}
+	if verified != 3 || calculations != 3 {
+		t.Errorf("Expected all three files to be verified but got %d verified and %d hashed", verified, calculations)
+	}
+}
+
+func Test_sameStrongHash(t *testing.T) {
+	if !sameStrongHash("sha256:aa", "sha256:bb") {
+		t.Error("Expected checksums of the same algorithm to be comparable")
+	}
+	if sameStrongHash("sha256:aa", "blake2b:aa") || sameStrongHash("", "sha256:aa") || sameStrongHash("sha256:aa", "") {
+		t.Error("Expected checksums of different or missing algorithms not to be comparable")
+	}
+}
@@ -5,13 +5,20 @@
 
 package localFileStructure
 
-import "testing"
+import (
+	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/categoryKey"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
 
-func Test_ScanLocalFileStructure_should_find_testfile(t *testing.T) {
+func Test_StreamLocalFileStructures_should_find_testfile(t *testing.T) {
 	supportedExtensions := make([]string, 0)
 	supportedExtensions = append(supportedExtensions, "jpg")
 
-	images, err := ScanLocalFileStructure("../../../test/", supportedExtensions, make([]string, 0), 0)
+	images, err := scanLocalFileStructure("../../../test/", supportedExtensions, make([]string, 0), 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -32,11 +39,11 @@ func Test_ScanLocalFileStructure_should_find_testfile(t *testing.T) {
 	}
 }
 
-func Test_ScanLocalFileStructure_should_find_testfile_with_trimmed_folder(t *testing.T) {
+func Test_StreamLocalFileStructures_should_find_testfile_with_trimmed_folder(t *testing.T) {
 	supportedExtensions := make([]string, 0)
 	supportedExtensions = append(supportedExtensions, "jpg")
 
-	images, err := ScanLocalFileStructure("../../../test/", supportedExtensions, make([]string, 0), 1)
+	images, err := scanLocalFileStructure("../../../test/", supportedExtensions, make([]string, 0), 1)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -66,13 +73,13 @@ func Test_ScanLocalFileStructure_should_find_testfile_with_trimmed_folder(t *tes
 
 }
 
-func Test_ScanLocalFileStructure_should_ignore_test_directory(t *testing.T) {
+func Test_StreamLocalFileStructures_should_ignore_test_directory(t *testing.T) {
 	supportedExtensions := make([]string, 0)
 	supportedExtensions = append(supportedExtensions, "jpg")
 
 	ignores := make([]string, 0)
 	ignores = append(ignores, "images")
-	images, err := ScanLocalFileStructure("../../../test/", supportedExtensions, ignores, 0)
+	images, err := scanLocalFileStructure("../../../test/", supportedExtensions, ignores, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -82,11 +89,11 @@ func Test_ScanLocalFileStructure_should_ignore_test_directory(t *testing.T) {
 	}
 }
 
-func Test_ScanLocalFileStructure_should_not_find_jpg_when_only_png_supported(t *testing.T) {
+func Test_StreamLocalFileStructures_should_not_find_jpg_when_only_png_supported(t *testing.T) {
 	supportedExtensions := make([]string, 0)
 	supportedExtensions = append(supportedExtensions, "png")
 
-	images, err := ScanLocalFileStructure("../../../test/", supportedExtensions, make([]string, 0), 0)
+	images, err := scanLocalFileStructure("../../../test/", supportedExtensions, make([]string, 0), 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -106,3 +113,261 @@ func Test_ScanLocalFileStructure_should_not_find_jpg_when_only_png_supported(t *
 		t.Errorf("Did find the testimage. This should not happen as png is searched but jpg found")
 	}
 }
+
+func Test_StreamLocalFileStructures_should_add_album_prefix(t *testing.T) {
+	rootPath := createTestTree(t, "2019/img.jpg", "root.jpg")
+	defer os.RemoveAll(rootPath)
+
+	roots := []ScanRoot{{Path: rootPath, AlbumPrefix: "Phones/Anna"}}
+	nodes, err := scanLocalFileStructures(roots, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := make(map[string]bool)
+	for _, node := range nodes {
+		keys[node.Key] = node.IsDir
+	}
+
+	expected := map[string]bool{
+		"Phones":                   true,
+		"Phones/Anna":              true,
+		"Phones/Anna/root.jpg":     false,
+		"Phones/Anna/2019":         true,
+		"Phones/Anna/2019/img.jpg": false,
+	}
+	for key, isDir := range expected {
+		nodeIsDir, found := keys[key]
+		if !found || nodeIsDir != isDir {
+			t.Errorf("Did not find the expected node %s", key)
+		}
+	}
+	if len(nodes) != len(expected) {
+		t.Errorf("Expected %d nodes but got %d", len(expected), len(nodes))
+	}
+}
+
+func Test_StreamLocalFileStructures_should_merge_directories_of_roots(t *testing.T) {
+	firstRoot := createTestTree(t, "2019/first.jpg")
+	defer os.RemoveAll(firstRoot)
+	secondRoot := createTestTree(t, "2019/second.jpg")
+	defer os.RemoveAll(secondRoot)
+
+	roots := []ScanRoot{{Path: firstRoot}, {Path: secondRoot}}
+	nodes, err := scanLocalFileStructures(roots, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(nodes) != 3 { // 1x folder, 2x image
+		t.Errorf("Expected the directories to be merged but got %d nodes", len(nodes))
+	}
+}
+
+func Test_StreamLocalFileStructures_should_report_colliding_files(t *testing.T) {
+	firstRoot := createTestTree(t, "2019/img.jpg")
+	defer os.RemoveAll(firstRoot)
+	secondRoot := createTestTree(t, "2019/img.jpg")
+	defer os.RemoveAll(secondRoot)
+
+	roots := []ScanRoot{{Path: firstRoot}, {Path: secondRoot}}
+	_, err := scanLocalFileStructures(roots, 0)
+	if err == nil {
+		t.Error("Expected an error for files with the same key in different roots")
+	}
+}
+
+func createTestTree(t *testing.T, files ...string) string {
+	rootPath, err := ioutil.TempDir("", "piwigouploader")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range files {
+		path := filepath.Join(rootPath, filepath.FromSlash(file))
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(path, []byte("test"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rootPath
+}
+
+func Test_StreamLocalFileStructures_should_honour_piwigoignore_files(t *testing.T) {
+	rootPath := createTestTree(t, "2019/img.jpg", "2019/raw/img.jpg", "2019/keep.jpg", "2019/Event/skip.jpg", "2019/Event/img.jpg", "Private/img.jpg")
+	defer os.RemoveAll(rootPath)
+	writeDirectoryFile(t, rootPath, ignoreFileName, "", "# comment", "/Private/", "raw/")
+	writeDirectoryFile(t, rootPath, ignoreFileName, "2019", "*.jpg", "!keep.jpg", "!Event/*.jpg")
+	writeDirectoryFile(t, rootPath, ignoreFileName, "2019/Event", "skip.jpg")
+
+	nodes, err := scanLocalFileStructure(rootPath, []string{"jpg"}, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := make(map[string]bool)
+	for _, node := range nodes {
+		if !node.IsDir {
+			files[node.Key] = true
+		}
+	}
+
+	expected := []string{"2019/keep.jpg", "2019/Event/img.jpg"}
+	for _, key := range expected {
+		if !files[key] {
+			t.Errorf("Did not find the expected file %s", key)
+		}
+	}
+	if len(files) != len(expected) {
+		t.Errorf("Expected %d files but got %v", len(expected), files)
+	}
+}
+
+func Test_StreamLocalFileStructures_should_inherit_directory_settings(t *testing.T) {
+	rootPath := createTestTree(t, "2019/cover.jpg", "2019/Event/img.jpg")
+	defer os.RemoveAll(rootPath)
+	writeDirectoryFile(t, rootPath, settingsFileName, "2019", "description = Holidays", "status = private", "users = 1, 2", "tags = summer,beach", "cover = cover.jpg", "rank = 3")
+	writeDirectoryFile(t, rootPath, settingsFileName, "2019/Event", "# override", "status = public", "upload = false")
+
+	nodes, err := scanLocalFileStructure(rootPath, []string{"jpg"}, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parent := nodes[filepath.Join(rootPath, "2019")].Settings
+	if parent.Cover != "cover.jpg" || parent.Rank != 3 || parent.Status != StatusPrivate || len(parent.Users) != 2 {
+		t.Errorf("Got unexpected settings %+v for the parent directory", parent)
+	}
+
+	child := nodes[filepath.Join(rootPath, "2019", "Event")].Settings
+	if child.Description != "Holidays" || child.Status != StatusPublic || !child.SkipUpload || len(child.Tags) != 2 {
+		t.Errorf("Got unexpected inherited settings %+v for the sub directory", child)
+	}
+	if child.Cover != "" || child.Rank != 0 {
+		t.Errorf("The cover and the rank must not be inherited but got %+v", child)
+	}
+
+	file := nodes[filepath.Join(rootPath, "2019", "Event", "img.jpg")].Settings
+	if !file.SkipUpload || file.Status != StatusPublic {
+		t.Errorf("Expected the file to use the settings of its directory but got %+v", file)
+	}
+}
+
+func Test_StreamLocalFileStructures_should_fail_on_invalid_settings(t *testing.T) {
+	rootPath := createTestTree(t, "2019/img.jpg")
+	defer os.RemoveAll(rootPath)
+	writeDirectoryFile(t, rootPath, settingsFileName, "2019", "status = hidden")
+
+	_, err := scanLocalFileStructure(rootPath, []string{"jpg"}, nil, 0)
+	if err == nil {
+		t.Error("Expected an error for an invalid status")
+	}
+}
+
+func writeDirectoryFile(t *testing.T, rootPath string, fileName string, directory string, lines ...string) {
+	content := strings.Join(lines, "\n")
+	path := filepath.Join(rootPath, filepath.FromSlash(directory), fileName)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_StreamLocalFileStructures_should_send_directories_before_their_files(t *testing.T) {
+	rootPath := createTestTree(t, "2019/Event/img.jpg", "2019/img.jpg", "2020/img.jpg")
+	defer os.RemoveAll(rootPath)
+
+	nodes := make(chan *FilesystemNode)
+	scanResult := make(chan error, 1)
+	go func() {
+		scanResult <- StreamLocalFileStructures([]ScanRoot{{Path: rootPath, AlbumPrefix: "Archive/NAS"}}, 0, nodes, nil)
+	}()
+
+	sent := make(map[string]bool)
+	for node := range nodes {
+		if parent := categoryKey.Parent(node.Key); parent != "" && !sent[parent] {
+			t.Errorf("%s was sent before its album %s", node.Key, parent)
+		}
+		sent[node.Key] = true
+	}
+
+	if err := <-scanResult; err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 8 {
+		t.Errorf("Expected 8 nodes but got %v", sent)
+	}
+}
+
+func Test_StreamLocalFileStructures_should_stop_if_cancelled(t *testing.T) {
+	rootPath := createTestTree(t, "2019/a.jpg", "2019/b.jpg", "2019/c.jpg")
+	defer os.RemoveAll(rootPath)
+
+	nodes := make(chan *FilesystemNode)
+	done := make(chan struct{})
+	scanResult := make(chan error, 1)
+	go func() {
+		scanResult <- StreamLocalFileStructures([]ScanRoot{{Path: rootPath}}, 0, nodes, done)
+	}()
+
+	<-nodes
+	close(done)
+
+	if err := <-scanResult; err != ErrorScanCancelled {
+		t.Errorf("Expected the scan to be cancelled but got %v", err)
+	}
+	if _, open := <-nodes; open {
+		t.Error("Expected the nodes channel to be closed")
+	}
+}
+
+func Test_nodeSender_should_only_hold_back_files_with_the_same_key(t *testing.T) {
+	nodes := make(chan *FilesystemNode, 3)
+	sender := &nodeSender{nodes: nodes, albums: make(map[string]struct{}), files: make(map[string]struct{})}
+
+	for _, node := range []*FilesystemNode{
+		{Key: "2019/img.jpg", Path: "/a/2019/img.jpg"},
+		{Key: "2019/img2.jpg", Path: "/a/2019/img2.jpg"},
+		{Key: "2019/img.jpg", Path: "/b/2019/img.jpg"},
+	} {
+		if err := sender.send(node); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(nodes) != 2 {
+		t.Errorf("Expected the files with distinct keys to be sent but got %d", len(nodes))
+	}
+	if len(sender.collisions) != 1 || sender.collisions[0] != "2019/img.jpg (/b/2019/img.jpg)" {
+		t.Errorf("Expected the second file with the same key to be reported but got %v", sender.collisions)
+	}
+}
+
+func scanLocalFileStructure(path string, extensions []string, ignoreDirs []string, dirSuffixToSkip int) (map[string]*FilesystemNode, error) {
+	root := ScanRoot{Path: path, Extensions: extensions, IgnoreDirs: ignoreDirs}
+	return scanLocalFileStructures([]ScanRoot{root}, dirSuffixToSkip)
+}
+
+// Collects the streamed nodes by their path. The result is only returned if the scan succeeded.
+func scanLocalFileStructures(roots []ScanRoot, dirSuffixToSkip int) (map[string]*FilesystemNode, error) {
+	fileMap := make(map[string]*FilesystemNode)
+	nodes := make(chan *FilesystemNode, 128)
+	done := make(chan struct{})
+	defer close(done)
+
+	scanResult := make(chan error, 1)
+	go func() {
+		scanResult <- StreamLocalFileStructures(roots, dirSuffixToSkip, nodes, done)
+	}()
+
+	for node := range nodes {
+		fileMap[node.Path] = node
+	}
+
+	err := <-scanResult
+	if err != nil {
+		return nil, err
+	}
+	return fileMap, nil
+}
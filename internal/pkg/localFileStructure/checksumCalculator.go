@@ -7,29 +7,97 @@ package localFileStructure
 
 import (
 	"crypto/md5"
+	"crypto/sha256"
 	"fmt"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/blake2b"
+	"hash"
 	"io"
 	"os"
 )
 
-func CalculateFileCheckSums(filePath string) (string, error) {
+const (
+	StrongHashNone    = ""
+	StrongHashSha256  = "sha256"
+	StrongHashBlake2b = "blake2b"
+)
+
+// FileChecksums are the checksums of a file calculated within one pass over its content.
+type FileChecksums struct {
+	// Md5 is the checksum piwigo uses to identify the files.
+	Md5 string
+	// Strong is the collision resistant checksum prefixed by its algorithm, e.g. sha256:<hex>. The prefix keeps
+	// checksums of different algorithms from matching. It is empty if no strong hash is configured.
+	Strong string
+}
+
+// CalculateFileCheckSums calculates the md5 sum of the file.
+func CalculateFileCheckSums(filePath string) (FileChecksums, error) {
+	return calculateFileChecksums(filePath, StrongHashNone, nil, nil)
+}
+
+// NewChecksumCalculator returns a checksum calculator that calculates the strong hash together with the md5 sum.
+// The algorithm is sha256, blake2b or empty to only calculate the md5 sum. The files are read using the throttle
+// unless it is nil.
+func NewChecksumCalculator(strongHash string, throttle *ReadThrottle) (func(filePath string) (FileChecksums, error), error) {
+	var newHash func() hash.Hash
+	switch strongHash {
+	case StrongHashNone:
+		newHash = func() hash.Hash {
+			return nil
+		}
+	case StrongHashSha256:
+		newHash = sha256.New
+	case StrongHashBlake2b:
+		newHash = func() hash.Hash {
+			// the error is only returned for invalid keys
+			strong, _ := blake2b.New256(nil)
+			return strong
+		}
+	default:
+		return nil, fmt.Errorf("unknown strong hash algorithm: %s", strongHash)
+	}
+
+	return func(filePath string) (FileChecksums, error) {
+		return calculateFileChecksums(filePath, strongHash, newHash(), throttle)
+	}, nil
+}
+
+func calculateFileChecksums(filePath string, strongHash string, strong hash.Hash, throttle *ReadThrottle) (FileChecksums, error) {
+	if throttle != nil {
+		throttle.acquire()
+		defer throttle.release()
+	}
+
 	file, err := os.Open(filePath)
 	if err != nil {
 		logrus.Errorf("Could not open file %s", filePath)
-		return "", err
+		return FileChecksums{}, err
 	}
 	defer file.Close()
 
-	hash := md5.New()
-	if _, err = io.Copy(hash, file); err != nil {
+	md5Hash := md5.New()
+	var writer io.Writer = md5Hash
+	if strong != nil {
+		writer = io.MultiWriter(md5Hash, strong)
+	}
+
+	var reader io.Reader = file
+	if throttle != nil {
+		reader = throttle.reader(file)
+	}
+
+	if _, err = io.Copy(writer, reader); err != nil {
 		logrus.Errorf("Could calculate md5 sum of file %s", filePath)
-		return "", err
+		return FileChecksums{}, err
 	}
 
-	md5sum := fmt.Sprintf("%x", hash.Sum(nil))
+	checksums := FileChecksums{Md5: fmt.Sprintf("%x", md5Hash.Sum(nil))}
+	if strong != nil {
+		checksums.Strong = fmt.Sprintf("%s:%x", strongHash, strong.Sum(nil))
+	}
 
-	logrus.Tracef("Calculated md5 sum of %s - %s", filePath, md5sum)
+	logrus.Tracef("Calculated checksums of %s - %s %s", filePath, checksums.Md5, checksums.Strong)
 
-	return md5sum, nil
+	return checksums, nil
 }
@@ -19,8 +19,8 @@ func TestCalculateFileCheckSumsWithValidFile(t *testing.T) {
 		t.Error(err)
 	}
 
-	if sum != expectedSum {
-		t.Errorf("wrong md5 sum provided: expected %s - got %s", expectedSum, sum)
+	if sum.Md5 != expectedSum {
+		t.Errorf("wrong md5 sum provided: expected %s - got %s", expectedSum, sum.Md5)
 	}
 }
 
@@ -33,7 +33,7 @@ func TestCalculateFileCheckSumsWithWrongPath(t *testing.T) {
 		t.Error("there was no error using an invalid and unknown path.")
 	}
 
-	if sum != "" {
+	if sum != (FileChecksums{}) {
 		t.Error("found a checksum of an invalid file. This should not happen!")
 	}
 
@@ -41,3 +41,50 @@ func TestCalculateFileCheckSumsWithWrongPath(t *testing.T) {
 		t.Errorf("the error was not logged")
 	}
 }
+
+func TestNewChecksumCalculatorWithStrongHashes(t *testing.T) {
+	tests := map[string]string{
+		StrongHashSha256:  "sha256:a498c82ac2db8ee8f80991d63c262ce1063fa3e7a60dfbdb3cdd00291cd36e80",
+		StrongHashBlake2b: "blake2b:c5941774d86336f9550116ee4f1384a483a8d8fd02b0d84a7786b2dfb2b76137",
+	}
+
+	for algorithm, expectedSum := range tests {
+		calculator, err := NewChecksumCalculator(algorithm, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sum, err := calculator("../../../test/md5testfile.txt")
+		if err != nil {
+			t.Error(err)
+		}
+		if sum.Md5 != "2e7c66bd6657b1a8659ba05af26a0f7e" {
+			t.Errorf("wrong md5 sum provided using %s: got %s", algorithm, sum.Md5)
+		}
+		if sum.Strong != expectedSum {
+			t.Errorf("wrong strong sum provided: expected %s - got %s", expectedSum, sum.Strong)
+		}
+	}
+}
+
+func TestNewChecksumCalculatorWithThrottle(t *testing.T) {
+	calculator, err := NewChecksumCalculator(StrongHashNone, NewReadThrottle(1, true, 1024*1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum, err := calculator("../../../test/md5testfile.txt")
+	if err != nil {
+		t.Error(err)
+	}
+	if sum.Md5 != "2e7c66bd6657b1a8659ba05af26a0f7e" || sum.Strong != "" {
+		t.Errorf("wrong checksums provided using the throttle: got %s %s", sum.Md5, sum.Strong)
+	}
+}
+
+func TestNewChecksumCalculatorWithUnknownHash(t *testing.T) {
+	_, err := NewChecksumCalculator("md4", nil)
+	if err == nil {
+		t.Error("there was no error using an unknown hash algorithm.")
+	}
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package localFileStructure
+
+import (
+	"fmt"
+	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/categoryKey"
+	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/metadata"
+	"github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var datePlaceholders = map[string]string{
+	"{year}":  "2006",
+	"{month}": "01",
+	"{day}":   "02",
+}
+
+var datePlaceholderPattern = regexp.MustCompile(`{[^}]*}`)
+
+// DateLayout builds the albums from the capture date of the images instead of their directories. The template
+// uses slashes to separate the albums and the placeholders {year}, {month} and {day}, e.g. "{year}/{year}-{month}".
+type DateLayout struct {
+	Template string
+	albums   []string
+}
+
+// ParseDateLayout checks the placeholders of the template and splits it into its albums.
+func ParseDateLayout(template string) (*DateLayout, error) {
+	layout := &DateLayout{Template: template}
+	for _, album := range strings.Split(strings.Trim(template, "/"), "/") {
+		album = strings.TrimSpace(album)
+		if album == "" {
+			return nil, fmt.Errorf("invalid date layout '%s'. Albums must not be empty", template)
+		}
+
+		for _, placeholder := range datePlaceholderPattern.FindAllString(album, -1) {
+			if _, ok := datePlaceholders[placeholder]; !ok {
+				return nil, fmt.Errorf("invalid date layout '%s'. Unknown placeholder %s", template, placeholder)
+			}
+		}
+		layout.albums = append(layout.albums, album)
+	}
+	return layout, nil
+}
+
+// Returns the names of the albums for the given date.
+func (layout *DateLayout) format(date time.Time) []string {
+	names := make([]string, 0, len(layout.albums))
+	for _, album := range layout.albums {
+		name := datePlaceholderPattern.ReplaceAllStringFunc(album, func(placeholder string) string {
+			return date.Format(datePlaceholders[placeholder])
+		})
+		names = append(names, name)
+	}
+	return names
+}
+
+// Puts every file into the album of its capture date. Images without a capture date use the modification time of
+// the file. The directories do not get albums, so the same day of different directories ends up in one album.
+type dateKeyBuilder struct {
+	albumPrefix string
+	layout      *DateLayout
+	readDate    func(filePath string) (time.Time, error)
+	albums      map[string]struct{}
+	pending     []string
+}
+
+func newDateKeyBuilder(albumPrefix string, layout *DateLayout) *dateKeyBuilder {
+	return &dateKeyBuilder{
+		albumPrefix: albumPrefix,
+		layout:      layout,
+		readDate:    metadata.ReadCaptureDate,
+		albums:      make(map[string]struct{}),
+	}
+}
+
+func (b *dateKeyBuilder) buildKey(path string, info os.FileInfo) (string, error) {
+	if info.IsDir() {
+		return "", nil
+	}
+
+	date, err := b.readDate(path)
+	if err != nil {
+		logrus.Debugf("Using the modification time of %s as album date: %s", path, err)
+		date = info.ModTime()
+	}
+
+	album := categoryKey.Join(b.layout.format(date)...)
+	if b.albumPrefix != "" {
+		album = b.albumPrefix + categoryKey.Separator + album
+	}
+	b.addAlbum(album)
+
+	return categoryKey.Append(album, filepath.Base(path)), nil
+}
+
+// Remembers the album and its parents below the album prefix that were not used before, parents first.
+func (b *dateKeyBuilder) addAlbum(album string) {
+	var added []string
+	for key := album; key != "" && key != b.albumPrefix; key = categoryKey.Parent(key) {
+		if _, exists := b.albums[key]; exists {
+			break
+		}
+		b.albums[key] = struct{}{}
+		added = append([]string{key}, added...)
+	}
+	b.pending = append(b.pending, added...)
+}
+
+func (b *dateKeyBuilder) newAlbums() []string {
+	albums := b.pending
+	b.pending = nil
+	return albums
+}
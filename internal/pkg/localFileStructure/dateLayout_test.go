@@ -0,0 +1,116 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package localFileStructure
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_ParseDateLayout_should_fail_on_invalid_templates(t *testing.T) {
+	for _, template := range []string{"", "{year}//{month}", "{year}/{week}"} {
+		if _, err := ParseDateLayout(template); err == nil {
+			t.Errorf("Expected an error for template %s", template)
+		}
+	}
+}
+
+func Test_dateKeyBuilder_should_use_capture_date_and_prefix(t *testing.T) {
+	layout, err := ParseDateLayout("{year}/{year}-{month}-{day}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	builder := newDateKeyBuilder("Phone", layout)
+	builder.readDate = func(filePath string) (time.Time, error) {
+		return time.Date(2019, 6, 1, 12, 0, 0, 0, time.Local), nil
+	}
+
+	info := createTestFileInfo(t)
+	key, err := builder.buildKey(filepath.Join("dump", "IMG_0001.jpg"), info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "Phone/2019/2019-06-01/IMG_0001.jpg" {
+		t.Errorf("Got unexpected key %s", key)
+	}
+
+	albums := builder.newAlbums()
+	if len(albums) != 2 || albums[0] != "Phone/2019" || albums[1] != "Phone/2019/2019-06-01" {
+		t.Errorf("Got unexpected albums %v", albums)
+	}
+
+	_, err = builder.buildKey(filepath.Join("dump", "IMG_0002.jpg"), info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if albums = builder.newAlbums(); len(albums) != 0 {
+		t.Errorf("Expected no new albums but got %v", albums)
+	}
+}
+
+func Test_StreamLocalFileStructures_should_build_albums_from_modification_time(t *testing.T) {
+	rootPath := createTestTree(t, "dump/a.jpg", "dump/b.jpg", "other/c.jpg")
+	defer os.RemoveAll(rootPath)
+	setTestModTime(t, filepath.Join(rootPath, "dump", "a.jpg"), time.Date(2019, 6, 1, 12, 0, 0, 0, time.Local))
+	setTestModTime(t, filepath.Join(rootPath, "dump", "b.jpg"), time.Date(2020, 1, 2, 12, 0, 0, 0, time.Local))
+	setTestModTime(t, filepath.Join(rootPath, "other", "c.jpg"), time.Date(2019, 6, 3, 12, 0, 0, 0, time.Local))
+
+	layout, err := ParseDateLayout("{year}/{year}-{month}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodes, err := scanLocalFileStructures([]ScanRoot{{Path: rootPath, DateLayout: layout}}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := make(map[string]bool)
+	for _, node := range nodes {
+		keys[node.Key] = node.IsDir
+	}
+
+	expected := map[string]bool{
+		"2019":               true,
+		"2019/2019-06":       true,
+		"2020":               true,
+		"2020/2020-01":       true,
+		"2019/2019-06/a.jpg": false,
+		"2019/2019-06/c.jpg": false,
+		"2020/2020-01/b.jpg": false,
+	}
+	for key, isDir := range expected {
+		if found, ok := keys[key]; !ok || found != isDir {
+			t.Errorf("Did not find the expected node %s", key)
+		}
+	}
+	if len(keys) != len(expected) {
+		t.Errorf("Expected %d nodes but got %v", len(expected), keys)
+	}
+}
+
+func createTestFileInfo(t *testing.T) os.FileInfo {
+	file, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+func setTestModTime(t *testing.T, path string, modTime time.Time) {
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,199 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package localFileStructure
+
+import (
+	"github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Directories modified within this interval before they got listed are not cached, as further changes within the
+// same timestamp resolution of the filesystem would not change their modification time.
+const racyModificationInterval = 2 * time.Second
+
+// DirectoryEntry is a file or directory as it was found while listing its parent directory.
+type DirectoryEntry struct {
+	Name    string
+	IsDir   bool
+	Size    int64
+	ModTime time.Time
+	// FileId and ChangeTime are the rest of the fingerprint of a file, empty for directories.
+	FileId     string
+	ChangeTime time.Time
+}
+
+// DirectoryCache remembers the entries of the directories by their modification time. Adding, removing or renaming
+// an entry changes the modification time of the directory, so an unchanged directory still contains the same entries.
+// Files modified in place do not change the directory, so their modification time is only updated on a full scan.
+type DirectoryCache interface {
+	// Entries returns the entries of the directory if it got listed with the same modification time before.
+	Entries(path string, modTime time.Time) ([]DirectoryEntry, bool)
+	SaveEntries(path string, modTime time.Time, entries []DirectoryEntry) error
+}
+
+// The cachedWalker walks the tree like filepath.Walk, but lists the directories using the cache. Subdirectories are
+// always checked for changes, files of unchanged directories are not touched at all. Without a cache, every
+// directory is listed.
+type cachedWalker struct {
+	cache    DirectoryCache
+	fullScan bool
+	fn       walkFunc
+	listings *directoryListings
+}
+
+func (w *cachedWalker) walk(path string, info os.FileInfo) error {
+	err := w.fn(path, path, info)
+	if err == filepath.SkipDir && info.IsDir() {
+		return nil
+	}
+	if err != nil || !info.IsDir() {
+		return err
+	}
+
+	entries, err := w.entries(path, info)
+	if err != nil {
+		// same as filepath.Walk, a directory that could not be read is skipped
+		logrus.Warnf("Skipping directory %s as it could not be read - %s", path, err)
+		return nil
+	}
+	w.listings.add(path, entries)
+	defer w.listings.remove(path)
+
+	for _, entry := range entries {
+		childPath := filepath.Join(path, entry.Name)
+
+		var childInfo os.FileInfo = cachedFileInfo{entry: entry}
+		if entry.IsDir {
+			childInfo, err = os.Lstat(childPath)
+			if os.IsNotExist(err) {
+				logrus.Debugf("Skipping %s as it got removed since it was listed", childPath)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		err = w.walk(childPath, childInfo)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *cachedWalker) entries(path string, info os.FileInfo) ([]DirectoryEntry, error) {
+	if w.cache != nil && !w.fullScan {
+		if entries, ok := w.cache.Entries(path, info.ModTime()); ok {
+			logrus.Tracef("Using the cached entries of the unchanged directory %s", path)
+			return entries, nil
+		}
+	}
+
+	entries, err := listDirectory(path)
+	if err != nil || w.cache == nil {
+		return entries, err
+	}
+
+	if time.Since(info.ModTime()) < racyModificationInterval {
+		logrus.Debugf("Not caching the entries of %s as it just got modified", path)
+		return entries, nil
+	}
+
+	err = w.cache.SaveEntries(path, info.ModTime(), entries)
+	if err != nil {
+		logrus.Warnf("Could not cache the entries of %s - %s", path, err)
+	}
+	return entries, nil
+}
+
+// Lists the entries of the directory ordered by name. Entries removed while listing are skipped.
+func listDirectory(path string) ([]DirectoryEntry, error) {
+	names, err := readDirNames(path)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]DirectoryEntry, 0, len(names))
+	for _, name := range names {
+		entryInfo, err := os.Lstat(filepath.Join(path, name))
+		if err != nil {
+			logrus.Warnf("Skipping %s - %s", filepath.Join(path, name), err)
+			continue
+		}
+		entries = append(entries, newDirectoryEntry(filepath.Join(path, name), entryInfo))
+	}
+	return entries, nil
+}
+
+func newDirectoryEntry(path string, info os.FileInfo) DirectoryEntry {
+	entry := DirectoryEntry{Name: info.Name(), IsDir: info.IsDir(), Size: info.Size(), ModTime: info.ModTime()}
+	if !entry.IsDir {
+		entry.FileId, entry.ChangeTime = fileFingerprint(path, info)
+	}
+	return entry
+}
+
+// directoryListings keeps the entries of the directories the walk is in. The walkers add the entries of a directory
+// before its children are visited, so the variants and the sidecar of a file are found without listing or
+// accessing its siblings again. The entries of unchanged directories come from the cache.
+type directoryListings struct {
+	entries map[string][]DirectoryEntry
+}
+
+func newDirectoryListings() *directoryListings {
+	return &directoryListings{entries: make(map[string][]DirectoryEntry)}
+}
+
+func (l *directoryListings) add(dir string, entries []DirectoryEntry) {
+	l.entries[dir] = entries
+}
+
+func (l *directoryListings) remove(dir string) {
+	delete(l.entries, dir)
+}
+
+// Returns the entries of the directory. Directories the walk is not in are listed.
+func (l *directoryListings) list(dir string) ([]DirectoryEntry, error) {
+	if entries, ok := l.entries[dir]; ok {
+		return entries, nil
+	}
+	return listDirectory(dir)
+}
+
+// Provides the stored information of a file without accessing the filesystem.
+type cachedFileInfo struct {
+	entry DirectoryEntry
+}
+
+func (info cachedFileInfo) Name() string {
+	return info.entry.Name
+}
+
+func (info cachedFileInfo) Size() int64 {
+	return info.entry.Size
+}
+
+func (info cachedFileInfo) Mode() os.FileMode {
+	if info.entry.IsDir {
+		return os.ModeDir
+	}
+	return 0
+}
+
+func (info cachedFileInfo) ModTime() time.Time {
+	return info.entry.ModTime
+}
+
+func (info cachedFileInfo) IsDir() bool {
+	return info.entry.IsDir
+}
+
+func (info cachedFileInfo) Sys() interface{} {
+	return nil
+}
@@ -0,0 +1,154 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package localFileStructure
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testDirectoryCache struct {
+	modTimes map[string]time.Time
+	entries  map[string][]DirectoryEntry
+}
+
+func newTestDirectoryCache() *testDirectoryCache {
+	return &testDirectoryCache{modTimes: make(map[string]time.Time), entries: make(map[string][]DirectoryEntry)}
+}
+
+func (c *testDirectoryCache) Entries(path string, modTime time.Time) ([]DirectoryEntry, bool) {
+	if stored, ok := c.modTimes[path]; !ok || !stored.Equal(modTime) {
+		return nil, false
+	}
+	return c.entries[path], true
+}
+
+func (c *testDirectoryCache) SaveEntries(path string, modTime time.Time, entries []DirectoryEntry) error {
+	c.modTimes[path] = modTime
+	c.entries[path] = entries
+	return nil
+}
+
+func Test_StreamLocalFileStructures_should_reuse_the_entries_of_unchanged_directories(t *testing.T) {
+	rootPath := createTestTree(t, "2019/img.jpg", "2020/img.jpg")
+	defer os.RemoveAll(rootPath)
+	cache := newTestDirectoryCache()
+	dirTime := setDirectoryTimes(t, rootPath, "", "2019", "2020")
+
+	scanCachedTestTree(t, ScanRoot{Path: rootPath, Cache: cache})
+	addFileKeepingDirectoryTime(t, rootPath, "2019/new.jpg", dirTime)
+
+	nodes := scanCachedTestTree(t, ScanRoot{Path: rootPath, Cache: cache})
+
+	if _, exists := nodes["2019/new.jpg"]; exists {
+		t.Error("Expected the cached entries of the unchanged directory to be used")
+	}
+	if _, exists := nodes["2020/img.jpg"]; !exists {
+		t.Error("Did not find the file of the cached directory")
+	}
+}
+
+func Test_StreamLocalFileStructures_should_list_changed_directories_again(t *testing.T) {
+	rootPath := createTestTree(t, "2019/img.jpg")
+	defer os.RemoveAll(rootPath)
+	cache := newTestDirectoryCache()
+	setDirectoryTimes(t, rootPath, "", "2019")
+
+	scanCachedTestTree(t, ScanRoot{Path: rootPath, Cache: cache})
+	addFileKeepingDirectoryTime(t, rootPath, "2019/new.jpg", time.Now().Add(-time.Hour))
+
+	nodes := scanCachedTestTree(t, ScanRoot{Path: rootPath, Cache: cache})
+
+	if _, exists := nodes["2019/new.jpg"]; !exists {
+		t.Error("Expected the changed directory to be listed again")
+	}
+}
+
+func Test_StreamLocalFileStructures_should_list_all_directories_on_a_full_scan(t *testing.T) {
+	rootPath := createTestTree(t, "2019/img.jpg")
+	defer os.RemoveAll(rootPath)
+	cache := newTestDirectoryCache()
+	dirTime := setDirectoryTimes(t, rootPath, "", "2019")
+
+	scanCachedTestTree(t, ScanRoot{Path: rootPath, Cache: cache})
+	addFileKeepingDirectoryTime(t, rootPath, "2019/new.jpg", dirTime)
+
+	nodes := scanCachedTestTree(t, ScanRoot{Path: rootPath, Cache: cache, FullScan: true})
+
+	if _, exists := nodes["2019/new.jpg"]; !exists {
+		t.Error("Expected the full scan to ignore the cached entries")
+	}
+	if len(cache.entries[filepath.Join(rootPath, "2019")]) != 2 {
+		t.Errorf("Expected the full scan to update the cache but got %v", cache.entries)
+	}
+}
+
+func Test_StreamLocalFileStructures_should_not_cache_directories_modified_just_now(t *testing.T) {
+	rootPath := createTestTree(t, "2019/img.jpg")
+	defer os.RemoveAll(rootPath)
+	cache := newTestDirectoryCache()
+
+	scanCachedTestTree(t, ScanRoot{Path: rootPath, Cache: cache})
+
+	if _, exists := cache.entries[filepath.Join(rootPath, "2019")]; exists {
+		t.Error("Expected the just modified directory not to be cached")
+	}
+}
+
+func Test_StreamLocalFileStructures_should_keep_the_fingerprint_of_cached_files(t *testing.T) {
+	rootPath := createTestTree(t, "2019/img.jpg")
+	defer os.RemoveAll(rootPath)
+	cache := newTestDirectoryCache()
+	setDirectoryTimes(t, rootPath, "", "2019")
+
+	listed := scanCachedTestTree(t, ScanRoot{Path: rootPath, Cache: cache})["2019/img.jpg"]
+	cached := scanCachedTestTree(t, ScanRoot{Path: rootPath, Cache: cache})["2019/img.jpg"]
+
+	if listed.FileId == "" || listed.Size == 0 {
+		t.Errorf("Expected the fingerprint of the listed file but got %d %s", listed.Size, listed.FileId)
+	}
+	if cached.FileId != listed.FileId || !cached.ChangeTime.Equal(listed.ChangeTime) || cached.Size != listed.Size {
+		t.Errorf("Expected the fingerprint %d %s %s of the cached file but got %d %s %s", listed.Size, listed.FileId, listed.ChangeTime, cached.Size, cached.FileId, cached.ChangeTime)
+	}
+}
+
+// Moves the modification time of the directories out of the racy interval so they get cached.
+func setDirectoryTimes(t *testing.T, rootPath string, dirs ...string) time.Time {
+	dirTime := time.Now().Add(-2 * time.Hour).Truncate(time.Second)
+	for _, dir := range dirs {
+		if err := os.Chtimes(filepath.Join(rootPath, filepath.FromSlash(dir)), dirTime, dirTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dirTime
+}
+
+func addFileKeepingDirectoryTime(t *testing.T, rootPath string, file string, dirTime time.Time) {
+	path := filepath.Join(rootPath, filepath.FromSlash(file))
+	if err := ioutil.WriteFile(path, []byte("test"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Dir(path), dirTime, dirTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func scanCachedTestTree(t *testing.T, root ScanRoot) map[string]*FilesystemNode {
+	root.Extensions = []string{"jpg"}
+	nodes, err := scanLocalFileStructures([]ScanRoot{root}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodesByKey := make(map[string]*FilesystemNode, len(nodes))
+	for _, node := range nodes {
+		nodesByKey[node.Key] = node
+	}
+	return nodesByKey
+}
@@ -0,0 +1,25 @@
+//go:build linux || openbsd || dragonfly || solaris
+// +build linux openbsd dragonfly solaris
+
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package localFileStructure
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// The change time is updated by the filesystem on every change of the content or the inode and can not be set by
+// the tools copying or restoring the files.
+func fileChangeTime(info os.FileInfo) time.Time {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}
+	}
+	return time.Unix(stat.Ctim.Unix())
+}
@@ -0,0 +1,19 @@
+//go:build !linux && !openbsd && !dragonfly && !solaris && !darwin && !freebsd && !netbsd
+// +build !linux,!openbsd,!dragonfly,!solaris,!darwin,!freebsd,!netbsd
+
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package localFileStructure
+
+import (
+	"os"
+	"time"
+)
+
+// The change time is not available using os.FileInfo, so the fingerprint only contains the size and the identity.
+func fileChangeTime(info os.FileInfo) time.Time {
+	return time.Time{}
+}
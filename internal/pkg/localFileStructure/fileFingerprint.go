@@ -0,0 +1,30 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package localFileStructure
+
+import (
+	"github.com/sirupsen/logrus"
+	"os"
+	"time"
+)
+
+// Returns the identity and the change time of the file as part of its fingerprint. Files listed from the directory
+// cache have the fingerprint they had at the time their directory got listed.
+func fileFingerprint(path string, info os.FileInfo) (string, time.Time) {
+	if cached, ok := info.(cachedFileInfo); ok {
+		return cached.entry.FileId, cached.entry.ChangeTime
+	}
+	if info.Sys() == nil {
+		return "", time.Time{}
+	}
+
+	id, err := fileIdentity(path, info)
+	if err != nil {
+		logrus.Debugf("Fingerprint of %s without identity - %s", path, err)
+		id = ""
+	}
+	return id, fileChangeTime(info)
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+// +build !windows
+
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package localFileStructure
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// Returns the device and inode of the file, so the same file is recognized even if it is reachable by more than one path.
+func fileIdentity(path string, info os.FileInfo) (string, error) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", fmt.Errorf("could not read the inode of %s", path)
+	}
+	return fmt.Sprintf("%d:%d", uint64(stat.Dev), uint64(stat.Ino)), nil
+}
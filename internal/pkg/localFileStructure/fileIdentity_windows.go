@@ -0,0 +1,21 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package localFileStructure
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Windows does not provide inodes using os.FileInfo, so the path without any links is used to recognize the same file.
+func fileIdentity(path string, info os.FileInfo) (string, error) {
+	resolvedPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.ToLower(resolvedPath), nil
+}
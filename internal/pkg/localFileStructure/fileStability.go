@@ -0,0 +1,45 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package localFileStructure
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// ErrorFileNotStable is returned for files that are probably still being written, e.g. by an import tool copying
+// them into the tree while the uploader runs.
+var ErrorFileNotStable = errors.New("file is still being written")
+
+// StableFileCheckSums wraps the checksum calculator to only hash files that are not modified within the quiet period
+// and that keep their size and modification time while they are hashed. Otherwise ErrorFileNotStable is returned,
+// as the checksum of a partially written file must not be used.
+func StableFileCheckSums(quietPeriod time.Duration, calculate func(filePath string) (FileChecksums, error)) func(filePath string) (FileChecksums, error) {
+	return func(filePath string) (FileChecksums, error) {
+		before, err := os.Stat(filePath)
+		if err != nil {
+			return FileChecksums{}, err
+		}
+		if time.Since(before.ModTime()) < quietPeriod {
+			return FileChecksums{}, ErrorFileNotStable
+		}
+
+		checksum, err := calculate(filePath)
+		if err != nil {
+			return FileChecksums{}, err
+		}
+
+		after, err := os.Stat(filePath)
+		if err != nil {
+			return FileChecksums{}, err
+		}
+		if after.Size() != before.Size() || !after.ModTime().Equal(before.ModTime()) {
+			return FileChecksums{}, ErrorFileNotStable
+		}
+		return checksum, nil
+	}
+}
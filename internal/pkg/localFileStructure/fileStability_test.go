@@ -0,0 +1,79 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package localFileStructure
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_StableFileCheckSums_should_hash_files_older_than_the_quiet_period(t *testing.T) {
+	rootPath := createTestTree(t, "img.jpg")
+	defer os.RemoveAll(rootPath)
+	path := filepath.Join(rootPath, "img.jpg")
+	setFileTime(t, path, time.Now().Add(-time.Hour))
+
+	sum, err := StableFileCheckSums(time.Minute, CalculateFileCheckSums)(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Md5 == "" {
+		t.Error("Expected a checksum of the stable file")
+	}
+}
+
+func Test_StableFileCheckSums_should_skip_files_modified_within_the_quiet_period(t *testing.T) {
+	rootPath := createTestTree(t, "img.jpg")
+	defer os.RemoveAll(rootPath)
+	path := filepath.Join(rootPath, "img.jpg")
+
+	calculatorCalled := false
+	calculator := func(filePath string) (FileChecksums, error) {
+		calculatorCalled = true
+		return FileChecksums{}, nil
+	}
+
+	_, err := StableFileCheckSums(time.Minute, calculator)(path)
+
+	if err != ErrorFileNotStable {
+		t.Errorf("Expected ErrorFileNotStable but got %v", err)
+	}
+	if calculatorCalled {
+		t.Error("Expected the file not to be hashed")
+	}
+}
+
+func Test_StableFileCheckSums_should_skip_files_growing_while_hashed(t *testing.T) {
+	rootPath := createTestTree(t, "img.jpg")
+	defer os.RemoveAll(rootPath)
+	path := filepath.Join(rootPath, "img.jpg")
+	setFileTime(t, path, time.Now().Add(-time.Hour))
+
+	growingCalculator := func(filePath string) (FileChecksums, error) {
+		file, err := os.OpenFile(filePath, os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return FileChecksums{}, err
+		}
+		defer file.Close()
+		_, err = file.WriteString("more data")
+		return FileChecksums{Md5: "partial"}, err
+	}
+
+	_, err := StableFileCheckSums(0, growingCalculator)(path)
+
+	if err != ErrorFileNotStable {
+		t.Errorf("Expected ErrorFileNotStable but got %v", err)
+	}
+}
+
+func setFileTime(t *testing.T, path string, modTime time.Time) {
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
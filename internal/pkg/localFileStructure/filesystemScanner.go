@@ -6,39 +6,150 @@
 package localFileStructure
 
 import (
+	"errors"
 	"fmt"
+	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/categoryKey"
 	"github.com/sirupsen/logrus"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 )
 
+// ErrorScanCancelled is returned if the scan got cancelled before it was done.
+var ErrorScanCancelled = errors.New("scan cancelled")
+
 type FilesystemNode struct {
-	Key     string
-	Path    string
-	Name    string
-	IsDir   bool
-	ModTime time.Time
+	Key      string
+	Path     string
+	Name     string
+	IsDir    bool
+	ModTime  time.Time
+	Settings DirectorySettings
+	// Variants are the less preferred files of the same group. The file replaces the image uploaded for one of them.
+	Variants []string
+	// SidecarPath is the xmp sidecar of the file if there is one and ScanRoot.Sidecars is set.
+	SidecarPath    string
+	SidecarModTime time.Time
+	// Size, FileId and ChangeTime complete the fingerprint of a file. FileId and ChangeTime are empty if the
+	// platform does not provide them.
+	Size       int64
+	FileId     string
+	ChangeTime time.Time
 }
 
 func (n *FilesystemNode) String() string {
 	return fmt.Sprintf("FilesystemNode: %s", n.Path)
 }
 
-func ScanLocalFileStructure(path string, extensions []string, ignoreDirs []string, dirSuffixToSkip int) (map[string]*FilesystemNode, error) {
-	fullPathRoot, err := filepath.Abs(path)
+// ScanRoot is a local directory that gets mirrored to the album with the given key. An empty album prefix
+// mirrors the directory to the root of the gallery.
+type ScanRoot struct {
+	Path        string
+	AlbumPrefix string
+	Extensions  []string
+	IgnoreDirs  []string
+	Rules       []Rule
+	// FollowSymlinks enables following symlinks to files and directories. SymlinkKeys defines if the path
+	// of the link or of its target is used to build the keys.
+	FollowSymlinks bool
+	SymlinkKeys    string
+	// Rewrites are applied to the relative directory path after the dirSuffixToSkip got removed.
+	Rewrites []RewriteRule
+	// MaxAlbumDepth limits the number of album levels below the album prefix. Deeper directories are merged into
+	// their ancestor at the limit or, with JoinCollapsedAlbums, get an album named by all collapsed directories.
+	MaxAlbumDepth       int
+	JoinCollapsedAlbums bool
+	// Cache skips listing directories that did not change since the last scan unless FullScan is set.
+	Cache    DirectoryCache
+	FullScan bool
+	// DateLayout builds the albums from the capture dates of the images instead of the directories if set.
+	DateLayout *DateLayout
+	// Variants only sends the most preferred file of the files sharing a base name within a directory if set.
+	Variants *VariantPreference
+	// Sidecars looks up the xmp sidecar of every file.
+	Sidecars bool
+	// Paths limits the scan to the given absolute paths of files and directories if set. The directories leading to
+	// them are sent as well, but none of their other files.
+	Paths []string
+}
+
+// StreamLocalFileStructures scans all roots and sends every node as soon as it is found, so the following stages do
+// not have to wait for the whole tree. Every directory is sent before the files and directories within it and the
+// nodes channel is closed when the scan is done. Closing the done channel cancels the scan.
+// Only the keys of the albums and files are kept in memory to merge the albums and to detect collisions. As colliding
+// files are only reported at the end, the error has to be checked before using the result.
+func StreamLocalFileStructures(roots []ScanRoot, dirSuffixToSkip int, nodes chan<- *FilesystemNode, done <-chan struct{}) error {
+	defer close(nodes)
+
+	sender := &nodeSender{
+		nodes:  nodes,
+		done:   done,
+		albums: make(map[string]struct{}),
+		files:  make(map[string]struct{}),
+	}
+
+	for _, root := range roots {
+		err := scanRoot(root, dirSuffixToSkip, sender.send)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(sender.collisions) > 0 {
+		sort.Strings(sender.collisions)
+		return fmt.Errorf("found files with the same key, only one of them could be uploaded: %s", strings.Join(sender.collisions, "; "))
+	}
+	return nil
+}
+
+// nodeSender merges the directories of all roots with the same key and holds back files with a key that was
+// already sent.
+type nodeSender struct {
+	nodes      chan<- *FilesystemNode
+	done       <-chan struct{}
+	albums     map[string]struct{}
+	files      map[string]struct{}
+	collisions []string
+}
+
+func (s *nodeSender) send(node *FilesystemNode) error {
+	if node.IsDir {
+		if _, exists := s.albums[node.Key]; exists {
+			logrus.Debugf("Merging directory %s into the existing album %s", node.Path, node.Key)
+			return nil
+		}
+		s.albums[node.Key] = struct{}{}
+	} else {
+		if _, exists := s.files[node.Key]; exists {
+			s.collisions = append(s.collisions, fmt.Sprintf("%s (%s)", node.Key, node.Path))
+			return nil
+		}
+		s.files[node.Key] = struct{}{}
+	}
+
+	select {
+	case s.nodes <- node:
+		return nil
+	case <-s.done:
+		return ErrorScanCancelled
+	}
+}
+
+func scanRoot(root ScanRoot, dirSuffixToSkip int, send func(node *FilesystemNode) error) error {
+	fullPathRoot, err := filepath.Abs(root.Path)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	ignoreDirsMap := make(map[string]struct{}, len(ignoreDirs))
-	for _, ignoredFolder := range ignoreDirs {
+	ignoreDirsMap := make(map[string]struct{}, len(root.IgnoreDirs))
+	for _, ignoredFolder := range root.IgnoreDirs {
 		ignoreDirsMap[strings.ToLower(ignoredFolder)] = struct{}{}
 	}
 
-	extensionsMap := make(map[string]struct{}, len(extensions))
-	for _, extension := range extensions {
+	extensionsMap := make(map[string]struct{}, len(root.Extensions))
+	for _, extension := range root.Extensions {
 		extensionsMap["."+strings.ToLower(extension)] = struct{}{}
 	}
 
@@ -50,14 +161,56 @@ func ScanLocalFileStructure(path string, extensions []string, ignoreDirs []strin
 
 	logrus.Infof("Scanning %s for images...", fullPathRoot)
 
-	fileMap := make(map[string]*FilesystemNode)
-	fullPathReplace := fmt.Sprintf("%s%c", fullPathRoot, os.PathSeparator)
 	numberOfDirectories := 0
 	numberOfImages := 0
 
-	err = filepath.Walk(fullPathRoot, func(path string, info os.FileInfo, err error) error {
+	ignores := newIgnoreFiles()
+	var keys keyBuilder = &directoryKeyBuilder{
+		fullPathRoot:    fullPathRoot,
+		albumPrefix:     root.AlbumPrefix,
+		dirSuffixToSkip: dirSuffixToSkip,
+		rewrites:        root.Rewrites,
+		maxDepth:        root.MaxAlbumDepth,
+		joinCollapsed:   root.JoinCollapsedAlbums,
+	}
+	if root.DateLayout != nil {
+		keys = newDateKeyBuilder(root.AlbumPrefix, root.DateLayout)
+	}
+	settingsByDir := make(map[string]DirectorySettings)
+
+	scope := newScanScope(root.Paths)
+	listings := newDirectoryListings()
+	var variants *variantSelector
+	if root.Variants != nil {
+		variants = &variantSelector{
+			preference: root.Variants,
+			listings:   listings,
+			isEligible: func(entry DirectoryEntry, relativePath string) bool {
+				_, extensionSupported := extensionsMap[strings.ToLower(filepath.Ext(entry.Name))]
+				return !entry.IsDir && extensionSupported &&
+					!strings.HasPrefix(entry.Name, ".") &&
+					!ignores.isIgnored(relativePath, false) &&
+					!isExcluded(root.Rules, relativePath, false)
+			},
+		}
+	}
+
+	err = walk(fullPathRoot, root.FollowSymlinks, root.SymlinkKeys, root.Cache, root.FullScan, listings, func(path string, keyPath string, info os.FileInfo) error {
 		if fullPathRoot == path {
-			return nil
+			settings, err := loadRootDirectory(path, ignores, settingsByDir)
+			if err != nil {
+				return err
+			}
+			return sendAlbumPrefixNodes(send, fullPathRoot, root.AlbumPrefix, settings)
+		}
+
+		if scope != nil && !scope.contains(path) {
+			if !info.IsDir() {
+				return nil
+			}
+			if !scope.leadsTo(path) {
+				return filepath.SkipDir
+			}
 		}
 
 		if strings.HasPrefix(info.Name(), ".") {
@@ -74,20 +227,80 @@ func ScanLocalFileStructure(path string, extensions []string, ignoreDirs []strin
 			return filepath.SkipDir
 		}
 
+		relativePath := filepath.ToSlash(strings.TrimPrefix(keyPath, fmt.Sprintf("%s%c", fullPathRoot, os.PathSeparator)))
+		if ignores.isIgnored(relativePath, info.IsDir()) {
+			logrus.Tracef("Skipping %s as it is ignored by a %s file", path, ignoreFileName)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
 		extension := strings.ToLower(filepath.Ext(path))
 		_, extensionSupported := extensionsMap[extension]
 		if !extensionSupported && !info.IsDir() {
 			return nil
 		}
 
-		key := buildKey(path, info, fullPathReplace, dirSuffixToSkip)
+		if isExcluded(root.Rules, relativePath, info.IsDir()) {
+			logrus.Tracef("Skipping %s as it is excluded by the rules", path)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		var fileVariants []string
+		if variants != nil && !info.IsDir() {
+			preferred, others, err := variants.selectFile(path, relativePath)
+			if err != nil {
+				return err
+			}
+			if !preferred {
+				logrus.Tracef("Skipping %s as a more preferred variant exists", path)
+				return nil
+			}
+			fileVariants = others
+		}
+
+		var sidecarPath string
+		var sidecarModTime time.Time
+		if root.Sidecars && !info.IsDir() {
+			sidecarPath, sidecarModTime, err = findSidecar(listings, path)
+			if err != nil {
+				return err
+			}
+		}
+
+		settings := settingsByDir[parentDir(relativePath)]
+		if info.IsDir() {
+			err = ignores.loadDirectory(path, relativePath)
+			if err != nil {
+				return err
+			}
+
+			settings, err = readDirectorySettings(path, settings)
+			if err != nil {
+				return err
+			}
+			settingsByDir[relativePath] = settings
+		}
+
+		key, err := keys.buildKey(keyPath, info)
+		if err != nil {
+			return err
+		}
+
+		for _, album := range keys.newAlbums() {
+			numberOfDirectories += 1
+			err = send(&FilesystemNode{Key: album, Path: album, Name: categoryKey.Name(album), IsDir: true})
+			if err != nil {
+				return err
+			}
+		}
 
-		fileMap[path] = &FilesystemNode{
-			Key:     key,
-			Path:    path,
-			Name:    filepath.Base(key),
-			IsDir:   info.IsDir(),
-			ModTime: info.ModTime(),
+		if key == "" {
+			return nil
 		}
 
 		if info.IsDir() {
@@ -96,35 +309,67 @@ func ScanLocalFileStructure(path string, extensions []string, ignoreDirs []strin
 			numberOfImages += 1
 		}
 
-		return nil
+		node := &FilesystemNode{
+			Key:            key,
+			Path:           path,
+			Name:           categoryKey.Name(key),
+			IsDir:          info.IsDir(),
+			ModTime:        info.ModTime(),
+			Settings:       settings,
+			Variants:       fileVariants,
+			SidecarPath:    sidecarPath,
+			SidecarModTime: sidecarModTime,
+		}
+		if !info.IsDir() {
+			node.Size = info.Size()
+			node.FileId, node.ChangeTime = fileFingerprint(path, info)
+		}
+		return send(node)
 	})
 
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	logrus.Infof("Found %d directories and %d images on the local filesystem", numberOfDirectories, numberOfImages)
-
-	return fileMap, nil
+	return nil
 }
 
-func buildKey(path string, info os.FileInfo, fullPathReplace string, dirSuffixToSkip int) string {
-	if info.IsDir() {
-		return trimPathForKey(path, fullPathReplace, dirSuffixToSkip)
+// The files of the root directory are part of the walk as well, so the ignore and settings files of the root
+// have to be loaded before. The settings are used by the album of the prefix if there is one.
+func loadRootDirectory(fullPathRoot string, ignores *ignoreFiles, settingsByDir map[string]DirectorySettings) (DirectorySettings, error) {
+	err := ignores.loadDirectory(fullPathRoot, "")
+	if err != nil {
+		return DirectorySettings{}, err
+	}
+
+	settings, err := readDirectorySettings(fullPathRoot, DirectorySettings{})
+	if err != nil {
+		return DirectorySettings{}, err
 	}
-	fileName := filepath.Base(path)
-	directoryName := filepath.Dir(path)
-	cleanDir := trimPathForKey(directoryName, fullPathReplace, dirSuffixToSkip)
-	return filepath.Join(cleanDir, fileName)
+	settingsByDir[""] = settings
+	return settings, nil
 }
 
-func trimPathForKey(path string, fullPathReplace string, dirSuffixToSkip int) string {
-	trimmedPath := strings.Replace(path, fullPathReplace, "", 1)
-	for i := 0; i < dirSuffixToSkip; i++ {
-		trimmedPath = filepath.Clean(strings.TrimSuffix(trimmedPath, filepath.Base(trimmedPath)))
+// The albums of the prefix do not exist on the filesystem, but they are required to create the categories.
+// The album of the prefix itself represents the root directory, its parents are only virtual.
+func sendAlbumPrefixNodes(send func(node *FilesystemNode) error, fullPathRoot string, albumPrefix string, settings DirectorySettings) error {
+	var keys []string
+	for key := albumPrefix; key != ""; key = categoryKey.Parent(key) {
+		keys = append([]string{key}, keys...)
 	}
-	if trimmedPath == "." {
-		return "root"
+
+	for _, key := range keys {
+		node := &FilesystemNode{Key: key, Path: key, Name: categoryKey.Name(key), IsDir: true}
+		if key == albumPrefix {
+			node.Path = fullPathRoot
+			node.Settings = settings
+		}
+
+		err := send(node)
+		if err != nil {
+			return err
+		}
 	}
-	return trimmedPath
+	return nil
 }
@@ -0,0 +1,172 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package localFileStructure
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// InvalidImageError is returned for files that piwigo would not be able to process.
+type InvalidImageError struct {
+	Reason string
+}
+
+func (e *InvalidImageError) Error() string {
+	return "invalid image: " + e.Reason
+}
+
+// The formats are named like the image package names them.
+var formatsByExtension = map[string]string{
+	".jpg":  "jpeg",
+	".jpeg": "jpeg",
+	".png":  "png",
+	".gif":  "gif",
+	".webp": "webp",
+}
+
+var (
+	jpegMagic = []byte{0xff, 0xd8, 0xff}
+	pngMagic  = []byte("\x89PNG\r\n\x1a\n")
+	gif87     = []byte("GIF87a")
+	gif89     = []byte("GIF89a")
+	riffMagic = []byte("RIFF")
+	webpMagic = []byte("WEBP")
+	ftypMagic = []byte("ftyp")
+)
+
+// Brands of the ISO base media files that contain HEIF images, as phones often store them with a jpg extension.
+var heifBrands = []string{"heic", "heix", "heim", "heis", "hevc", "hevx", "mif1", "msf1", "avif"}
+
+// ValidatedFileCheckSums wraps the checksum calculator to validate the files before they are hashed. Files that are
+// not valid images are reported with an InvalidImageError.
+func ValidatedFileCheckSums(calculate func(filePath string) (FileChecksums, error)) func(filePath string) (FileChecksums, error) {
+	return func(filePath string) (FileChecksums, error) {
+		err := ValidateImage(filePath)
+		if err != nil {
+			return FileChecksums{}, err
+		}
+		return calculate(filePath)
+	}
+}
+
+// ValidateImage checks if the content of the file matches its extension and if it could be decoded. Only jpeg, png,
+// gif and webp files are validated, other extensions are accepted as they are. Errors reading the file are returned
+// as they are, problems of the content as InvalidImageError.
+func ValidateImage(filePath string) error {
+	expected, ok := formatsByExtension[strings.ToLower(filepath.Ext(filePath))]
+	if !ok {
+		return nil
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		return &InvalidImageError{Reason: "empty file"}
+	}
+
+	header := make([]byte, 32)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	header = header[:n]
+
+	format := sniffImageFormat(header)
+	if format == "" {
+		return &InvalidImageError{Reason: "unknown content, expected " + expected}
+	}
+	if format != expected {
+		return &InvalidImageError{Reason: fmt.Sprintf("content is %s, expected %s", format, expected)}
+	}
+
+	if format == "webp" {
+		return validateWebp(header, info.Size())
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	// decoding the whole image is the only way to find truncated or corrupt image data
+	_, _, err = image.Decode(file)
+	if err != nil {
+		return &InvalidImageError{Reason: fmt.Sprintf("broken %s: %s", format, err)}
+	}
+	return nil
+}
+
+func sniffImageFormat(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, jpegMagic):
+		return "jpeg"
+	case bytes.HasPrefix(header, pngMagic):
+		return "png"
+	case bytes.HasPrefix(header, gif87), bytes.HasPrefix(header, gif89):
+		return "gif"
+	case len(header) >= 12 && bytes.Equal(header[0:4], riffMagic) && bytes.Equal(header[8:12], webpMagic):
+		return "webp"
+	case len(header) >= 12 && bytes.Equal(header[4:8], ftypMagic):
+		brand := string(header[8:12])
+		for _, heifBrand := range heifBrands {
+			if brand == heifBrand {
+				return "heif"
+			}
+		}
+	}
+	return ""
+}
+
+// There is no webp decoder in the standard library, so only the container and the header of the first chunk are
+// checked. As the container stores its size, truncated files are found anyway.
+func validateWebp(header []byte, fileSize int64) error {
+	if len(header) < 20 {
+		return &InvalidImageError{Reason: "broken webp: file too short"}
+	}
+
+	riffSize := int64(binary.LittleEndian.Uint32(header[4:8]))
+	if riffSize+8 > fileSize {
+		return &InvalidImageError{Reason: fmt.Sprintf("broken webp: truncated to %d of %d bytes", fileSize, riffSize+8)}
+	}
+
+	chunkSize := int64(binary.LittleEndian.Uint32(header[16:20]))
+	if 20+chunkSize > riffSize+8 {
+		return &InvalidImageError{Reason: "broken webp: first chunk exceeds the file"}
+	}
+
+	switch string(header[12:16]) {
+	case "VP8 ":
+		// lossy images start with a frame tag followed by the start code
+		if len(header) < 26 || !bytes.Equal(header[23:26], []byte{0x9d, 0x01, 0x2a}) {
+			return &InvalidImageError{Reason: "broken webp: missing VP8 start code"}
+		}
+	case "VP8L":
+		if len(header) < 21 || header[20] != 0x2f {
+			return &InvalidImageError{Reason: "broken webp: missing VP8L signature"}
+		}
+	case "VP8X":
+	default:
+		return &InvalidImageError{Reason: fmt.Sprintf("broken webp: unknown chunk %q", header[12:16])}
+	}
+	return nil
+}
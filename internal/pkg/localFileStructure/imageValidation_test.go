@@ -0,0 +1,136 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package localFileStructure
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_ValidateImage_should_accept_valid_images(t *testing.T) {
+	rootPath := createTestTree(t)
+	defer os.RemoveAll(rootPath)
+
+	files := map[string][]byte{
+		"img.jpg":  encodeTestImage(t, "jpeg"),
+		"img.JPEG": encodeTestImage(t, "jpeg"),
+		"img.png":  encodeTestImage(t, "png"),
+		"img.gif":  encodeTestImage(t, "gif"),
+		"img.webp": createTestWebp(0),
+		"clip.mp4": []byte("not validated"),
+	}
+
+	for name, content := range files {
+		path := writeNamedTestFile(t, rootPath, name, content)
+		if err := ValidateImage(path); err != nil {
+			t.Errorf("Expected %s to be valid but got %s", name, err)
+		}
+	}
+}
+
+func Test_ValidateImage_should_report_invalid_images(t *testing.T) {
+	rootPath := createTestTree(t)
+	defer os.RemoveAll(rootPath)
+
+	jpegContent := encodeTestImage(t, "jpeg")
+	webpContent := createTestWebp(0)
+	heicContent := append([]byte{0, 0, 0, 24}, []byte("ftypheic\x00\x00\x00\x00mif1heic")...)
+
+	files := map[string]struct {
+		content []byte
+		reason  string
+	}{
+		"empty.jpg":      {nil, "empty file"},
+		"heic.jpg":       {heicContent, "content is heif, expected jpeg"},
+		"png.jpg":        {encodeTestImage(t, "png"), "content is png, expected jpeg"},
+		"text.png":       {[]byte("hello world"), "unknown content, expected png"},
+		"truncated.jpg":  {jpegContent[:len(jpegContent)/2], "broken jpeg"},
+		"truncated.webp": {webpContent[:len(webpContent)-4], "broken webp: truncated"},
+	}
+
+	for name, file := range files {
+		path := writeNamedTestFile(t, rootPath, name, file.content)
+		err := ValidateImage(path)
+		invalid, ok := err.(*InvalidImageError)
+		if !ok {
+			t.Errorf("Expected %s to be invalid but got %v", name, err)
+			continue
+		}
+		if !strings.HasPrefix(invalid.Reason, file.reason) {
+			t.Errorf("Expected the reason of %s to start with '%s' but got '%s'", name, file.reason, invalid.Reason)
+		}
+	}
+}
+
+func Test_ValidatedFileCheckSums_should_not_hash_invalid_images(t *testing.T) {
+	rootPath := createTestTree(t)
+	defer os.RemoveAll(rootPath)
+	path := writeNamedTestFile(t, rootPath, "empty.jpg", nil)
+
+	calculatorCalled := false
+	calculator := func(filePath string) (FileChecksums, error) {
+		calculatorCalled = true
+		return FileChecksums{}, nil
+	}
+
+	_, err := ValidatedFileCheckSums(calculator)(path)
+
+	if _, ok := err.(*InvalidImageError); !ok {
+		t.Errorf("Expected an InvalidImageError but got %v", err)
+	}
+	if calculatorCalled {
+		t.Error("Expected the invalid image not to be hashed")
+	}
+}
+
+func encodeTestImage(t *testing.T, format string) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	buffer := &bytes.Buffer{}
+
+	var err error
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(buffer, img, nil)
+	case "png":
+		err = png.Encode(buffer, img)
+	case "gif":
+		err = gif.Encode(buffer, img, nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+// Builds a webp container with a lossless chunk. Only the headers are valid, which is all the validation checks.
+func createTestWebp(padding int) []byte {
+	chunk := append([]byte{0x2f}, make([]byte, 9+padding)...)
+
+	content := &bytes.Buffer{}
+	content.WriteString("RIFF")
+	_ = binary.Write(content, binary.LittleEndian, uint32(4+8+len(chunk)))
+	content.WriteString("WEBPVP8L")
+	_ = binary.Write(content, binary.LittleEndian, uint32(len(chunk)))
+	content.Write(chunk)
+	return content.Bytes()
+}
+
+func writeNamedTestFile(t *testing.T, rootPath string, name string, content []byte) string {
+	path := filepath.Join(rootPath, name)
+	if err := ioutil.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
@@ -0,0 +1,138 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package localFileStructure
+
+import (
+	"fmt"
+	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/categoryKey"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const rewriteSeparator = "=>"
+
+const collapsedNameSeparator = " - "
+
+// RewriteRule replaces the matches of the regular expression in the relative directory path. The path uses
+// slashes as separator and the replacement may use the capture groups like $1 or ${name}.
+type RewriteRule struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// ParseRewriteRule parses a rule like "^(\d{4})/[^/]+ (.+)/RAW-Export/jpg$=>$1/$2".
+func ParseRewriteRule(definition string) (RewriteRule, error) {
+	parts := strings.SplitN(definition, rewriteSeparator, 2)
+	if len(parts) != 2 {
+		return RewriteRule{}, fmt.Errorf("invalid rewrite rule '%s'. Expected regex%sreplacement", definition, rewriteSeparator)
+	}
+
+	pattern, err := regexp.Compile(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return RewriteRule{}, fmt.Errorf("invalid regular expression in rewrite rule '%s': %s", definition, err)
+	}
+	return RewriteRule{pattern: pattern, replacement: strings.TrimSpace(parts[1])}, nil
+}
+
+// keyBuilder builds the category keys of the scanned directories and files. Directories with an empty key do not
+// get an album of their own. Albums that do not exist on the filesystem are reported by newAlbums after building
+// the first key that uses them.
+type keyBuilder interface {
+	buildKey(path string, info os.FileInfo) (string, error)
+	newAlbums() []string
+}
+
+// Mirrors the directory structure to the albums.
+type directoryKeyBuilder struct {
+	fullPathRoot    string
+	albumPrefix     string
+	dirSuffixToSkip int
+	rewrites        []RewriteRule
+	maxDepth        int
+	joinCollapsed   bool
+}
+
+func (b *directoryKeyBuilder) buildKey(path string, info os.FileInfo) (string, error) {
+	if info.IsDir() {
+		return b.trimPathForKey(path), nil
+	}
+	fileName := filepath.Base(path)
+	directoryName := filepath.Dir(path)
+	cleanDir := b.trimPathForKey(directoryName)
+	return categoryKey.Append(cleanDir, fileName), nil
+}
+
+func (b *directoryKeyBuilder) newAlbums() []string {
+	return nil
+}
+
+func (b *directoryKeyBuilder) trimPathForKey(path string) string {
+	trimmedPath := "."
+	if path != b.fullPathRoot {
+		trimmedPath = strings.Replace(path, fmt.Sprintf("%s%c", b.fullPathRoot, os.PathSeparator), "", 1)
+	}
+	for i := 0; i < b.dirSuffixToSkip; i++ {
+		trimmedPath = filepath.Clean(strings.TrimSuffix(trimmedPath, filepath.Base(trimmedPath)))
+	}
+
+	var names []string
+	if trimmedPath != "." {
+		names = rewritePath(b.rewrites, strings.Split(filepath.ToSlash(trimmedPath), "/"))
+	}
+
+	names = b.limitDepth(names)
+
+	if len(names) == 0 {
+		if b.albumPrefix != "" {
+			return b.albumPrefix
+		}
+		return "root"
+	}
+	if b.albumPrefix != "" {
+		return b.albumPrefix + categoryKey.Separator + categoryKey.Join(names...)
+	}
+	return categoryKey.Join(names...)
+}
+
+// Directories below the maximum depth are merged into their ancestor at the limit. If the collapsed names are
+// joined, the album at the limit is named by all of them, e.g. "Trip - Day 2 - Beach".
+func (b *directoryKeyBuilder) limitDepth(names []string) []string {
+	if b.maxDepth <= 0 || len(names) <= b.maxDepth {
+		return names
+	}
+
+	if !b.joinCollapsed {
+		return names[:b.maxDepth]
+	}
+
+	limited := make([]string, 0, b.maxDepth)
+	limited = append(limited, names[:b.maxDepth-1]...)
+	return append(limited, strings.Join(names[b.maxDepth-1:], collapsedNameSeparator))
+}
+
+// Applies all rules in their order to the directory names joined by slashes. Empty names created by the
+// replacements are removed, so a rule is able to drop a directory.
+func rewritePath(rules []RewriteRule, names []string) []string {
+	if len(rules) == 0 {
+		return names
+	}
+
+	path := strings.Join(names, "/")
+	for _, rule := range rules {
+		path = rule.pattern.ReplaceAllString(path, rule.replacement)
+	}
+
+	var rewritten []string
+	for _, name := range strings.Split(path, "/") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			rewritten = append(rewritten, name)
+		}
+	}
+	return rewritten
+}
@@ -0,0 +1,130 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package localFileStructure
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_trimPathForKey_should_apply_rewrites_in_order(t *testing.T) {
+	builder := &directoryKeyBuilder{
+		fullPathRoot: filepath.FromSlash("/photos"),
+		rewrites: parseTestRewrites(t,
+			`^(\d{4})/\d{4}-\d{2}-\d{2} (.+)$=>$1/$2`,
+			`(^|/)Export(/|$)=>$1`,
+			`^Private(/|$)=>`),
+	}
+
+	tests := map[string]string{
+		"/photos":                           "root",
+		"/photos/2019":                      "2019",
+		"/photos/2019/2019-06-01 Holidays":  "2019/Holidays",
+		"/photos/2019/Export/Holidays":      "2019/Holidays",
+		"/photos/Private":                   "root",
+		"/photos/Private/Family":            "Family",
+		"/photos/Other/2019-06-01 Holidays": "Other/2019-06-01 Holidays",
+	}
+	for path, expected := range tests {
+		key := builder.trimPathForKey(filepath.FromSlash(path))
+		if key != expected {
+			t.Errorf("Expected key %s for %s but got %s", expected, path, key)
+		}
+	}
+}
+
+func Test_trimPathForKey_should_rewrite_after_skipping_suffixes(t *testing.T) {
+	builder := &directoryKeyBuilder{
+		fullPathRoot:    filepath.FromSlash("/photos"),
+		albumPrefix:     "Archive",
+		dirSuffixToSkip: 1,
+		rewrites:        parseTestRewrites(t, `^(\d{4})_(\w+)$=>$1/$2`),
+	}
+
+	key := builder.trimPathForKey(filepath.FromSlash("/photos/2019_Summer/jpg"))
+	if key != "Archive/2019/Summer" {
+		t.Errorf("Got unexpected key %s", key)
+	}
+}
+
+func Test_trimPathForKey_should_limit_the_album_depth(t *testing.T) {
+	builder := &directoryKeyBuilder{fullPathRoot: filepath.FromSlash("/photos"), albumPrefix: "Archive", maxDepth: 2}
+
+	tests := map[string]string{
+		"/photos/Trip":                  "Archive/Trip",
+		"/photos/Trip/Day 2":            "Archive/Trip/Day 2",
+		"/photos/Trip/Day 2/Beach":      "Archive/Trip/Day 2",
+		"/photos/Trip/Day 2/Beach/Sand": "Archive/Trip/Day 2",
+	}
+	for path, expected := range tests {
+		key := builder.trimPathForKey(filepath.FromSlash(path))
+		if key != expected {
+			t.Errorf("Expected key %s for %s but got %s", expected, path, key)
+		}
+	}
+
+	builder.maxDepth = 1
+	builder.joinCollapsed = true
+	key := builder.trimPathForKey(filepath.FromSlash("/photos/Trip/Day 2/Beach"))
+	if key != "Archive/Trip - Day 2 - Beach" {
+		t.Errorf("Got unexpected key %s", key)
+	}
+}
+
+func Test_StreamLocalFileStructures_should_fail_if_flattening_creates_collisions(t *testing.T) {
+	rootPath := createTestTree(t, "Trip/Day 1/img.jpg", "Trip/Day 2/img.jpg", "Trip/Day 2/other.jpg")
+	defer os.RemoveAll(rootPath)
+
+	_, err := scanLocalFileStructures([]ScanRoot{{Path: rootPath, MaxAlbumDepth: 1}}, 0)
+	if err == nil {
+		t.Fatal("Expected an error for files with the same key in one album")
+	}
+
+	nodes, err := scanLocalFileStructures([]ScanRoot{{Path: rootPath, MaxAlbumDepth: 1, JoinCollapsedAlbums: true}}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node, ok := nodes[filepath.Join(rootPath, "Trip", "Day 2", "img.jpg")]; !ok || node.Key != "Trip - Day 2/img.jpg" {
+		t.Errorf("Got unexpected node %+v", node)
+	}
+}
+
+func Test_ParseRewriteRule_should_fail_on_invalid_rules(t *testing.T) {
+	for _, definition := range []string{"^2019$", "^(2019$=>$1"} {
+		if _, err := ParseRewriteRule(definition); err == nil {
+			t.Errorf("Expected an error for rule %s", definition)
+		}
+	}
+}
+
+func Test_StreamLocalFileStructures_should_use_rewritten_keys(t *testing.T) {
+	rootPath := createTestTree(t, "2019/2019-06-01 Holidays/img.jpg")
+	defer os.RemoveAll(rootPath)
+
+	root := ScanRoot{Path: rootPath, Rewrites: parseTestRewrites(t, `^(\d{4})/\d{4}-\d{2}-\d{2} (.+)$=>$1/$2`)}
+	nodes, err := scanLocalFileStructures([]ScanRoot{root}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node, ok := nodes[filepath.Join(rootPath, "2019", "2019-06-01 Holidays", "img.jpg")]
+	if !ok || node.Key != "2019/Holidays/img.jpg" {
+		t.Errorf("Got unexpected node %+v", node)
+	}
+}
+
+func parseTestRewrites(t *testing.T, definitions ...string) []RewriteRule {
+	rewrites := make([]RewriteRule, 0, len(definitions))
+	for _, definition := range definitions {
+		rewrite, err := ParseRewriteRule(definition)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rewrites = append(rewrites, rewrite)
+	}
+	return rewrites
+}
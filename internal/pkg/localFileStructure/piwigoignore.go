@@ -0,0 +1,133 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package localFileStructure
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const ignoreFileName = ".piwigoignore"
+
+type ignorePattern struct {
+	base    string
+	glob    []string
+	negate  bool
+	dirOnly bool
+}
+
+// ignoreFiles keeps the patterns of all .piwigoignore files found so far by the relative directory. Every directory
+// inherits the patterns of its parent, so the patterns of the deepest ignore file come last.
+type ignoreFiles struct {
+	patterns map[string][]ignorePattern
+}
+
+func newIgnoreFiles() *ignoreFiles {
+	return &ignoreFiles{patterns: make(map[string][]ignorePattern)}
+}
+
+// Reads the ignore file of the directory if there is one. The parent directory has to be loaded before.
+func (f *ignoreFiles) loadDirectory(fullPath string, relativeDir string) error {
+	inherited := f.patterns[parentDir(relativeDir)]
+	if relativeDir == "" {
+		inherited = nil
+	}
+
+	own, err := readIgnoreFile(filepath.Join(fullPath, ignoreFileName), relativeDir)
+	if err != nil {
+		return err
+	}
+
+	patterns := make([]ignorePattern, 0, len(inherited)+len(own))
+	patterns = append(patterns, inherited...)
+	f.patterns[relativeDir] = append(patterns, own...)
+	return nil
+}
+
+// Same as git, the last matching pattern decides if the path is ignored or not.
+func (f *ignoreFiles) isIgnored(relativePath string, isDir bool) bool {
+	ignored := false
+	for _, pattern := range f.patterns[parentDir(relativePath)] {
+		if pattern.dirOnly && !isDir {
+			continue
+		}
+
+		patternPath := relativePath
+		if pattern.base != "" {
+			patternPath = strings.TrimPrefix(relativePath, pattern.base+"/")
+		}
+
+		if matchGlob(pattern.glob, strings.Split(patternPath, "/")) {
+			ignored = !pattern.negate
+		}
+	}
+	return ignored
+}
+
+func readIgnoreFile(filePath string, base string) ([]ignorePattern, error) {
+	file, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var patterns []ignorePattern
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		pattern, ok := parseIgnorePattern(scanner.Text(), base)
+		if ok {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns, scanner.Err()
+}
+
+func parseIgnorePattern(line string, base string) (ignorePattern, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignorePattern{}, false
+	}
+
+	pattern := ignorePattern{base: base}
+	if strings.HasPrefix(line, "!") {
+		pattern.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		pattern.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+
+	// a pattern containing a slash is relative to the directory of the ignore file, others match at any depth
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	if line == "" {
+		return ignorePattern{}, false
+	}
+
+	pattern.glob = strings.Split(line, "/")
+	if !anchored {
+		pattern.glob = append([]string{"**"}, pattern.glob...)
+	}
+	return pattern, true
+}
+
+func parentDir(relativePath string) string {
+	dir := path.Dir(relativePath)
+	if dir == "." || dir == "/" {
+		return ""
+	}
+	return dir
+}
@@ -0,0 +1,113 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package localFileStructure
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_parseIgnorePattern(t *testing.T) {
+	tests := map[string]struct {
+		line     string
+		expected ignorePattern
+		ok       bool
+	}{
+		"empty line":      {"", ignorePattern{}, false},
+		"blank line":      {" \t", ignorePattern{}, false},
+		"comment":         {"# holidays", ignorePattern{}, false},
+		"any depth":       {"*.tmp", ignorePattern{base: "2019", glob: []string{"**", "*.tmp"}}, true},
+		"trailing spaces": {"*.tmp  \r", ignorePattern{base: "2019", glob: []string{"**", "*.tmp"}}, true},
+		"negation":        {"!keep.tmp", ignorePattern{base: "2019", glob: []string{"**", "keep.tmp"}, negate: true}, true},
+		"escaped !":       {"\\!keep.tmp", ignorePattern{base: "2019", glob: []string{"**", "!keep.tmp"}}, true},
+		"escaped #":       {"\\#hash.jpg", ignorePattern{base: "2019", glob: []string{"**", "#hash.jpg"}}, true},
+		"anchored":        {"/Private", ignorePattern{base: "2019", glob: []string{"Private"}}, true},
+		"inner slash":     {"raw/*.jpg", ignorePattern{base: "2019", glob: []string{"raw", "*.jpg"}}, true},
+		"directory only":  {"Thumbs/", ignorePattern{base: "2019", glob: []string{"**", "Thumbs"}, dirOnly: true}, true},
+		"anchored dir":    {"/Private/", ignorePattern{base: "2019", glob: []string{"Private"}, dirOnly: true}, true},
+		"double star":     {"**/export/**/*.png", ignorePattern{base: "2019", glob: []string{"**", "export", "**", "*.png"}}, true},
+		"only a slash":    {"/", ignorePattern{}, false},
+	}
+	for name, test := range tests {
+		pattern, ok := parseIgnorePattern(test.line, "2019")
+		if ok != test.ok || !reflect.DeepEqual(pattern, test.expected) {
+			t.Errorf("%s: expected %+v (%t) but got %+v (%t)", name, test.expected, test.ok, pattern, ok)
+		}
+	}
+}
+
+func Test_isIgnored_should_follow_the_gitignore_rules(t *testing.T) {
+	rootPath, err := ioutil.TempDir("", "piwigoignore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(rootPath)
+
+	writeTestIgnoreFile(t, rootPath, "", "# temporary files", "*.tmp", "!keep.tmp", "/Private", "Thumbs/", "**/export/**/*.png", "\\#hash.jpg")
+	writeTestIgnoreFile(t, rootPath, "2019", "/draft.jpg", "raw/*.jpg", "!important.tmp")
+
+	ignores := newIgnoreFiles()
+	for _, relativeDir := range []string{"", "2019", "2019/raw", "2019/export", "2019/export/raw"} {
+		err = ignores.loadDirectory(filepath.Join(rootPath, filepath.FromSlash(relativeDir)), relativeDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		relativePath string
+		isDir        bool
+		expected     bool
+	}{
+		{"img.tmp", false, true},
+		{"2019/img.tmp", false, true},
+		{"2019/raw/img.tmp", false, true},
+		{"keep.tmp", false, false},
+		{"2019/keep.tmp", false, false},
+		{"Private", true, true},
+		{"Private", false, true},
+		{"2019/Private", true, false},
+		{"Thumbs", true, true},
+		{"2019/Thumbs", true, true},
+		{"Thumbs", false, false},
+		{"2019/export/img.png", false, true},
+		{"2019/export/raw/img.png", false, true},
+		{"2019/export/img.jpg", false, false},
+		{"#hash.jpg", false, true},
+		{"img.jpg", false, false},
+		// patterns of a nested ignore file are relative to its directory
+		{"2019/draft.jpg", false, true},
+		{"draft.jpg", false, false},
+		{"2019/export/draft.jpg", false, false},
+		{"2019/raw/img.jpg", false, true},
+		{"raw/img.jpg", false, false},
+		// the deeper ignore file overrides the patterns it inherits
+		{"2019/important.tmp", false, false},
+		{"2019/raw/important.tmp", false, false},
+		{"important.tmp", false, true},
+	}
+	for _, test := range tests {
+		if ignores.isIgnored(test.relativePath, test.isDir) != test.expected {
+			t.Errorf("Expected %s (directory: %t) to be ignored: %t", test.relativePath, test.isDir, test.expected)
+		}
+	}
+}
+
+func writeTestIgnoreFile(t *testing.T, rootPath string, relativeDir string, lines ...string) {
+	dir := filepath.Join(rootPath, filepath.FromSlash(relativeDir))
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, ignoreFileName), []byte(strings.Join(lines, "\n")+"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
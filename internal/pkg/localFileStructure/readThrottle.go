@@ -0,0 +1,158 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package localFileStructure
+
+import (
+	"github.com/sirupsen/logrus"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// The files are read in chunks of this size, so the bandwidth limit is met within a file as well.
+	readChunkSize = 64 * 1024
+	// The time the throughput gets measured before the adaptive parallelism is adjusted.
+	adaptInterval = 5 * time.Second
+	// Throughput changes below this ratio are treated as noise by the adaptive parallelism.
+	adaptTolerance = 0.05
+)
+
+// ReadThrottle limits the number of files that are read in parallel and the bandwidth used to read them. It is shared
+// by all checksum calculations, so the limits apply to the whole process. Spinning disks and network mounts get
+// slower if too many files are read at once.
+type ReadThrottle struct {
+	mutex   sync.Mutex
+	changed *sync.Cond
+
+	maxReaders int
+	readers    int
+	active     int
+
+	adaptive       bool
+	direction      int
+	windowStart    time.Time
+	windowBytes    int64
+	lastThroughput float64
+
+	bytesPerSecond int64
+	nextRead       time.Time
+}
+
+// NewReadThrottle creates a throttle reading at most maxReaders files in parallel. If adaptive is set, it starts
+// using a single reader and adjusts the number of readers between one and maxReaders by the measured throughput.
+// A positive bytesPerSecond limits the bandwidth of all readers together.
+func NewReadThrottle(maxReaders int, adaptive bool, bytesPerSecond int64) *ReadThrottle {
+	if maxReaders < 1 {
+		maxReaders = 1
+	}
+	throttle := &ReadThrottle{maxReaders: maxReaders, readers: maxReaders, adaptive: adaptive, direction: 1, bytesPerSecond: bytesPerSecond}
+	if adaptive {
+		throttle.readers = 1
+	}
+	throttle.changed = sync.NewCond(&throttle.mutex)
+	return throttle
+}
+
+// Waits until another file may be read. Every call has to be followed by a call to release.
+func (t *ReadThrottle) acquire() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for t.active >= t.readers {
+		t.changed.Wait()
+	}
+	if t.active == 0 {
+		// the time without any reader must not count as slow reading
+		t.startWindow(time.Now())
+	}
+	t.active++
+}
+
+func (t *ReadThrottle) release() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.active--
+	t.changed.Broadcast()
+}
+
+func (t *ReadThrottle) reader(r io.Reader) io.Reader {
+	return &throttledReader{reader: r, throttle: t}
+}
+
+// Reserves the bandwidth for the next chunk and accounts the bytes of the previous one. Returns the time to wait
+// before the chunk may be read.
+func (t *ReadThrottle) account(previous int, next int) time.Duration {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := time.Now()
+	if t.adaptive && previous > 0 {
+		t.windowBytes += int64(previous)
+		if elapsed := now.Sub(t.windowStart); elapsed >= adaptInterval {
+			t.adjust(float64(t.windowBytes) / elapsed.Seconds())
+			t.startWindow(now)
+		}
+	}
+
+	if t.bytesPerSecond <= 0 {
+		return 0
+	}
+	if t.nextRead.Before(now) {
+		t.nextRead = now
+	}
+	wait := t.nextRead.Sub(now)
+	t.nextRead = t.nextRead.Add(time.Duration(int64(next) * int64(time.Second) / t.bytesPerSecond))
+	return wait
+}
+
+func (t *ReadThrottle) startWindow(now time.Time) {
+	t.windowStart = now
+	t.windowBytes = 0
+}
+
+// Keeps changing the number of readers in the same direction as long as the throughput increases. If it drops, the
+// direction is reversed. Without a notable change, one reader less is tried, as it reaches the same throughput with
+// less seeking and load.
+func (t *ReadThrottle) adjust(throughput float64) {
+	switch {
+	case t.lastThroughput == 0 || throughput > t.lastThroughput*(1+adaptTolerance):
+	case throughput < t.lastThroughput*(1-adaptTolerance):
+		t.direction = -t.direction
+	default:
+		t.direction = -1
+	}
+	t.lastThroughput = throughput
+
+	readers := t.readers + t.direction
+	if readers < 1 || readers > t.maxReaders {
+		t.direction = -t.direction
+		return
+	}
+
+	logrus.Debugf("Reading %d files in parallel at %.1f MiB/s, changing to %d", t.readers, throughput/1024/1024, readers)
+	t.readers = readers
+	t.changed.Broadcast()
+}
+
+type throttledReader struct {
+	reader   io.Reader
+	throttle *ReadThrottle
+	previous int
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > readChunkSize {
+		p = p[:readChunkSize]
+	}
+	if wait := r.throttle.account(r.previous, len(p)); wait > 0 {
+		time.Sleep(wait)
+	}
+	n, err := r.reader.Read(p)
+	r.previous = n
+	return n, err
+}
@@ -0,0 +1,91 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package localFileStructure
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_readThrottle_limits_parallel_readers(t *testing.T) {
+	throttle := NewReadThrottle(2, false, 0)
+
+	mutex := sync.Mutex{}
+	active, maxActive := 0, 0
+	wg := sync.WaitGroup{}
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			throttle.acquire()
+			defer throttle.release()
+
+			mutex.Lock()
+			active++
+			if active > maxActive {
+				maxActive = active
+			}
+			mutex.Unlock()
+
+			time.Sleep(10 * time.Millisecond)
+
+			mutex.Lock()
+			active--
+			mutex.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if maxActive != 2 {
+		t.Errorf("Expected 2 parallel readers but got %d", maxActive)
+	}
+}
+
+func Test_readThrottle_limits_the_bandwidth(t *testing.T) {
+	throttle := NewReadThrottle(1, false, 512*1024)
+
+	start := time.Now()
+	read, err := io.Copy(ioutil.Discard, throttle.reader(bytes.NewReader(make([]byte, 256*1024))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+
+	if read != 256*1024 {
+		t.Errorf("Expected all bytes to be read but got %d", read)
+	}
+	if elapsed < 350*time.Millisecond {
+		t.Errorf("Expected reading 256 KiB at 512 KiB/s to take about half a second but it took %s", elapsed)
+	}
+}
+
+func Test_readThrottle_adjusts_the_readers_by_the_throughput(t *testing.T) {
+	throttle := NewReadThrottle(4, true, 0)
+
+	steps := []struct {
+		throughput float64
+		expected   int
+	}{
+		{100, 2}, // the first measurement always tries another reader
+		{150, 3}, // faster, keep adding readers
+		{100, 2}, // slower, go back
+		{101, 1}, // the same throughput with fewer readers is preferred
+		{100, 1}, // never below one reader
+	}
+	if throttle.readers != 1 {
+		t.Fatalf("Expected the adaptive throttle to start with one reader but got %d", throttle.readers)
+	}
+	for i, step := range steps {
+		throttle.adjust(step.throughput)
+		if throttle.readers != step.expected {
+			t.Errorf("Step %d: expected %d readers but got %d", i, step.expected, throttle.readers)
+		}
+	}
+}
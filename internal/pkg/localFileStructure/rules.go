@@ -0,0 +1,117 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package localFileStructure
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	ruleInclude     = "include"
+	ruleExclude     = "exclude"
+	ruleRegexPrefix = "re:"
+)
+
+// Rule includes or excludes files and directories by their path relative to the scanned root using slashes as
+// separator. The pattern is either a glob or a regular expression prefixed with "re:". Globs are matched case
+// insensitive, where "**" matches any number of directories. A glob without slash matches the name at any depth.
+type Rule struct {
+	Include bool
+	Pattern string
+	glob    []string
+	regex   *regexp.Regexp
+}
+
+// ParseRule parses a rule definition like "exclude **/Thumbs/**" or "include re:^Export/".
+func ParseRule(definition string) (Rule, error) {
+	parts := strings.SplitN(strings.TrimSpace(definition), " ", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[1]) == "" {
+		return Rule{}, fmt.Errorf("invalid rule '%s'. Expected include|exclude followed by a pattern", definition)
+	}
+
+	rule := Rule{Pattern: strings.TrimSpace(parts[1])}
+	switch strings.ToLower(parts[0]) {
+	case ruleInclude:
+		rule.Include = true
+	case ruleExclude:
+		rule.Include = false
+	default:
+		return Rule{}, fmt.Errorf("invalid rule '%s'. Expected include|exclude followed by a pattern", definition)
+	}
+
+	if strings.HasPrefix(rule.Pattern, ruleRegexPrefix) {
+		regex, err := regexp.Compile(strings.TrimPrefix(rule.Pattern, ruleRegexPrefix))
+		if err != nil {
+			return Rule{}, fmt.Errorf("invalid regular expression in rule '%s': %s", definition, err)
+		}
+		rule.regex = regex
+		return rule, nil
+	}
+
+	glob := strings.Trim(strings.ToLower(rule.Pattern), "/")
+	if !strings.Contains(glob, "/") {
+		glob = "**/" + glob
+	}
+	rule.glob = strings.Split(glob, "/")
+	for _, segment := range rule.glob {
+		if _, err := path.Match(segment, ""); err != nil {
+			return Rule{}, fmt.Errorf("invalid glob in rule '%s': %s", definition, err)
+		}
+	}
+	return rule, nil
+}
+
+func (rule Rule) matches(relativePath string) bool {
+	if rule.regex != nil {
+		return rule.regex.MatchString(relativePath)
+	}
+	return matchGlob(rule.glob, strings.Split(strings.ToLower(relativePath), "/"))
+}
+
+// The first matching rule decides. Files without a matching rule are only excluded if there are include rules.
+// Directories are only excluded by a matching exclude rule, as include rules may match files below them.
+func isExcluded(rules []Rule, relativePath string, isDir bool) bool {
+	for _, rule := range rules {
+		if rule.matches(relativePath) {
+			return !rule.Include
+		}
+	}
+
+	if isDir {
+		return false
+	}
+	for _, rule := range rules {
+		if rule.Include {
+			return true
+		}
+	}
+	return false
+}
+
+func matchGlob(glob []string, segments []string) bool {
+	if len(glob) == 0 {
+		return len(segments) == 0
+	}
+
+	if glob[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchGlob(glob[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(segments) == 0 {
+		return false
+	}
+
+	matched, _ := path.Match(glob[0], segments[0])
+	return matched && matchGlob(glob[1:], segments[1:])
+}
@@ -0,0 +1,74 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package localFileStructure
+
+import "testing"
+
+func Test_isExcluded_should_apply_glob_rules(t *testing.T) {
+	rules := parseTestRules(t, "exclude **/Thumbs/**", "exclude *_edited_backup.jpg", "exclude Private/**/*.png")
+
+	tests := map[string]bool{
+		"2019/Thumbs":                       true,
+		"2019/Thumbs/img.jpg":               true,
+		"2019/img_edited_backup.jpg":        true,
+		"2019/img.jpg":                      false,
+		"Private/2019/img.png":              true,
+		"Private/2019/img.jpg":              false,
+		"Public/Private/2019/img.png":       false,
+		"2019/thumbs/IMG_EDITED_BACKUP.JPG": true,
+	}
+	for relativePath, expected := range tests {
+		if isExcluded(rules, relativePath, false) != expected {
+			t.Errorf("Expected %s to be excluded: %t", relativePath, expected)
+		}
+	}
+}
+
+func Test_isExcluded_should_only_include_matching_files(t *testing.T) {
+	rules := parseTestRules(t, "include **/Export/**")
+
+	if isExcluded(rules, "2019/Export/img.jpg", false) {
+		t.Error("Expected the exported image to be included")
+	}
+	if !isExcluded(rules, "2019/img.jpg", false) {
+		t.Error("Expected the image outside of the export directory to be excluded")
+	}
+	if isExcluded(rules, "2019", true) {
+		t.Error("Directories must not be excluded by include rules")
+	}
+}
+
+func Test_isExcluded_should_use_first_matching_rule(t *testing.T) {
+	rules := parseTestRules(t, "include re:^Private/Shared/", "exclude Private/**")
+
+	if isExcluded(rules, "Private/Shared/img.jpg", false) {
+		t.Error("Expected the shared image to be included by the first rule")
+	}
+	if !isExcluded(rules, "Private/img.jpg", false) {
+		t.Error("Expected the private image to be excluded")
+	}
+}
+
+func Test_ParseRule_should_fail_on_invalid_definitions(t *testing.T) {
+	definitions := []string{"exclude", "ignore *.jpg", "exclude re:(", "exclude [a"}
+	for _, definition := range definitions {
+		if _, err := ParseRule(definition); err == nil {
+			t.Errorf("Expected an error for the rule '%s'", definition)
+		}
+	}
+}
+
+func parseTestRules(t *testing.T, definitions ...string) []Rule {
+	var rules []Rule
+	for _, definition := range definitions {
+		rule, err := ParseRule(definition)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
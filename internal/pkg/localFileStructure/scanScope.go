@@ -0,0 +1,57 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package localFileStructure
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// AffectsDirectory reports if a change of the file affects the other files of its directory. This is the case for
+// the settings and ignore files as well as for the sidecars.
+func AffectsDirectory(path string) bool {
+	name := filepath.Base(path)
+	return name == settingsFileName || name == ignoreFileName || strings.EqualFold(filepath.Ext(name), ".xmp")
+}
+
+// scanScope limits a scan to some files and directories. The directories leading to them are walked as well, as
+// their settings and ignore files apply to the files within the scope.
+type scanScope struct {
+	paths []string
+}
+
+func newScanScope(paths []string) *scanScope {
+	if len(paths) == 0 {
+		return nil
+	}
+
+	scope := &scanScope{}
+	for _, path := range paths {
+		scope.paths = append(scope.paths, filepath.Clean(path))
+	}
+	return scope
+}
+
+// Reports if the path is one of the paths of the scope or within one of them.
+func (s *scanScope) contains(path string) bool {
+	for _, scopePath := range s.paths {
+		if path == scopePath || strings.HasPrefix(path, scopePath+string(os.PathSeparator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports if the directory contains one of the paths of the scope.
+func (s *scanScope) leadsTo(dir string) bool {
+	for _, scopePath := range s.paths {
+		if strings.HasPrefix(scopePath, dir+string(os.PathSeparator)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package localFileStructure
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_scan_should_only_send_the_paths_of_the_scope(t *testing.T) {
+	rootPath := createTestTree(t, "a/x.jpg", "a/y.jpg", "b/z.jpg", "c/d/w.jpg")
+	defer os.RemoveAll(rootPath)
+
+	root := ScanRoot{
+		Path:       rootPath,
+		Extensions: []string{"jpg"},
+		Paths:      []string{filepath.Join(rootPath, "a", "x.jpg"), filepath.Join(rootPath, "c")},
+	}
+	nodes, err := scanLocalFileStructures([]ScanRoot{root}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]bool{
+		"a/x.jpg":   true,
+		"a/y.jpg":   false,
+		"b":         false,
+		"b/z.jpg":   false,
+		"c/d":       true,
+		"c/d/w.jpg": true,
+	}
+	for path, found := range expected {
+		if _, ok := nodes[filepath.Join(rootPath, filepath.FromSlash(path))]; ok != found {
+			t.Errorf("Expected %s to be found: %t", path, found)
+		}
+	}
+}
+
+func Test_AffectsDirectory(t *testing.T) {
+	tests := map[string]bool{
+		"/images/.piwigo.ini":    true,
+		"/images/.piwigoignore":  true,
+		"/images/IMG_1.XMP":      true,
+		"/images/IMG_1.jpg":      false,
+		"/images/IMG_1.jpg.orig": false,
+	}
+	for path, expected := range tests {
+		if AffectsDirectory(path) != expected {
+			t.Errorf("Expected AffectsDirectory of %s to be %t", path, expected)
+		}
+	}
+}
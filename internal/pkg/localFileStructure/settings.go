@@ -0,0 +1,126 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package localFileStructure
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const settingsFileName = ".piwigo.ini"
+
+const (
+	StatusPublic  = "public"
+	StatusPrivate = "private"
+)
+
+// DirectorySettings are read from the .piwigo.ini file of a directory. Subdirectories inherit the settings of their
+// parent except the cover and the rank, as these only make sense for the directory that defines them.
+// Files use the settings of the directory they are in.
+type DirectorySettings struct {
+	Description string
+	Status      string
+	Users       []int
+	Groups      []int
+	Tags        []string
+	Cover       string
+	Rank        int
+	SkipUpload  bool
+}
+
+// Reads the settings file of the directory and applies it on top of the inherited settings of the parent.
+func readDirectorySettings(directoryPath string, parent DirectorySettings) (DirectorySettings, error) {
+	settings := parent
+	settings.Cover = ""
+	settings.Rank = 0
+
+	filePath := filepath.Join(directoryPath, settingsFileName)
+	file, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return settings, nil
+	}
+	if err != nil {
+		return settings, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return settings, fmt.Errorf("%s:%d: expected key = value", filePath, lineNumber)
+		}
+
+		err = settings.set(strings.ToLower(strings.TrimSpace(parts[0])), strings.TrimSpace(parts[1]))
+		if err != nil {
+			return settings, fmt.Errorf("%s:%d: %s", filePath, lineNumber, err)
+		}
+	}
+
+	return settings, scanner.Err()
+}
+
+func (settings *DirectorySettings) set(key string, value string) error {
+	var err error
+	switch key {
+	case "description":
+		settings.Description = value
+	case "status":
+		if value != StatusPublic && value != StatusPrivate {
+			return fmt.Errorf("invalid status %s. Expected %s or %s", value, StatusPublic, StatusPrivate)
+		}
+		settings.Status = value
+	case "users":
+		settings.Users, err = parseIds(value)
+	case "groups":
+		settings.Groups, err = parseIds(value)
+	case "tags":
+		settings.Tags = splitValues(value)
+	case "cover":
+		settings.Cover = value
+	case "rank":
+		settings.Rank, err = strconv.Atoi(value)
+	case "upload":
+		var upload bool
+		upload, err = strconv.ParseBool(value)
+		settings.SkipUpload = !upload
+	default:
+		return fmt.Errorf("unknown setting %s", key)
+	}
+	return err
+}
+
+func parseIds(value string) ([]int, error) {
+	var ids []int
+	for _, part := range splitValues(value) {
+		id, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid id %s", part)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func splitValues(value string) []string {
+	var values []string
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part != "" {
+			values = append(values, part)
+		}
+	}
+	return values
+}
@@ -0,0 +1,33 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package localFileStructure
+
+import (
+	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/metadata"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Returns the path and the modification time of the xmp sidecar of the file. The path is empty if the file has
+// no sidecar. The names are compared case insensitive as IMG_1.JPG often comes with an IMG_1.xmp. The sidecar is
+// taken from the entries of the walk, so the cached entries of unchanged directories are used.
+func findSidecar(listings *directoryListings, path string) (string, time.Time, error) {
+	dir := filepath.Dir(path)
+	entries, err := listings.list(dir)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	for _, sidecarName := range metadata.SidecarNames(filepath.Base(path)) {
+		for _, entry := range entries {
+			if !entry.IsDir && strings.ToLower(entry.Name) == sidecarName {
+				return filepath.Join(dir, entry.Name), entry.ModTime, nil
+			}
+		}
+	}
+	return "", time.Time{}, nil
+}
@@ -0,0 +1,64 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package localFileStructure
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_scan_should_find_the_sidecars_of_the_files(t *testing.T) {
+	rootPath := createTestTree(t, "shoot/IMG_1.JPG", "shoot/IMG_1.xmp", "shoot/IMG_2.jpg", "shoot/IMG_2.jpg.xmp",
+		"shoot/IMG_2.xmp", "shoot/IMG_3.jpg")
+	defer os.RemoveAll(rootPath)
+
+	root := ScanRoot{Path: rootPath, Extensions: []string{"jpg"}, Sidecars: true}
+	nodes, err := scanLocalFileStructures([]ScanRoot{root}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"IMG_1.JPG": filepath.Join(rootPath, "shoot", "IMG_1.xmp"),
+		"IMG_2.jpg": filepath.Join(rootPath, "shoot", "IMG_2.jpg.xmp"),
+		"IMG_3.jpg": "",
+	}
+	for name, sidecar := range expected {
+		node, ok := nodes[filepath.Join(rootPath, "shoot", name)]
+		if !ok {
+			t.Errorf("Expected the file %s to be found", name)
+			continue
+		}
+		if node.SidecarPath != sidecar {
+			t.Errorf("Expected the sidecar '%s' of %s but got '%s'", sidecar, name, node.SidecarPath)
+		}
+		if sidecar != "" && node.SidecarModTime.IsZero() {
+			t.Errorf("Expected the modification time of the sidecar of %s", name)
+		}
+	}
+}
+
+func Test_scan_should_find_the_sidecars_in_the_cached_entries(t *testing.T) {
+	rootPath := createTestTree(t, "shoot/IMG_1.jpg", "shoot/IMG_2.jpg", "shoot/IMG_2.xmp")
+	defer os.RemoveAll(rootPath)
+	cache := newTestDirectoryCache()
+	dirTime := setDirectoryTimes(t, rootPath, "", "shoot")
+
+	root := ScanRoot{Path: rootPath, Cache: cache, Sidecars: true}
+	scanCachedTestTree(t, root)
+	// the unchanged directory is served from the cache, so the new sidecar is not seen yet
+	addFileKeepingDirectoryTime(t, rootPath, "shoot/IMG_1.xmp", dirTime)
+
+	nodes := scanCachedTestTree(t, root)
+
+	if nodes["shoot/IMG_1.jpg"].SidecarPath != "" {
+		t.Errorf("Expected the sidecar to be looked up in the cached entries but got %s", nodes["shoot/IMG_1.jpg"].SidecarPath)
+	}
+	if nodes["shoot/IMG_2.jpg"].SidecarPath != filepath.Join(rootPath, "shoot", "IMG_2.xmp") || nodes["shoot/IMG_2.jpg"].SidecarModTime.IsZero() {
+		t.Errorf("Expected the cached sidecar of IMG_2.jpg but got '%s'", nodes["shoot/IMG_2.jpg"].SidecarPath)
+	}
+}
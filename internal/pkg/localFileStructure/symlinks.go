@@ -0,0 +1,186 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package localFileStructure
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// SymlinkKeysLink builds the category key using the path of the link.
+	SymlinkKeysLink = "link"
+	// SymlinkKeysResolved builds the category key using the path the link points to.
+	SymlinkKeysResolved = "resolved"
+)
+
+// Same as filepath.WalkFunc, but with the path that is used to build the key. The path and the key path only
+// differ if symlinks are followed.
+type walkFunc func(path string, keyPath string, info os.FileInfo) error
+
+// Walks the tree using the cache if there is one. The cache is not used if symlinks are followed. The entries of
+// the directories the walk is in are kept in the listings.
+func walk(root string, followSymlinks bool, symlinkKeys string, cache DirectoryCache, fullScan bool, listings *directoryListings, fn walkFunc) error {
+	if !followSymlinks {
+		info, err := os.Lstat(root)
+		if err != nil {
+			return err
+		}
+		walker := &cachedWalker{cache: cache, fullScan: fullScan, fn: fn, listings: listings}
+		return walker.walk(root, info)
+	}
+
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+
+	walker := &symlinkWalker{
+		root:         root,
+		resolvedRoot: resolvedRoot,
+		useResolved:  symlinkKeys == SymlinkKeysResolved,
+		fn:           fn,
+		listings:     listings,
+		visitedDirs:  make(map[string]bool),
+		visitedFiles: make(map[string]bool),
+	}
+	return walker.walk(root, root, info)
+}
+
+// The symlinkWalker follows symlinks to files and directories. Every directory and file is only visited once,
+// identified by its device and inode. This prevents endless loops and handles files reachable by more than
+// one link only once. The first path in lexical order wins.
+type symlinkWalker struct {
+	root         string
+	resolvedRoot string
+	useResolved  bool
+	fn           walkFunc
+	listings     *directoryListings
+	visitedDirs  map[string]bool
+	visitedFiles map[string]bool
+}
+
+func (w *symlinkWalker) walk(path string, keyPath string, info os.FileInfo) error {
+	identity, err := fileIdentity(path, info)
+	if err != nil {
+		return err
+	}
+
+	visited := w.visitedFiles
+	if info.IsDir() {
+		visited = w.visitedDirs
+	}
+	if visited[identity] {
+		logrus.Debugf("Skipping %s as it was already scanned using another path", path)
+		return nil
+	}
+
+	err = w.fn(path, keyPath, info)
+	if err == filepath.SkipDir && info.IsDir() {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	visited[identity] = true
+
+	if !info.IsDir() {
+		return nil
+	}
+
+	names, err := readDirNames(path)
+	if err != nil {
+		return err
+	}
+
+	// the children are resolved first, so their entries describe the targets of the links
+	type child struct {
+		path    string
+		keyPath string
+		info    os.FileInfo
+	}
+	children := make([]child, 0, len(names))
+	entries := make([]DirectoryEntry, 0, len(names))
+	for _, name := range names {
+		childPath := filepath.Join(path, name)
+		childKeyPath := filepath.Join(keyPath, name)
+
+		childInfo, err := os.Lstat(childPath)
+		if err != nil {
+			return err
+		}
+
+		targetPath := childPath
+		if childInfo.Mode()&os.ModeSymlink != 0 {
+			resolvedPath, err := filepath.EvalSymlinks(childPath)
+			if err != nil {
+				logrus.Warnf("Skipping broken symlink %s - %s", childPath, err)
+				continue
+			}
+
+			childInfo, err = os.Stat(resolvedPath)
+			if err != nil {
+				logrus.Warnf("Skipping symlink %s - %s", childPath, err)
+				continue
+			}
+
+			targetPath = resolvedPath
+			if w.useResolved {
+				childPath = resolvedPath
+				childKeyPath = w.resolvedKeyPath(keyPath, resolvedPath)
+			}
+		}
+
+		entry := newDirectoryEntry(targetPath, childInfo)
+		entry.Name = name
+		children = append(children, child{path: childPath, keyPath: childKeyPath, info: childInfo})
+		entries = append(entries, entry)
+	}
+	w.listings.add(path, entries)
+	defer w.listings.remove(path)
+
+	for _, c := range children {
+		err = w.walk(c.path, c.keyPath, c.info)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Targets within the root are used with their real location. Targets outside of the root keep the
+// position of the link but use the name of the target.
+func (w *symlinkWalker) resolvedKeyPath(parentKeyPath string, resolvedPath string) string {
+	rootPrefix := fmt.Sprintf("%s%c", w.resolvedRoot, os.PathSeparator)
+	if strings.HasPrefix(resolvedPath, rootPrefix) {
+		return filepath.Join(w.root, strings.TrimPrefix(resolvedPath, rootPrefix))
+	}
+	return filepath.Join(parentKeyPath, filepath.Base(resolvedPath))
+}
+
+func readDirNames(path string) ([]string, error) {
+	dir, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,96 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package localFileStructure
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_StreamLocalFileStructures_should_not_follow_symlinks_by_default(t *testing.T) {
+	rootPath, archivePath := createSymlinkTestTrees(t)
+	defer os.RemoveAll(rootPath)
+	defer os.RemoveAll(archivePath)
+
+	keys := scanSymlinkTestTree(t, ScanRoot{Path: rootPath})
+
+	if len(keys) != 1 || !keys["2019/img.jpg"] {
+		t.Errorf("Expected only the file of the root but got %v", keys)
+	}
+}
+
+func Test_StreamLocalFileStructures_should_follow_symlinks_once_using_the_link_path(t *testing.T) {
+	rootPath, archivePath := createSymlinkTestTrees(t)
+	defer os.RemoveAll(rootPath)
+	defer os.RemoveAll(archivePath)
+
+	keys := scanSymlinkTestTree(t, ScanRoot{Path: rootPath, FollowSymlinks: true, SymlinkKeys: SymlinkKeysLink})
+
+	expected := []string{"2019/img.jpg", "Archive/Holidays/img.jpg"}
+	for _, key := range expected {
+		if !keys[key] {
+			t.Errorf("Did not find the expected file %s in %v", key, keys)
+		}
+	}
+	if len(keys) != len(expected) {
+		t.Errorf("Expected every file only once but got %v", keys)
+	}
+}
+
+func Test_StreamLocalFileStructures_should_follow_symlinks_using_the_resolved_path(t *testing.T) {
+	rootPath, archivePath := createSymlinkTestTrees(t)
+	defer os.RemoveAll(rootPath)
+	defer os.RemoveAll(archivePath)
+
+	keys := scanSymlinkTestTree(t, ScanRoot{Path: rootPath, FollowSymlinks: true, SymlinkKeys: SymlinkKeysResolved})
+
+	expected := []string{"2019/img.jpg", filepath.Base(archivePath) + "/Holidays/img.jpg"}
+	for _, key := range expected {
+		if !keys[key] {
+			t.Errorf("Did not find the expected file %s in %v", key, keys)
+		}
+	}
+	if len(keys) != len(expected) {
+		t.Errorf("Expected every file only once but got %v", keys)
+	}
+}
+
+// Creates a root containing a link to an archive outside of the root, a second link to the same archive,
+// a link to a directory within the root and a link back to the root.
+func createSymlinkTestTrees(t *testing.T) (string, string) {
+	rootPath := createTestTree(t, "2019/img.jpg")
+	archivePath := createTestTree(t, "Holidays/img.jpg")
+
+	links := map[string]string{
+		"Archive":      archivePath,
+		"ArchiveAgain": archivePath,
+		"Latest":       filepath.Join(rootPath, "2019"),
+		"2019/Loop":    rootPath,
+	}
+	for link, target := range links {
+		err := os.Symlink(target, filepath.Join(rootPath, filepath.FromSlash(link)))
+		if err != nil {
+			t.Skipf("Could not create symlink: %s", err)
+		}
+	}
+	return rootPath, archivePath
+}
+
+func scanSymlinkTestTree(t *testing.T, root ScanRoot) map[string]bool {
+	nodes, err := scanLocalFileStructures([]ScanRoot{root}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := make(map[string]bool)
+	for _, node := range nodes {
+		if !node.IsDir {
+			keys[node.Key] = true
+		}
+	}
+	return keys
+}
@@ -0,0 +1,91 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package localFileStructure
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// VariantPreference groups files of the same directory that share a base name, like IMG_1.CR2, IMG_1.JPG and
+// IMG_1-edit.jpg. Only the variant with the most preferred ending is uploaded. Files without a listed ending are not
+// grouped, so raw files still depend on the extensions only.
+type VariantPreference struct {
+	endings []string
+}
+
+// ParseVariantPreference parses a comma separated list of file name endings, the most preferred first,
+// e.g. "-edit.jpg,.jpg". The endings are compared case insensitive.
+func ParseVariantPreference(definition string) (*VariantPreference, error) {
+	preference := &VariantPreference{}
+	for _, ending := range strings.Split(definition, ",") {
+		ending = strings.ToLower(strings.TrimSpace(ending))
+		if ending == "" {
+			return nil, fmt.Errorf("invalid variant preference '%s'. Endings must not be empty", definition)
+		}
+		preference.endings = append(preference.endings, ending)
+	}
+	return preference, nil
+}
+
+// Returns the lower case base name and the rank of the file, where zero is the most preferred. The longest matching
+// ending is used, so IMG_1-edit.jpg belongs to IMG_1 with "-edit.jpg,.jpg" as well as with ".jpg,-edit.jpg".
+func (p *VariantPreference) match(name string) (string, int, bool) {
+	name = strings.ToLower(name)
+	rank := -1
+	for i, ending := range p.endings {
+		if len(ending) < len(name) && strings.HasSuffix(name, ending) && (rank < 0 || len(ending) > len(p.endings[rank])) {
+			rank = i
+		}
+	}
+	if rank < 0 {
+		return "", 0, false
+	}
+	return strings.TrimSuffix(name, p.endings[rank]), rank, true
+}
+
+// variantSelector decides if a file is the preferred variant of its group. The siblings are taken from the listing
+// of the walk, so unchanged directories served from the cache are not accessed.
+type variantSelector struct {
+	preference *VariantPreference
+	// isEligible reports if the sibling with the given relative path would be uploaded on its own.
+	isEligible func(entry DirectoryEntry, relativePath string) bool
+	listings   *directoryListings
+}
+
+// Returns false if a more preferred variant of the file exists. Otherwise the paths of the other variants are
+// returned, as their uploaded image gets replaced by the file.
+func (s *variantSelector) selectFile(path string, relativePath string) (bool, []string, error) {
+	base, rank, ok := s.preference.match(filepath.Base(path))
+	if !ok {
+		return true, nil, nil
+	}
+
+	dir := filepath.Dir(path)
+	entries, err := s.listings.list(dir)
+	if err != nil {
+		return false, nil, err
+	}
+
+	var variants []string
+	for _, entry := range entries {
+		siblingBase, siblingRank, ok := s.preference.match(entry.Name)
+		if !ok || siblingBase != base || entry.Name == filepath.Base(path) {
+			continue
+		}
+
+		siblingPath := filepath.Join(dir, entry.Name)
+		if !s.isEligible(entry, filepath.ToSlash(filepath.Join(filepath.Dir(relativePath), entry.Name))) {
+			continue
+		}
+		if siblingRank < rank {
+			return false, nil, nil
+		}
+		variants = append(variants, siblingPath)
+	}
+	return true, variants, nil
+}
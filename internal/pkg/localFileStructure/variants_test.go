@@ -0,0 +1,105 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package localFileStructure
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_ParseVariantPreference_should_reject_empty_endings(t *testing.T) {
+	_, err := ParseVariantPreference("-edit.jpg,,.jpg")
+	if err == nil {
+		t.Error("Expected an error for an empty ending")
+	}
+}
+
+func Test_scan_should_only_send_the_preferred_variant(t *testing.T) {
+	rootPath := createTestTree(t, "shoot/IMG_1.CR2", "shoot/IMG_1.JPG", "shoot/IMG_1-edit.jpg",
+		"shoot/IMG_2.CR2", "shoot/IMG_2.JPG", "shoot/IMG_3.CR2", "shoot/other.jpg")
+	defer os.RemoveAll(rootPath)
+
+	preference, err := ParseVariantPreference("-edit.jpg,.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := ScanRoot{Path: rootPath, Extensions: []string{"jpg"}, Variants: preference}
+
+	nodes, err := scanLocalFileStructures([]ScanRoot{root}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string][]string{
+		"shoot/IMG_1-edit.jpg": {filepath.Join(rootPath, "shoot", "IMG_1.JPG")},
+		"shoot/IMG_2.JPG":      nil,
+		"shoot/other.jpg":      nil,
+	}
+	files := 0
+	for _, node := range nodes {
+		if node.IsDir {
+			continue
+		}
+		files++
+
+		variants, ok := expected[node.Key]
+		if !ok {
+			t.Errorf("Unexpected file %s", node.Key)
+			continue
+		}
+		if len(node.Variants) != len(variants) || (len(variants) > 0 && node.Variants[0] != variants[0]) {
+			t.Errorf("Expected the variants %v of %s but got %v", variants, node.Key, node.Variants)
+		}
+	}
+	if files != len(expected) {
+		t.Errorf("Expected %d files but got %d", len(expected), files)
+	}
+}
+
+func Test_scan_should_select_the_variants_from_the_cached_entries(t *testing.T) {
+	rootPath := createTestTree(t, "shoot/IMG_1.jpg")
+	defer os.RemoveAll(rootPath)
+	cache := newTestDirectoryCache()
+	dirTime := setDirectoryTimes(t, rootPath, "", "shoot")
+
+	preference, err := ParseVariantPreference("-edit.jpg,.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := ScanRoot{Path: rootPath, Cache: cache, Variants: preference}
+
+	scanCachedTestTree(t, root)
+	// the unchanged directory is served from the cache, so the new variant is not seen yet
+	addFileKeepingDirectoryTime(t, rootPath, "shoot/IMG_1-edit.jpg", dirTime)
+
+	nodes := scanCachedTestTree(t, root)
+
+	if _, exists := nodes["shoot/IMG_1.jpg"]; !exists {
+		t.Errorf("Expected the variant to be selected from the cached entries but got %v", nodes)
+	}
+}
+
+func Test_scan_should_ignore_excluded_variants(t *testing.T) {
+	rootPath := createTestTree(t, "shoot/IMG_1.JPG", "shoot/IMG_1-edit.jpg")
+	defer os.RemoveAll(rootPath)
+	writeDirectoryFile(t, rootPath, ignoreFileName, "shoot", "*-edit.jpg")
+
+	preference, err := ParseVariantPreference("-edit.jpg,.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := ScanRoot{Path: rootPath, Extensions: []string{"jpg"}, Variants: preference}
+
+	nodes, err := scanLocalFileStructures([]ScanRoot{root}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := nodes[filepath.Join(rootPath, "shoot", "IMG_1.JPG")]; !ok {
+		t.Error("Expected the camera variant to be sent as the preferred one is ignored")
+	}
+}
@@ -0,0 +1,220 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package metadata
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+)
+
+var (
+	exifSignature      = []byte("Exif\x00\x00")
+	xmpSignature       = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	photoshopSignature = []byte("Photoshop 3.0\x00")
+	pngSignature       = []byte("\x89PNG\r\n\x1a\n")
+)
+
+const (
+	pngXmpKeyword     = "XML:com.adobe.xmp"
+	photoshopIptcId   = 0x0404
+	maxMetadataLength = 16 * 1024 * 1024
+)
+
+// The raw metadata blocks embedded in an image. Missing blocks are nil.
+type metadataBlocks struct {
+	// exif starts with the tiff header
+	exif []byte
+	xmp  []byte
+	iptc []byte
+}
+
+// Reads the metadata blocks in front of the image data. Unknown formats and broken files are no error, the
+// blocks found so far are returned.
+func readMetadataBlocks(reader *bufio.Reader) metadataBlocks {
+	signature, err := reader.Peek(8)
+	if err != nil {
+		return metadataBlocks{}
+	}
+
+	if bytes.HasPrefix(signature, []byte{0xFF, 0xD8}) {
+		return readJpegMetadataBlocks(reader)
+	}
+	if bytes.Equal(signature, pngSignature) {
+		return readPngMetadataBlocks(reader)
+	}
+	return metadataBlocks{}
+}
+
+func readJpegMetadataBlocks(reader *bufio.Reader) metadataBlocks {
+	blocks := metadataBlocks{}
+	if _, err := reader.Discard(2); err != nil {
+		return blocks
+	}
+
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(reader, header[:]); err != nil {
+			return blocks
+		}
+		if header[0] != 0xFF {
+			return blocks
+		}
+
+		marker := header[1]
+		// start of scan or end of image: the metadata segments are always in front of the image data
+		if marker == 0xDA || marker == 0xD9 {
+			return blocks
+		}
+
+		length := int(binary.BigEndian.Uint16(header[2:])) - 2
+		if length < 0 {
+			return blocks
+		}
+
+		if marker != 0xE1 && marker != 0xED {
+			if _, err := reader.Discard(length); err != nil {
+				return blocks
+			}
+			continue
+		}
+
+		segment := make([]byte, length)
+		if _, err := io.ReadFull(reader, segment); err != nil {
+			return blocks
+		}
+
+		switch {
+		case bytes.HasPrefix(segment, exifSignature) && blocks.exif == nil:
+			blocks.exif = segment[len(exifSignature):]
+		case bytes.HasPrefix(segment, xmpSignature) && blocks.xmp == nil:
+			blocks.xmp = segment[len(xmpSignature):]
+		case bytes.HasPrefix(segment, photoshopSignature) && blocks.iptc == nil:
+			blocks.iptc = readPhotoshopIptc(segment[len(photoshopSignature):])
+		}
+	}
+}
+
+func readPngMetadataBlocks(reader *bufio.Reader) metadataBlocks {
+	blocks := metadataBlocks{}
+	if _, err := reader.Discard(8); err != nil {
+		return blocks
+	}
+
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(reader, header[:]); err != nil {
+			return blocks
+		}
+
+		length := int(binary.BigEndian.Uint32(header[:4]))
+		chunkType := string(header[4:])
+		if chunkType == "IDAT" || chunkType == "IEND" || length > maxMetadataLength {
+			return blocks
+		}
+
+		if chunkType != "eXIf" && chunkType != "iTXt" {
+			// skip the data and the crc
+			if _, err := reader.Discard(length + 4); err != nil {
+				return blocks
+			}
+			continue
+		}
+
+		// the crc is read with the data
+		chunk := make([]byte, length+4)
+		if _, err := io.ReadFull(reader, chunk); err != nil {
+			return blocks
+		}
+		chunk = chunk[:length]
+
+		if chunkType == "eXIf" {
+			blocks.exif = chunk
+		} else if xmp, ok := readPngXmp(chunk); ok {
+			blocks.xmp = xmp
+		}
+	}
+}
+
+// An iTXt chunk contains the keyword, the compression flag and method, the language and the translated keyword
+// separated by zeros, followed by the text.
+func readPngXmp(chunk []byte) ([]byte, bool) {
+	if !bytes.HasPrefix(chunk, []byte(pngXmpKeyword+"\x00")) {
+		return nil, false
+	}
+
+	data := chunk[len(pngXmpKeyword)+1:]
+	if len(data) < 2 {
+		return nil, false
+	}
+	compressed := data[0] == 1
+	data = data[2:]
+
+	// skip the language and the translated keyword
+	for i := 0; i < 2; i++ {
+		end := bytes.IndexByte(data, 0)
+		if end < 0 {
+			return nil, false
+		}
+		data = data[end+1:]
+	}
+
+	if !compressed {
+		return data, true
+	}
+
+	decompressor, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, false
+	}
+	defer decompressor.Close()
+
+	xmp, err := ioutil.ReadAll(io.LimitReader(decompressor, maxMetadataLength))
+	if err != nil {
+		return nil, false
+	}
+	return xmp, true
+}
+
+// Photoshop stores the iptc data as one of its image resources. Every resource starts with 8BIM, its id and a
+// pascal string padded to an even length, followed by the size and the data, which is padded to an even length too.
+func readPhotoshopIptc(resources []byte) []byte {
+	for len(resources) >= 12 && bytes.HasPrefix(resources, []byte("8BIM")) {
+		id := binary.BigEndian.Uint16(resources[4:])
+
+		nameLength := int(resources[6]) + 1
+		if nameLength%2 == 1 {
+			nameLength++
+		}
+
+		start := 6 + nameLength
+		if start+4 > len(resources) {
+			return nil
+		}
+		size := int(binary.BigEndian.Uint32(resources[start:]))
+		start += 4
+		if size < 0 || start+size > len(resources) {
+			return nil
+		}
+
+		if id == photoshopIptcId {
+			return resources[start : start+size]
+		}
+
+		next := start + size
+		if size%2 == 1 {
+			next++
+		}
+		if next > len(resources) {
+			return nil
+		}
+		resources = resources[next:]
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package metadata
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"strings"
+	"time"
+)
+
+var ErrorNoCaptureDate = errors.New("no capture date found")
+
+const exifDateFormat = "2006:01:02 15:04:05"
+
+const (
+	exifTagImageDescription  = 0x010E
+	exifTagDateTime          = 0x0132
+	exifTagArtist            = 0x013B
+	exifTagRating            = 0x4746
+	exifTagExifIfdPointer    = 0x8769
+	exifTagGpsIfdPointer     = 0x8825
+	exifTagDateTimeOriginal  = 0x9003
+	exifTagDateTimeDigitized = 0x9004
+
+	gpsTagLatitudeRef  = 0x0001
+	gpsTagLatitude     = 0x0002
+	gpsTagLongitudeRef = 0x0003
+	gpsTagLongitude    = 0x0004
+)
+
+const (
+	exifTypeAscii    = 2
+	exifTypeShort    = 3
+	exifTypeRational = 5
+)
+
+// ReadCaptureDate reads the date the image was taken from the exif data of jpeg and png files.
+// As exif does not contain a time zone, the local time zone is used.
+func ReadCaptureDate(filePath string) (time.Time, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer file.Close()
+
+	blocks := readMetadataBlocks(bufio.NewReader(file))
+	return parseExifCaptureDate(blocks.exif)
+}
+
+// Reads the fields of the exif data that are part of the metadata. Broken data is skipped.
+func parseExif(exif []byte) Metadata {
+	metadata := Metadata{}
+	byteOrder, ok := exifByteOrder(exif)
+	if !ok {
+		return metadata
+	}
+
+	ifd0 := readIfd(exif, byteOrder, byteOrder.Uint32(exif[4:]))
+	metadata.Description = readExifString(exif, byteOrder, ifd0[exifTagImageDescription])
+	metadata.Author = readExifString(exif, byteOrder, ifd0[exifTagArtist])
+	if rating, ok := ifd0[exifTagRating]; ok && rating.fieldType == exifTypeShort {
+		metadata.Rating = validRating(int(byteOrder.Uint16(rating.value)))
+	}
+	metadata.CaptureDate, _ = parseExifCaptureDate(exif)
+
+	if pointer, ok := ifd0[exifTagGpsIfdPointer]; ok {
+		gpsIfd := readIfd(exif, byteOrder, byteOrder.Uint32(pointer.value))
+		latitude, latitudeOk := readExifCoordinate(exif, byteOrder, gpsIfd[gpsTagLatitude], gpsIfd[gpsTagLatitudeRef], "S")
+		longitude, longitudeOk := readExifCoordinate(exif, byteOrder, gpsIfd[gpsTagLongitude], gpsIfd[gpsTagLongitudeRef], "W")
+		if latitudeOk && longitudeOk {
+			metadata.HasLocation = true
+			metadata.Latitude = latitude
+			metadata.Longitude = longitude
+		}
+	}
+	return metadata
+}
+
+func exifByteOrder(exif []byte) (binary.ByteOrder, bool) {
+	if len(exif) < 8 {
+		return nil, false
+	}
+
+	switch string(exif[:2]) {
+	case "II":
+		return binary.LittleEndian, true
+	case "MM":
+		return binary.BigEndian, true
+	}
+	return nil, false
+}
+
+func parseExifCaptureDate(exif []byte) (time.Time, error) {
+	byteOrder, ok := exifByteOrder(exif)
+	if !ok {
+		return time.Time{}, ErrorNoCaptureDate
+	}
+
+	ifd0 := readIfd(exif, byteOrder, byteOrder.Uint32(exif[4:]))
+
+	if pointer, ok := ifd0[exifTagExifIfdPointer]; ok {
+		exifIfd := readIfd(exif, byteOrder, byteOrder.Uint32(pointer.value))
+		for _, tag := range []uint16{exifTagDateTimeOriginal, exifTagDateTimeDigitized} {
+			if date, err := parseExifDate(exif, byteOrder, exifIfd[tag]); err == nil {
+				return date, nil
+			}
+		}
+	}
+
+	return parseExifDate(exif, byteOrder, ifd0[exifTagDateTime])
+}
+
+type ifdEntry struct {
+	fieldType uint16
+	count     uint32
+	// contains the value itself if it fits into four bytes, otherwise the offset to the value
+	value []byte
+}
+
+func readIfd(exif []byte, byteOrder binary.ByteOrder, offset uint32) map[uint16]ifdEntry {
+	entries := make(map[uint16]ifdEntry)
+	if int(offset)+2 > len(exif) {
+		return entries
+	}
+
+	count := int(byteOrder.Uint16(exif[offset:]))
+	for i := 0; i < count; i++ {
+		start := int(offset) + 2 + i*12
+		if start+12 > len(exif) {
+			break
+		}
+		entry := exif[start : start+12]
+		entries[byteOrder.Uint16(entry)] = ifdEntry{
+			fieldType: byteOrder.Uint16(entry[2:]),
+			count:     byteOrder.Uint32(entry[4:]),
+			value:     entry[8:12],
+		}
+	}
+	return entries
+}
+
+func parseExifDate(exif []byte, byteOrder binary.ByteOrder, entry ifdEntry) (time.Time, error) {
+	// dates are stored as ascii strings with 20 bytes including the terminating zero
+	if entry.fieldType != exifTypeAscii || entry.count < 19 {
+		return time.Time{}, ErrorNoCaptureDate
+	}
+
+	offset := int(byteOrder.Uint32(entry.value))
+	if offset+19 > len(exif) {
+		return time.Time{}, ErrorNoCaptureDate
+	}
+
+	value := strings.TrimSpace(string(exif[offset : offset+19]))
+	date, err := time.ParseInLocation(exifDateFormat, value, time.Local)
+	if err != nil {
+		return time.Time{}, ErrorNoCaptureDate
+	}
+	return date, nil
+}
+
+func readExifString(exif []byte, byteOrder binary.ByteOrder, entry ifdEntry) string {
+	if entry.fieldType != exifTypeAscii || entry.count == 0 {
+		return ""
+	}
+
+	value := entry.value
+	if entry.count > 4 {
+		offset := int(byteOrder.Uint32(entry.value))
+		if offset < 0 || offset+int(entry.count) > len(exif) {
+			return ""
+		}
+		value = exif[offset : offset+int(entry.count)]
+	} else {
+		value = value[:entry.count]
+	}
+
+	if end := bytes.IndexByte(value, 0); end >= 0 {
+		value = value[:end]
+	}
+	return strings.TrimSpace(string(value))
+}
+
+// Coordinates are stored as degrees, minutes and seconds with the hemisphere in a separate tag.
+func readExifCoordinate(exif []byte, byteOrder binary.ByteOrder, entry ifdEntry, ref ifdEntry, negativeRef string) (float64, bool) {
+	if entry.fieldType != exifTypeRational || entry.count != 3 {
+		return 0, false
+	}
+
+	offset := int(byteOrder.Uint32(entry.value))
+	if offset < 0 || offset+24 > len(exif) {
+		return 0, false
+	}
+
+	coordinate := 0.0
+	for i, divisor := range []float64{1, 60, 3600} {
+		numerator := byteOrder.Uint32(exif[offset+i*8:])
+		denominator := byteOrder.Uint32(exif[offset+i*8+4:])
+		if denominator == 0 {
+			return 0, false
+		}
+		coordinate += float64(numerator) / float64(denominator) / divisor
+	}
+
+	if readExifString(exif, byteOrder, ref) == negativeRef {
+		coordinate = -coordinate
+	}
+	return coordinate, true
+}
@@ -0,0 +1,115 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestReadCaptureDateFromJpeg(t *testing.T) {
+	file := writeTestFile(t, createJpegWithCaptureDate("2019:05:03 12:34:56"))
+	defer os.Remove(file)
+
+	date, err := ReadCaptureDate(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := time.Date(2019, 5, 3, 12, 34, 56, 0, time.Local)
+	if !date.Equal(expected) {
+		t.Errorf("Got capture date %s but expected %s", date, expected)
+	}
+}
+
+func TestReadCaptureDateWithoutExif(t *testing.T) {
+	file := writeTestFile(t, []byte{0xFF, 0xD8, 0xFF, 0xD9, 0x00, 0x00, 0x00, 0x00})
+	defer os.Remove(file)
+
+	_, err := ReadCaptureDate(file)
+	if err != ErrorNoCaptureDate {
+		t.Errorf("Expected ErrorNoCaptureDate but got %v", err)
+	}
+}
+
+func Test_parseExif_should_read_the_description_and_location(t *testing.T) {
+	tiff := &bytes.Buffer{}
+	tiff.WriteString("II*\x00")
+	_ = binary.Write(tiff, binary.LittleEndian, uint32(8))
+
+	// ifd0 with the description at offset 38 and the pointer to the gps ifd at offset 46
+	_ = binary.Write(tiff, binary.LittleEndian, uint16(2))
+	_ = binary.Write(tiff, binary.LittleEndian, []uint16{exifTagImageDescription, exifTypeAscii})
+	_ = binary.Write(tiff, binary.LittleEndian, []uint32{8, 38})
+	_ = binary.Write(tiff, binary.LittleEndian, []uint16{exifTagGpsIfdPointer, 4})
+	_ = binary.Write(tiff, binary.LittleEndian, []uint32{1, 46, 0})
+	tiff.WriteString("Sunset!\x00")
+
+	// gps ifd with the coordinates at offset 100 and 124
+	_ = binary.Write(tiff, binary.LittleEndian, uint16(4))
+	_ = binary.Write(tiff, binary.LittleEndian, []uint16{gpsTagLatitudeRef, exifTypeAscii})
+	_ = binary.Write(tiff, binary.LittleEndian, []uint32{2})
+	tiff.WriteString("N\x00\x00\x00")
+	_ = binary.Write(tiff, binary.LittleEndian, []uint16{gpsTagLatitude, exifTypeRational})
+	_ = binary.Write(tiff, binary.LittleEndian, []uint32{3, 100})
+	_ = binary.Write(tiff, binary.LittleEndian, []uint16{gpsTagLongitudeRef, exifTypeAscii})
+	_ = binary.Write(tiff, binary.LittleEndian, []uint32{2})
+	tiff.WriteString("W\x00\x00\x00")
+	_ = binary.Write(tiff, binary.LittleEndian, []uint16{gpsTagLongitude, exifTypeRational})
+	_ = binary.Write(tiff, binary.LittleEndian, []uint32{3, 124, 0})
+	_ = binary.Write(tiff, binary.LittleEndian, []uint32{47, 1, 225, 10, 0, 1})
+	_ = binary.Write(tiff, binary.LittleEndian, []uint32{8, 1, 30, 1, 36, 1})
+
+	metadata := parseExif(tiff.Bytes())
+
+	if metadata.Description != "Sunset!" {
+		t.Errorf("Expected the description 'Sunset!' but got '%s'", metadata.Description)
+	}
+	if !metadata.HasLocation || metadata.Latitude != 47.375 || metadata.Longitude != -8.51 {
+		t.Errorf("Expected the location 47.375,-8.51 but got %t %f,%f", metadata.HasLocation, metadata.Latitude, metadata.Longitude)
+	}
+}
+
+// Creates a minimal jpeg containing an exif block with the DateTimeOriginal tag.
+func createJpegWithCaptureDate(date string) []byte {
+	tiff := &bytes.Buffer{}
+	tiff.WriteString("II*\x00")
+	_ = binary.Write(tiff, binary.LittleEndian, uint32(8))
+
+	// ifd0 with the pointer to the exif ifd at offset 26
+	_ = binary.Write(tiff, binary.LittleEndian, []uint16{1, exifTagExifIfdPointer, 4})
+	_ = binary.Write(tiff, binary.LittleEndian, []uint32{1, 26, 0})
+
+	// exif ifd with the date at offset 44
+	_ = binary.Write(tiff, binary.LittleEndian, []uint16{1, exifTagDateTimeOriginal, 2})
+	_ = binary.Write(tiff, binary.LittleEndian, []uint32{20, 44, 0})
+	tiff.WriteString(date + "\x00")
+
+	jpeg := &bytes.Buffer{}
+	jpeg.Write([]byte{0xFF, 0xD8, 0xFF, 0xE1})
+	_ = binary.Write(jpeg, binary.BigEndian, uint16(tiff.Len()+8))
+	jpeg.WriteString("Exif\x00\x00")
+	jpeg.Write(tiff.Bytes())
+	jpeg.Write([]byte{0xFF, 0xD9})
+	return jpeg.Bytes()
+}
+
+func writeTestFile(t *testing.T, content []byte) string {
+	file, err := ioutil.TempFile("", "piwigouploader*.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if _, err = file.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	return file.Name()
+}
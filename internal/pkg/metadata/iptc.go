@@ -0,0 +1,89 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package metadata
+
+import (
+	"encoding/binary"
+	"strings"
+	"time"
+)
+
+// The datasets of the application record used for the metadata.
+const (
+	iptcRecordApplication = 2
+
+	iptcObjectName  = 5
+	iptcKeywords    = 25
+	iptcDateCreated = 55
+	iptcTimeCreated = 60
+	iptcByline      = 80
+	iptcCaption     = 120
+)
+
+// Reads the fields of the iptc data that are part of the metadata. The values are expected to be utf-8, which is
+// what current software writes. Broken data is skipped.
+func parseIptc(iptc []byte) Metadata {
+	metadata := Metadata{}
+	var date, timeOfDay string
+
+	for len(iptc) >= 5 && iptc[0] == 0x1C {
+		record := iptc[1]
+		dataset := iptc[2]
+		size := int(binary.BigEndian.Uint16(iptc[3:]))
+		// extended sizes are only used for large binary datasets we are not interested in
+		if size&0x8000 != 0 || 5+size > len(iptc) {
+			break
+		}
+
+		value := strings.TrimSpace(string(iptc[5 : 5+size]))
+		iptc = iptc[5+size:]
+		if record != iptcRecordApplication || value == "" {
+			continue
+		}
+
+		switch dataset {
+		case iptcObjectName:
+			metadata.Title = value
+		case iptcCaption:
+			metadata.Description = value
+		case iptcByline:
+			if metadata.Author == "" {
+				metadata.Author = value
+			}
+		case iptcKeywords:
+			metadata.Keywords = append(metadata.Keywords, value)
+		case iptcDateCreated:
+			date = value
+		case iptcTimeCreated:
+			timeOfDay = value
+		}
+	}
+
+	metadata.CaptureDate = parseIptcDate(date, timeOfDay)
+	return metadata
+}
+
+// The date is stored as CCYYMMDD and the time as HHMMSS with an optional offset like +0100.
+func parseIptcDate(date string, timeOfDay string) time.Time {
+	if date == "" {
+		return time.Time{}
+	}
+
+	if timeOfDay != "" {
+		if captured, err := time.Parse("20060102150405-0700", date+timeOfDay); err == nil {
+			return captured
+		}
+		if captured, err := time.ParseInLocation("20060102150405", date+timeOfDay, time.Local); err == nil {
+			return captured
+		}
+	}
+
+	captured, err := time.ParseInLocation("20060102", date, time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+	return captured
+}
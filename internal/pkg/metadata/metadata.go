@@ -0,0 +1,131 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package metadata
+
+import (
+	"bufio"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const sidecarExtension = ".xmp"
+
+// Metadata describes an image as the photographer tagged it. Empty fields were not found in any source.
+type Metadata struct {
+	Title       string
+	Description string
+	Author      string
+	Keywords    []string
+	// Rating from one to five stars, zero if the image is not rated.
+	Rating      int
+	CaptureDate time.Time
+	HasLocation bool
+	Latitude    float64
+	Longitude   float64
+}
+
+// Read collects the embedded exif, iptc and xmp metadata of jpeg and png files and the xmp sidecar if the path is
+// not empty. Later sources override the fields of the earlier ones, so the order of precedence is the sidecar, the
+// embedded xmp, iptc and at last exif. Broken metadata is skipped, only errors reading the files are returned.
+func Read(filePath string, sidecarPath string) (Metadata, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return Metadata{}, err
+	}
+	defer file.Close()
+
+	blocks := readMetadataBlocks(bufio.NewReader(file))
+
+	metadata := parseExif(blocks.exif)
+	metadata.override(parseIptc(blocks.iptc))
+	if blocks.xmp != nil {
+		xmp, err := parseXmp(blocks.xmp)
+		if err != nil {
+			logrus.Debugf("Skipping the embedded xmp of %s - %s", filePath, err)
+		}
+		metadata.override(xmp)
+	}
+
+	if sidecarPath == "" {
+		return metadata, nil
+	}
+
+	sidecar, err := readSidecar(sidecarPath)
+	if err != nil {
+		return metadata, err
+	}
+	metadata.override(sidecar)
+	return metadata, nil
+}
+
+// SidecarNames returns the lower case names a sidecar of the file may have, the most specific first. Some
+// applications append the extension to the whole file name, others replace the extension of the image.
+func SidecarNames(fileName string) []string {
+	fileName = strings.ToLower(fileName)
+	return []string{
+		fileName + sidecarExtension,
+		strings.TrimSuffix(fileName, filepath.Ext(fileName)) + sidecarExtension,
+	}
+}
+
+func readSidecar(sidecarPath string) (Metadata, error) {
+	file, err := os.Open(sidecarPath)
+	if err != nil {
+		return Metadata{}, err
+	}
+	defer file.Close()
+
+	xmp, err := ioutil.ReadAll(io.LimitReader(file, maxMetadataLength))
+	if err != nil {
+		return Metadata{}, err
+	}
+
+	metadata, err := parseXmp(xmp)
+	if err != nil {
+		logrus.Warnf("Skipping the broken sidecar %s - %s", sidecarPath, err)
+		return Metadata{}, nil
+	}
+	return metadata, nil
+}
+
+func (m *Metadata) override(other Metadata) {
+	if other.Title != "" {
+		m.Title = other.Title
+	}
+	if other.Description != "" {
+		m.Description = other.Description
+	}
+	if other.Author != "" {
+		m.Author = other.Author
+	}
+	if len(other.Keywords) > 0 {
+		m.Keywords = other.Keywords
+	}
+	if other.Rating > 0 {
+		m.Rating = other.Rating
+	}
+	if !other.CaptureDate.IsZero() {
+		m.CaptureDate = other.CaptureDate
+	}
+	if other.HasLocation {
+		m.HasLocation = true
+		m.Latitude = other.Latitude
+		m.Longitude = other.Longitude
+	}
+}
+
+// Ratings outside of one to five, like the -1 some applications use for rejected images, count as not rated.
+func validRating(rating int) int {
+	if rating < 1 || rating > 5 {
+		return 0
+	}
+	return rating
+}
@@ -0,0 +1,159 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testXmp = `<?xpacket begin="" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    xmlns:exif="http://ns.adobe.com/exif/1.0/" xmp:Rating="4" exif:DateTimeOriginal="2019-05-03T12:34:56"
+    exif:GPSLatitude="47,22.5N" exif:GPSLongitude="8,30,36W">
+   <dc:title><rdf:Alt><rdf:li xml:lang="x-default">Sunset</rdf:li></rdf:Alt></dc:title>
+   <dc:subject><rdf:Bag><rdf:li>lake</rdf:li><rdf:li>sunset</rdf:li></rdf:Bag></dc:subject>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`
+
+func Test_parseXmp_should_read_attributes_and_elements(t *testing.T) {
+	metadata, err := parseXmp([]byte(testXmp))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Metadata{
+		Title:       "Sunset",
+		Keywords:    []string{"lake", "sunset"},
+		Rating:      4,
+		CaptureDate: time.Date(2019, 5, 3, 12, 34, 56, 0, time.Local),
+		HasLocation: true,
+		Latitude:    47.375,
+		Longitude:   -8.51,
+	}
+	if !reflect.DeepEqual(metadata, expected) {
+		t.Errorf("Expected %+v but got %+v", expected, metadata)
+	}
+}
+
+func Test_Read_should_prefer_xmp_over_iptc_and_exif(t *testing.T) {
+	xmp := strings.Replace(testXmp, "<dc:title><rdf:Alt><rdf:li xml:lang=\"x-default\">Sunset</rdf:li></rdf:Alt></dc:title>", "", 1)
+	file := writeTestFile(t, createJpegWithMetadata(xmp))
+	defer os.Remove(file)
+
+	metadata, err := Read(file, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if metadata.Title != "Iptc title" {
+		t.Errorf("Expected the title of the iptc data but got '%s'", metadata.Title)
+	}
+	if metadata.Description != "Iptc caption" {
+		t.Errorf("Expected the caption of the iptc data but got '%s'", metadata.Description)
+	}
+	if !reflect.DeepEqual(metadata.Keywords, []string{"lake", "sunset"}) {
+		t.Errorf("Expected the keywords of the xmp but got %v", metadata.Keywords)
+	}
+	if !metadata.CaptureDate.Equal(time.Date(2019, 5, 3, 12, 34, 56, 0, time.Local)) {
+		t.Errorf("Expected the capture date of the exif data but got %s", metadata.CaptureDate)
+	}
+}
+
+func Test_Read_should_prefer_the_sidecar(t *testing.T) {
+	file := writeTestFile(t, createJpegWithMetadata(testXmp))
+	defer os.Remove(file)
+	sidecar := writeTestFile(t, []byte(strings.Replace(testXmp, "Sunset", "Sidecar title", 1)))
+	defer os.Remove(sidecar)
+
+	metadata, err := Read(file, sidecar)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if metadata.Title != "Sidecar title" {
+		t.Errorf("Expected the title of the sidecar but got '%s'", metadata.Title)
+	}
+}
+
+func Test_Read_should_find_the_xmp_of_png_files(t *testing.T) {
+	png := &bytes.Buffer{}
+	png.Write(pngSignature)
+	writePngChunk(png, "IHDR", make([]byte, 13))
+	writePngChunk(png, "iTXt", []byte(pngXmpKeyword+"\x00\x00\x00\x00\x00"+testXmp))
+	writePngChunk(png, "IEND", nil)
+
+	file := writeTestFile(t, png.Bytes())
+	defer os.Remove(file)
+
+	metadata, err := Read(file, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.Title != "Sunset" {
+		t.Errorf("Expected the title of the embedded xmp but got '%s'", metadata.Title)
+	}
+}
+
+func Test_SidecarNames_should_return_both_naming_schemes(t *testing.T) {
+	names := SidecarNames("IMG_1.JPG")
+	expected := []string{"img_1.jpg.xmp", "img_1.xmp"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected %v but got %v", expected, names)
+	}
+}
+
+// Creates a jpeg with the capture date as exif, a title and caption as iptc and the given xmp.
+func createJpegWithMetadata(xmp string) []byte {
+	jpeg := bytes.NewBuffer(createJpegWithCaptureDate("2019:05:03 12:34:56"))
+	jpeg.Truncate(jpeg.Len() - 2)
+
+	iptc := &bytes.Buffer{}
+	writeIptcDataset(iptc, iptcObjectName, "Iptc title")
+	writeIptcDataset(iptc, iptcCaption, "Iptc caption")
+	writeIptcDataset(iptc, iptcKeywords, "iptc keyword")
+
+	resources := &bytes.Buffer{}
+	resources.WriteString("8BIM")
+	_ = binary.Write(resources, binary.BigEndian, uint16(photoshopIptcId))
+	resources.Write([]byte{0, 0})
+	_ = binary.Write(resources, binary.BigEndian, uint32(iptc.Len()))
+	resources.Write(iptc.Bytes())
+
+	writeJpegSegment(jpeg, 0xED, append(append([]byte{}, photoshopSignature...), resources.Bytes()...))
+	writeJpegSegment(jpeg, 0xE1, append(append([]byte{}, xmpSignature...), xmp...))
+	jpeg.Write([]byte{0xFF, 0xD9})
+	return jpeg.Bytes()
+}
+
+func writeIptcDataset(buffer *bytes.Buffer, dataset byte, value string) {
+	buffer.Write([]byte{0x1C, iptcRecordApplication, dataset})
+	_ = binary.Write(buffer, binary.BigEndian, uint16(len(value)))
+	buffer.WriteString(value)
+}
+
+func writeJpegSegment(buffer *bytes.Buffer, marker byte, data []byte) {
+	buffer.Write([]byte{0xFF, marker})
+	_ = binary.Write(buffer, binary.BigEndian, uint16(len(data)+2))
+	buffer.Write(data)
+}
+
+func writePngChunk(buffer *bytes.Buffer, chunkType string, data []byte) {
+	_ = binary.Write(buffer, binary.BigEndian, uint32(len(data)))
+	buffer.WriteString(chunkType)
+	buffer.Write(data)
+	_ = binary.Write(buffer, binary.BigEndian, crc32.ChecksumIEEE(append([]byte(chunkType), data...)))
+}
@@ -0,0 +1,188 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package metadata
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	rdfNamespace       = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	dcNamespace        = "http://purl.org/dc/elements/1.1/"
+	xmpNamespace       = "http://ns.adobe.com/xap/1.0/"
+	exifNamespace      = "http://ns.adobe.com/exif/1.0/"
+	photoshopNamespace = "http://ns.adobe.com/photoshop/1.0/"
+)
+
+var xmpDateFormats = []string{
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// The values of the xmp properties by their namespace and name. Arrays like the keywords have more than one value,
+// alternatives like the languages of a title are ordered as they are in the document.
+type xmpProperties map[xml.Name][]string
+
+// Reads the fields of the xmp packet that are part of the metadata. Properties can be written as attributes of the
+// rdf:Description or as elements containing the value or an rdf:Bag, rdf:Seq or rdf:Alt of values.
+func parseXmp(xmp []byte) (Metadata, error) {
+	properties, err := readXmpProperties(xmp)
+	if err != nil {
+		return Metadata{}, err
+	}
+
+	metadata := Metadata{
+		Title:       properties.first(dcNamespace, "title"),
+		Description: properties.first(dcNamespace, "description"),
+		Author:      properties.first(dcNamespace, "creator"),
+		Keywords:    properties[xml.Name{Space: dcNamespace, Local: "subject"}],
+	}
+
+	if rating, err := strconv.ParseFloat(properties.first(xmpNamespace, "Rating"), 64); err == nil {
+		metadata.Rating = validRating(int(math.Round(rating)))
+	}
+
+	for _, date := range []string{
+		properties.first(exifNamespace, "DateTimeOriginal"),
+		properties.first(photoshopNamespace, "DateCreated"),
+		properties.first(xmpNamespace, "CreateDate"),
+	} {
+		if captured, ok := parseXmpDate(date); ok {
+			metadata.CaptureDate = captured
+			break
+		}
+	}
+
+	latitude, latitudeOk := parseXmpCoordinate(properties.first(exifNamespace, "GPSLatitude"))
+	longitude, longitudeOk := parseXmpCoordinate(properties.first(exifNamespace, "GPSLongitude"))
+	if latitudeOk && longitudeOk {
+		metadata.HasLocation = true
+		metadata.Latitude = latitude
+		metadata.Longitude = longitude
+	}
+	return metadata, nil
+}
+
+func readXmpProperties(xmp []byte) (xmpProperties, error) {
+	properties := make(xmpProperties)
+	decoder := xml.NewDecoder(bytes.NewReader(xmp))
+
+	var path []xml.Name
+	text := strings.Builder{}
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return properties, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch element := token.(type) {
+		case xml.StartElement:
+			if element.Name == (xml.Name{Space: rdfNamespace, Local: "Description"}) {
+				for _, attribute := range element.Attr {
+					if attribute.Name.Space != rdfNamespace && attribute.Name.Space != "xmlns" && attribute.Name.Space != "" {
+						properties.add(attribute.Name, attribute.Value)
+					}
+				}
+			}
+			path = append(path, element.Name)
+			text.Reset()
+		case xml.CharData:
+			text.Write(element)
+		case xml.EndElement:
+			// only the text of the innermost elements is a value, the whitespace between elements is dropped
+			if value := strings.TrimSpace(text.String()); value != "" {
+				if property, ok := xmpProperty(path); ok {
+					properties.add(property, value)
+				}
+			}
+			text.Reset()
+			if len(path) > 0 {
+				path = path[:len(path)-1]
+			}
+		}
+	}
+}
+
+// The property of an element is the child of the innermost rdf:Description containing it.
+func xmpProperty(path []xml.Name) (xml.Name, bool) {
+	for i := len(path) - 1; i >= 0; i-- {
+		if path[i] == (xml.Name{Space: rdfNamespace, Local: "Description"}) {
+			if i+1 < len(path) {
+				return path[i+1], true
+			}
+			return xml.Name{}, false
+		}
+	}
+	return xml.Name{}, false
+}
+
+func (p xmpProperties) add(name xml.Name, value string) {
+	p[name] = append(p[name], value)
+}
+
+func (p xmpProperties) first(space string, local string) string {
+	values := p[xml.Name{Space: space, Local: local}]
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// Dates without a time zone are in local time like the exif dates.
+func parseXmpDate(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+
+	for _, format := range xmpDateFormats {
+		if date, err := time.ParseInLocation(format, value, time.Local); err == nil {
+			return date, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Coordinates are written as "DDD,MM,SSk" or "DDD,MM.mmk" where k is the direction N, S, E or W.
+func parseXmpCoordinate(value string) (float64, bool) {
+	if len(value) < 2 {
+		return 0, false
+	}
+
+	direction := strings.ToUpper(value[len(value)-1:])
+	parts := strings.Split(value[:len(value)-1], ",")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, false
+	}
+
+	coordinate := 0.0
+	for i, part := range parts {
+		number, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return 0, false
+		}
+		coordinate += number / math.Pow(60, float64(i))
+	}
+
+	switch direction {
+	case "S", "W":
+		return -coordinate, true
+	case "N", "E":
+		return coordinate, true
+	}
+	return 0, false
+}
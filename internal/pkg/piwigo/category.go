@@ -7,8 +7,11 @@ package piwigo
 
 import (
 	"fmt"
+	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/categoryKey"
 	"github.com/sirupsen/logrus"
-	"os"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 type Category struct {
@@ -16,21 +19,186 @@ type Category struct {
 	ParentId int
 	Name     string
 	Key      string
+	// TotalImages is the number of images within the category and all its sub categories.
+	TotalImages int
 }
 
-func buildLookupMap(categories map[int]*Category) map[string]*Category {
+// CategorySettings are sent to piwigo for categories that already exist. Empty values are not sent.
+type CategorySettings struct {
+	Description      string
+	Status           string
+	UserIds          []int
+	GroupIds         []int
+	Rank             int
+	RepresentativeId int
+}
+
+const (
+	// DuplicateCategoriesFail stops the synchronization if two sibling categories share the same name.
+	DuplicateCategoriesFail = "fail"
+	// DuplicateCategoriesLowestId uses the category with the lowest id and ignores the others including their children.
+	DuplicateCategoriesLowestId = "lowestId"
+)
+
+// Builds the lookup of all categories by key. Piwigo allows sibling categories with the same name. As these
+// share the same key, only one of them can be used. The configured bindings decide which category is used for
+// a key. If there is no binding, the policy is applied. Children of ignored categories are ignored as well.
+func buildLookupMap(categories map[int]*Category, policy string, bindings map[string]int) (map[string]*Category, error) {
 	categoryLookups := map[string]*Category{}
+	ignored := map[int]bool{}
+	var unresolved []string
+
+	for _, level := range categoriesByDepth(categories) {
+		candidates := map[string][]*Category{}
+		var keys []string
+		for _, category := range level {
+			if ignored[category.ParentId] {
+				logrus.Debugf("Ignoring category %s (%d) as its parent is ignored", category.Key, category.Id)
+				ignored[category.Id] = true
+				continue
+			}
+			if _, ok := candidates[category.Key]; !ok {
+				keys = append(keys, category.Key)
+			}
+			candidates[category.Key] = append(candidates[category.Key], category)
+		}
+
+		for _, key := range keys {
+			category, err := selectCategory(key, candidates[key], policy, bindings)
+			if err != nil {
+				unresolved = append(unresolved, err.Error())
+				continue
+			}
+
+			for _, candidate := range candidates[key] {
+				if candidate != category {
+					ignored[candidate.Id] = true
+				}
+			}
+
+			logrus.Debugf("Loaded existing category %s", category.Key)
+			categoryLookups[category.Key] = category
+		}
+	}
+
+	if len(unresolved) > 0 {
+		return nil, fmt.Errorf("found categories sharing the same name with the same parent. Bind the category to use or change the policy for duplicated categories: %s", strings.Join(unresolved, "; "))
+	}
+
+	return categoryLookups, nil
+}
+
+func selectCategory(key string, candidates []*Category, policy string, bindings map[string]int) (*Category, error) {
+	boundId, isBound := bindings[key]
+	if isBound {
+		for _, candidate := range candidates {
+			if candidate.Id == boundId {
+				logrus.Debugf("Using category %d for %s as configured", boundId, key)
+				return candidate, nil
+			}
+		}
+		return nil, fmt.Errorf("%s is bound to category %d but found only %s", key, boundId, categoryIds(candidates))
+	}
+
+	if len(candidates) == 1 {
+		return candidates[0], nil
+	}
+
+	switch policy {
+	case DuplicateCategoriesLowestId:
+		// the candidates are sorted by id
+		logrus.Warnf("Found duplicated categories %s for %s. Using category %d.", categoryIds(candidates), key, candidates[0].Id)
+		return candidates[0], nil
+	default:
+		logrus.Errorf("Found duplicated categories %s for %s", categoryIds(candidates), key)
+		return nil, fmt.Errorf("%s has the categories %s", key, categoryIds(candidates))
+	}
+}
+
+// Groups the categories by their depth in the tree beginning at the root. Each level is sorted by id.
+func categoriesByDepth(categories map[int]*Category) [][]*Category {
+	var levels [][]*Category
 	for _, category := range categories {
-		logrus.Debugf("Loaded existing category %s", category.Key)
-		categoryLookups[category.Key] = category
+		depth := 0
+		for parentId := category.ParentId; parentId != 0; parentId = categories[parentId].ParentId {
+			depth++
+		}
+		for len(levels) <= depth {
+			levels = append(levels, nil)
+		}
+		levels[depth] = append(levels[depth], category)
 	}
-	return categoryLookups
+
+	for _, level := range levels {
+		sort.Slice(level, func(i, j int) bool { return level[i].Id < level[j].Id })
+	}
+	return levels
+}
+
+func categoryIds(categories []*Category) string {
+	ids := make([]string, 0, len(categories))
+	for _, category := range categories {
+		ids = append(ids, strconv.Itoa(category.Id))
+	}
+	return strings.Join(ids, ",")
+}
+
+// Looks up the base category either by its id or by its key. The key has to be unique as there is no policy
+// to decide between categories with the same name.
+func findBaseCategory(categories map[int]*Category, id int, key string) (int, error) {
+	if id > 0 {
+		if _, ok := categories[id]; !ok {
+			return 0, fmt.Errorf("the base category %d does not exist on piwigo", id)
+		}
+		return id, nil
+	}
+
+	var candidates []*Category
+	for _, category := range categories {
+		if category.Key == key {
+			candidates = append(candidates, category)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Id < candidates[j].Id })
+
+	switch len(candidates) {
+	case 0:
+		return 0, fmt.Errorf("the base category %s does not exist on piwigo", key)
+	case 1:
+		return candidates[0].Id, nil
+	default:
+		return 0, fmt.Errorf("found the categories %s for the base category %s. Use the id to select one of them", categoryIds(candidates), key)
+	}
+}
+
+// Returns copies of all categories below the base category. The direct children of the base become root
+// categories and the keys are rebuilt, so they are relative to the base category like the local keys.
+func categoriesBelow(categories map[int]*Category, baseId int) map[int]*Category {
+	subtree := map[int]*Category{}
+	for _, category := range categories {
+		for parentId := category.ParentId; parentId != 0; parentId = categories[parentId].ParentId {
+			if parentId == baseId {
+				copied := *category
+				subtree[category.Id] = &copied
+				break
+			}
+		}
+	}
+
+	for _, category := range subtree {
+		if category.ParentId == baseId {
+			category.ParentId = 0
+		}
+	}
+
+	buildCategoryKeys(subtree)
+	return subtree
 }
 
 func buildCategoryMap(statusResponse *getCategoryListResponse) map[int]*Category {
 	categories := map[int]*Category{}
 	for _, category := range statusResponse.Result.Categories {
-		categories[category.ID] = &Category{Id: category.ID, ParentId: category.IDUppercat, Name: category.Name, Key: category.Name}
+		categories[category.ID] = &Category{Id: category.ID, ParentId: category.IDUppercat, Name: category.Name, Key: categoryKey.Join(category.Name), TotalImages: category.TotalNbImages}
 	}
 	return categories
 }
@@ -38,20 +206,19 @@ func buildCategoryMap(statusResponse *getCategoryListResponse) map[int]*Category
 func buildCategoryKeys(categories map[int]*Category) {
 	for _, category := range categories {
 		if category.ParentId == 0 {
-			category.Key = category.Name
+			category.Key = categoryKey.Join(category.Name)
 			continue
 		}
 
-		key := category.Name
+		names := []string{category.Name}
 		parentId := category.ParentId
 		for parentId != 0 {
 			parent := categories[parentId]
-			// as we build the category as a directory hierarchy,
-			// we have to use the path separator to construct the path key
-			key = fmt.Sprintf("%s%c%s", parent.Name, os.PathSeparator, key)
+			// the names get escaped by the key builder, so a slash inside a name does not create a new level
+			names = append([]string{parent.Name}, names...)
 			parentId = parent.ParentId
 		}
 
-		category.Key = key
+		category.Key = categoryKey.Join(names...)
 	}
 }
@@ -0,0 +1,142 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package piwigo
+
+import "testing"
+
+func Test_buildCategoryKeys_keeps_slashes_within_names(t *testing.T) {
+	categories := map[int]*Category{
+		1: {Id: 1, ParentId: 0, Name: "Concerts"},
+		2: {Id: 2, ParentId: 1, Name: "AC/DC 2019"},
+		3: {Id: 3, ParentId: 0, Name: "AC"},
+	}
+
+	buildCategoryKeys(categories)
+
+	if categories[2].Key != "Concerts/AC%2FDC 2019" {
+		t.Errorf("Got unexpected key %s for a category containing a slash", categories[2].Key)
+	}
+	if categories[3].Key != "AC" {
+		t.Errorf("Got unexpected key %s for a root category", categories[3].Key)
+	}
+}
+
+func Test_buildLookupMap_fails_on_duplicated_siblings(t *testing.T) {
+	categories := createDuplicatedCategories()
+
+	_, err := buildLookupMap(categories, DuplicateCategoriesFail, nil)
+	if err == nil {
+		t.Error("Expected an error for duplicated categories but got none")
+	}
+}
+
+func Test_buildLookupMap_uses_lowest_id_and_ignores_children_of_others(t *testing.T) {
+	categories := createDuplicatedCategories()
+
+	lookup, err := buildLookupMap(categories, DuplicateCategoriesLowestId, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lookup["2019/Event"].Id != 2 {
+		t.Errorf("Expected category 2 for 2019/Event but got %d", lookup["2019/Event"].Id)
+	}
+	if lookup["2019/Event/Day 1"].Id != 5 {
+		t.Errorf("Expected category 5 for 2019/Event/Day 1 but got %d", lookup["2019/Event/Day 1"].Id)
+	}
+}
+
+func Test_buildLookupMap_uses_bound_category(t *testing.T) {
+	categories := createDuplicatedCategories()
+	bindings := map[string]int{"2019/Event": 3}
+
+	lookup, err := buildLookupMap(categories, DuplicateCategoriesFail, bindings)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lookup["2019/Event"].Id != 3 {
+		t.Errorf("Expected category 3 for 2019/Event but got %d", lookup["2019/Event"].Id)
+	}
+	if lookup["2019/Event/Day 1"].Id != 4 {
+		t.Errorf("Expected category 4 for 2019/Event/Day 1 but got %d", lookup["2019/Event/Day 1"].Id)
+	}
+}
+
+func Test_buildLookupMap_fails_if_bound_category_does_not_exist(t *testing.T) {
+	categories := createDuplicatedCategories()
+	bindings := map[string]int{"2019/Event": 99}
+
+	_, err := buildLookupMap(categories, DuplicateCategoriesLowestId, bindings)
+	if err == nil {
+		t.Error("Expected an error for a missing bound category but got none")
+	}
+}
+
+func createDuplicatedCategories() map[int]*Category {
+	categories := map[int]*Category{
+		1: {Id: 1, ParentId: 0, Name: "2019"},
+		2: {Id: 2, ParentId: 1, Name: "Event"},
+		3: {Id: 3, ParentId: 1, Name: "Event"},
+		4: {Id: 4, ParentId: 3, Name: "Day 1"},
+		5: {Id: 5, ParentId: 2, Name: "Day 1"},
+	}
+	buildCategoryKeys(categories)
+	return categories
+}
+
+func Test_findBaseCategory_by_path(t *testing.T) {
+	categories := createBaseCategories()
+	buildCategoryKeys(categories)
+
+	id, err := findBaseCategory(categories, 0, "Imports/NAS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 2 {
+		t.Errorf("Expected base category 2 but got %d", id)
+	}
+}
+
+func Test_findBaseCategory_fails_on_missing_id(t *testing.T) {
+	categories := createBaseCategories()
+	buildCategoryKeys(categories)
+
+	_, err := findBaseCategory(categories, 42, "")
+	if err == nil {
+		t.Error("Expected an error for a missing base category but got none")
+	}
+}
+
+func Test_categoriesBelow_returns_keys_relative_to_the_base(t *testing.T) {
+	categories := createBaseCategories()
+	buildCategoryKeys(categories)
+
+	subtree := categoriesBelow(categories, 2)
+
+	if len(subtree) != 2 {
+		t.Fatalf("Expected 2 categories below the base but got %d", len(subtree))
+	}
+	if subtree[3].Key != "2019" || subtree[3].ParentId != 0 {
+		t.Errorf("Expected root category 2019 but got %s with parent %d", subtree[3].Key, subtree[3].ParentId)
+	}
+	if subtree[4].Key != "2019/Event" || subtree[4].ParentId != 3 {
+		t.Errorf("Expected category 2019/Event with parent 3 but got %s with parent %d", subtree[4].Key, subtree[4].ParentId)
+	}
+	if categories[3].Key != "Imports/NAS/2019" {
+		t.Errorf("The original categories must not be changed but got %s", categories[3].Key)
+	}
+}
+
+func createBaseCategories() map[int]*Category {
+	return map[int]*Category{
+		1: {Id: 1, ParentId: 0, Name: "Imports"},
+		2: {Id: 2, ParentId: 1, Name: "NAS"},
+		3: {Id: 3, ParentId: 2, Name: "2019"},
+		4: {Id: 4, ParentId: 3, Name: "Event"},
+		5: {Id: 5, ParentId: 0, Name: "2019"},
+	}
+}
@@ -0,0 +1,33 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package piwigo
+
+import (
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// The date format piwigo uses to return dates like the creation date of an image.
+const piwigoDateFormat = "2006-01-02 15:04:05"
+
+type Image struct {
+	Id       int
+	Filename string
+	// the capture date as stored by piwigo. It is zero if piwigo does not know the date.
+	DateCreation time.Time
+}
+
+func parsePiwigoDate(value string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+	date, err := time.ParseInLocation(piwigoDateFormat, value, time.Local)
+	if err != nil {
+		logrus.Debugf("Could not parse piwigo date %s - %s", value, err)
+		return time.Time{}
+	}
+	return date
+}
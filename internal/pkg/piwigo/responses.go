@@ -5,6 +5,8 @@
 
 package piwigo
 
+import "encoding/json"
+
 type responseStatuser interface {
 	responseStatus() string
 }
@@ -139,3 +141,86 @@ type deleteResponse struct {
 func (r deleteResponse) responseStatus() string {
 	return r.Status
 }
+
+type deleteCategoryResponse struct {
+	Status string      `json:"stat"`
+	Result interface{} `json:"result"`
+}
+
+func (r deleteCategoryResponse) responseStatus() string {
+	return r.Status
+}
+
+type getCategoryImagesResponse struct {
+	Status string `json:"stat"`
+	Result struct {
+		Paging struct {
+			Page       int    `json:"page"`
+			PerPage    int    `json:"per_page"`
+			Count      int    `json:"count"`
+			TotalCount string `json:"total_count"`
+		} `json:"paging"`
+		Images []struct {
+			ID           int    `json:"id"`
+			File         string `json:"file"`
+			Name         string `json:"name"`
+			DateCreation string `json:"date_creation"`
+		} `json:"images"`
+	} `json:"result"`
+}
+
+func (r getCategoryImagesResponse) responseStatus() string {
+	return r.Status
+}
+
+type getImageInfoResponse struct {
+	Status string `json:"stat"`
+	Result struct {
+		ID           int         `json:"id"`
+		File         string      `json:"file"`
+		Filesize     json.Number `json:"filesize"`
+		Md5sum       string      `json:"md5sum"`
+		DateCreation string      `json:"date_creation"`
+	} `json:"result"`
+}
+
+func (r getImageInfoResponse) responseStatus() string {
+	return r.Status
+}
+
+type updateResponse struct {
+	Status  string      `json:"stat"`
+	Err     int         `json:"err"`
+	Message string      `json:"message"`
+	Result  interface{} `json:"result"`
+}
+
+func (r updateResponse) responseStatus() string {
+	return r.Status
+}
+
+type getTagListResponse struct {
+	Status string `json:"stat"`
+	Result struct {
+		Tags []struct {
+			ID   json.Number `json:"id"`
+			Name string      `json:"name"`
+		} `json:"tags"`
+	} `json:"result"`
+}
+
+func (r getTagListResponse) responseStatus() string {
+	return r.Status
+}
+
+type addTagResponse struct {
+	Status string `json:"stat"`
+	Result struct {
+		Info string      `json:"info"`
+		ID   json.Number `json:"id"`
+	} `json:"result"`
+}
+
+func (r addTagResponse) responseStatus() string {
+	return r.Status
+}
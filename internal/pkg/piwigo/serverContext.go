@@ -9,6 +9,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"git.haefelfinger.net/piwigo/PiwigoDirectoryUploader/internal/pkg/categoryKey"
 	"github.com/sirupsen/logrus"
 	"net/http"
 	"net/http/cookiejar"
@@ -21,6 +22,8 @@ import (
 type CategoryApi interface {
 	GetAllCategories() (map[string]*Category, error)
 	CreateCategory(parentId int, name string) (int, error)
+	UpdateCategorySettings(categoryId int, settings CategorySettings) error
+	DeleteCategory(categoryId int) error
 }
 
 type ImageApi interface {
@@ -28,14 +31,23 @@ type ImageApi interface {
 	ImagesExistOnPiwigo(md5sums []string) (map[string]int, error)
 	UploadImage(piwigoId int, filePath string, md5sum string, category int) (int, error)
 	DeleteImages(imageIds []int) error
+	ImagesOfCategory(categoryId int) ([]Image, error)
+	ImageFileSize(piwigoId int) (int64, error)
+	SetImageTags(piwigoId int, tags []string) error
+	SetImageCategory(piwigoId int, categoryId int) error
 }
 
 type ServerContext struct {
-	url           string
-	username      string
-	password      string
-	chunkSizeInKB int
-	cookies       *cookiejar.Jar
+	url                       string
+	username                  string
+	password                  string
+	chunkSizeInKB             int
+	cookies                   *cookiejar.Jar
+	duplicateCategoriesPolicy string
+	categoryBindings          map[string]int
+	baseCategoryId            int
+	baseCategoryKey           string
+	tagIds                    map[string]int
 }
 
 func (context *ServerContext) Initialize(baseUrl string, username string, password string) error {
@@ -55,10 +67,47 @@ func (context *ServerContext) Initialize(baseUrl string, username string, passwo
 	context.username = username
 	context.password = password
 	context.chunkSizeInKB = 512
+	context.duplicateCategoriesPolicy = DuplicateCategoriesFail
 
 	return nil
 }
 
+// Configures how sibling categories with the same name are handled. The bindings map category keys
+// to the piwigo id of the category that should be used for it.
+func (context *ServerContext) UseDuplicateCategoriesPolicy(policy string, bindings map[string]int) error {
+	if policy != DuplicateCategoriesFail && policy != DuplicateCategoriesLowestId {
+		return fmt.Errorf("unknown policy for duplicated categories: %s", policy)
+	}
+
+	context.duplicateCategoriesPolicy = policy
+	context.categoryBindings = bindings
+	return nil
+}
+
+// Uses an existing category as parent of all top level categories. The base is either the id of the category or
+// its path using slashes as separator. A path consisting only of digits has to start with a slash.
+// The categories returned by GetAllCategories are relative to the base category.
+func (context *ServerContext) UseBaseCategory(base string) error {
+	base = strings.TrimSpace(base)
+	if base == "" {
+		return errors.New("please provide the id or the path of the base category")
+	}
+
+	id, err := strconv.Atoi(base)
+	if err == nil {
+		if id <= 0 {
+			return fmt.Errorf("invalid base category id %d", id)
+		}
+		context.baseCategoryId = id
+		context.baseCategoryKey = ""
+		return nil
+	}
+
+	context.baseCategoryId = 0
+	context.baseCategoryKey = categoryKey.Join(strings.Split(strings.Trim(base, "/"), "/")...)
+	return nil
+}
+
 func (context *ServerContext) Login() error {
 	logrus.Infoln("Logging in to piwigo and getting chunk size configuration for uploads")
 	logrus.Debugf("Logging in to %s using user %s", context.url, context.username)
@@ -101,6 +150,18 @@ func (context *ServerContext) Logout() error {
 	return nil
 }
 
+// KeepAlive checks if the session is still valid and logs in again if it expired. Piwigo falls back to the guest
+// user for expired sessions, so the status is checked instead of waiting for a request to fail.
+func (context *ServerContext) KeepAlive() error {
+	status, err := context.getStatus()
+	if err == nil && status.Result.Username == context.username {
+		return nil
+	}
+
+	logrus.Infof("The session on %s expired, logging in again", context.url)
+	return context.Login()
+}
+
 func (context *ServerContext) getStatus() (*getStatusResponse, error) {
 	logrus.Debugln("Getting current login state...")
 
@@ -133,9 +194,19 @@ func (context *ServerContext) GetAllCategories() (map[string]*Category, error) {
 	logrus.Infof("Successfully got all categories")
 	categories := buildCategoryMap(&response)
 	buildCategoryKeys(categories)
-	categoryLookups := buildLookupMap(categories)
 
-	return categoryLookups, nil
+	if context.baseCategoryId > 0 || context.baseCategoryKey != "" {
+		baseId, err := findBaseCategory(categories, context.baseCategoryId, context.baseCategoryKey)
+		if err != nil {
+			return nil, err
+		}
+
+		logrus.Infof("Using category %d as base category", baseId)
+		context.baseCategoryId = baseId
+		categories = categoriesBelow(categories, baseId)
+	}
+
+	return buildLookupMap(categories, context.duplicateCategoriesPolicy, context.categoryBindings)
 }
 
 func (context *ServerContext) CreateCategory(parentId int, name string) (int, error) {
@@ -143,6 +214,14 @@ func (context *ServerContext) CreateCategory(parentId int, name string) (int, er
 	formData.Set("method", "pwg.categories.add")
 	formData.Set("name", name)
 
+	// top level categories get created below the base category if there is one
+	if parentId == 0 {
+		if context.baseCategoryId == 0 && context.baseCategoryKey != "" {
+			return 0, fmt.Errorf("the base category %s is not resolved yet. Load the categories first", context.baseCategoryKey)
+		}
+		parentId = context.baseCategoryId
+	}
+
 	// we only submit the parentid if there is one.
 	if parentId > 0 {
 		formData.Set("parent", fmt.Sprint(parentId))
@@ -159,6 +238,204 @@ func (context *ServerContext) CreateCategory(parentId int, name string) (int, er
 	return response.Result.ID, nil
 }
 
+// Deletes the category together with its sub categories. The images are kept on piwigo, as they might be linked
+// to other categories as well.
+func (context *ServerContext) DeleteCategory(categoryId int) error {
+	pwgToken, err := context.getPiwigoToken()
+	if err != nil {
+		return err
+	}
+
+	formData := url.Values{}
+	formData.Set("method", "pwg.categories.delete")
+	formData.Set("category_id", strconv.Itoa(categoryId))
+	formData.Set("photo_deletion_mode", "no_delete")
+	formData.Set("pwg_token", pwgToken)
+
+	var response deleteCategoryResponse
+	err = context.executePiwigoRequest(formData, &response)
+	if err != nil {
+		logrus.Errorln(err)
+		return err
+	}
+
+	logrus.Infof("Successfully deleted category %d", categoryId)
+	return nil
+}
+
+// Piwigo creates new categories public, so categories without a status in their settings are set back to it.
+const defaultCategoryStatus = "public"
+
+// Sends the settings of a local directory to the category. An empty description clears the one on piwigo.
+// Permissions are only added, so users and groups removed from the settings keep their access until they get
+// removed on piwigo.
+func (context *ServerContext) UpdateCategorySettings(categoryId int, settings CategorySettings) error {
+	logrus.Debugf("Updating settings of category %d", categoryId)
+
+	formData := url.Values{}
+	formData.Set("method", "pwg.categories.setInfo")
+	formData.Set("category_id", strconv.Itoa(categoryId))
+	formData.Set("comment", settings.Description)
+	if settings.Status != "" {
+		formData.Set("status", settings.Status)
+	} else {
+		formData.Set("status", defaultCategoryStatus)
+	}
+
+	var response updateResponse
+	err := context.executePiwigoRequest(formData, &response)
+	if err != nil {
+		return err
+	}
+
+	if len(settings.UserIds) > 0 || len(settings.GroupIds) > 0 {
+		err = context.addCategoryPermissions(categoryId, settings.UserIds, settings.GroupIds)
+		if err != nil {
+			return err
+		}
+	}
+
+	if settings.Rank > 0 {
+		formData = url.Values{}
+		formData.Set("method", "pwg.categories.setRank")
+		formData.Set("category_id", strconv.Itoa(categoryId))
+		formData.Set("rank", strconv.Itoa(settings.Rank))
+
+		err = context.executePiwigoRequest(formData, &response)
+		if err != nil {
+			return err
+		}
+	}
+
+	if settings.RepresentativeId > 0 {
+		formData = url.Values{}
+		formData.Set("method", "pwg.categories.setRepresentative")
+		formData.Set("category_id", strconv.Itoa(categoryId))
+		formData.Set("image_id", strconv.Itoa(settings.RepresentativeId))
+
+		err = context.executePiwigoRequest(formData, &response)
+		if err != nil {
+			return err
+		}
+	}
+
+	logrus.Infof("Successfully updated the settings of category %d", categoryId)
+	return nil
+}
+
+func (context *ServerContext) addCategoryPermissions(categoryId int, userIds []int, groupIds []int) error {
+	pwgToken, err := context.getPiwigoToken()
+	if err != nil {
+		return err
+	}
+
+	formData := url.Values{}
+	formData.Set("method", "pwg.permissions.add")
+	formData.Set("cat_id", strconv.Itoa(categoryId))
+	formData.Set("pwg_token", pwgToken)
+	for _, userId := range userIds {
+		formData.Add("user_id[]", strconv.Itoa(userId))
+	}
+	for _, groupId := range groupIds {
+		formData.Add("group_id[]", strconv.Itoa(groupId))
+	}
+
+	var response updateResponse
+	return context.executePiwigoRequest(formData, &response)
+}
+
+// Replaces the tags of the image. Tags that do not exist on piwigo get created.
+func (context *ServerContext) SetImageTags(piwigoId int, tags []string) error {
+	ids := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		id, err := context.tagId(tag)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, strconv.Itoa(id))
+	}
+
+	formData := url.Values{}
+	formData.Set("method", "pwg.images.setInfo")
+	formData.Set("image_id", strconv.Itoa(piwigoId))
+	formData.Set("tag_ids", strings.Join(ids, ","))
+	formData.Set("multiple_value_mode", "replace")
+
+	logrus.Debugf("Setting tags %s of image %d", strings.Join(tags, ","), piwigoId)
+
+	var response updateResponse
+	return context.executePiwigoRequest(formData, &response)
+}
+
+// Moves the image to the category. It gets removed from all other categories, so it is no longer shown in the
+// album it was moved from.
+func (context *ServerContext) SetImageCategory(piwigoId int, categoryId int) error {
+	formData := url.Values{}
+	formData.Set("method", "pwg.images.setInfo")
+	formData.Set("image_id", strconv.Itoa(piwigoId))
+	formData.Set("categories", strconv.Itoa(categoryId))
+	formData.Set("multiple_value_mode", "replace")
+
+	logrus.Debugf("Moving image %d to category %d", piwigoId, categoryId)
+
+	var response updateResponse
+	return context.executePiwigoRequest(formData, &response)
+}
+
+func (context *ServerContext) tagId(name string) (int, error) {
+	if context.tagIds == nil {
+		err := context.loadTags()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if id, ok := context.tagIds[strings.ToLower(name)]; ok {
+		return id, nil
+	}
+
+	formData := url.Values{}
+	formData.Set("method", "pwg.tags.add")
+	formData.Set("name", name)
+
+	var response addTagResponse
+	err := context.executePiwigoRequest(formData, &response)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := strconv.Atoi(response.Result.ID.String())
+	if err != nil {
+		return 0, err
+	}
+
+	logrus.Infof("Created tag %s with id %d", name, id)
+	context.tagIds[strings.ToLower(name)] = id
+	return id, nil
+}
+
+func (context *ServerContext) loadTags() error {
+	formData := url.Values{}
+	formData.Set("method", "pwg.tags.getAdminList")
+
+	var response getTagListResponse
+	err := context.executePiwigoRequest(formData, &response)
+	if err != nil {
+		return err
+	}
+
+	context.tagIds = make(map[string]int, len(response.Result.Tags))
+	for _, tag := range response.Result.Tags {
+		id, err := strconv.Atoi(tag.ID.String())
+		if err != nil {
+			logrus.Warnf("Could not parse the id of tag %s", tag.Name)
+			continue
+		}
+		context.tagIds[strings.ToLower(tag.Name)] = id
+	}
+	return nil
+}
+
 func (context *ServerContext) ImageCheckFile(piwigoId int, md5sum string) (int, error) {
 	formData := url.Values{}
 	formData.Set("method", "pwg.images.checkFiles")
@@ -231,6 +508,65 @@ func (context *ServerContext) imagesExistOnPiwigoBatch(md5sums []string, existRe
 	return nil
 }
 
+// Returns all images directly assigned to the given category. Images of sub categories are not included.
+func (context *ServerContext) ImagesOfCategory(categoryId int) ([]Image, error) {
+	logrus.Debugf("Loading images of category %d", categoryId)
+
+	perPage := 500
+	var images []Image
+	for page := 0; ; page++ {
+		formData := url.Values{}
+		formData.Set("method", "pwg.categories.getImages")
+		formData.Set("cat_id", strconv.Itoa(categoryId))
+		formData.Set("recursive", "false")
+		formData.Set("per_page", strconv.Itoa(perPage))
+		formData.Set("page", strconv.Itoa(page))
+
+		var response getCategoryImagesResponse
+		err := context.executePiwigoRequest(formData, &response)
+		if err != nil {
+			logrus.Errorf("Got error while loading images of category %d: %s", categoryId, err)
+			return nil, err
+		}
+
+		for _, img := range response.Result.Images {
+			images = append(images, Image{
+				Id:           img.ID,
+				Filename:     img.File,
+				DateCreation: parsePiwigoDate(img.DateCreation),
+			})
+		}
+
+		if len(response.Result.Images) < perPage {
+			break
+		}
+	}
+
+	logrus.Debugf("Found %d images in category %d", len(images), categoryId)
+	return images, nil
+}
+
+// Returns the size of the original file on the server in bytes. As piwigo only returns the size in KB,
+// the value is not exact.
+func (context *ServerContext) ImageFileSize(piwigoId int) (int64, error) {
+	formData := url.Values{}
+	formData.Set("method", "pwg.images.getInfo")
+	formData.Set("image_id", strconv.Itoa(piwigoId))
+
+	var response getImageInfoResponse
+	err := context.executePiwigoRequest(formData, &response)
+	if err != nil {
+		return 0, err
+	}
+
+	sizeInKB, err := response.Result.Filesize.Int64()
+	if err != nil {
+		return 0, err
+	}
+
+	return sizeInKB * 1024, nil
+}
+
 func (context *ServerContext) UploadImage(piwigoId int, filePath string, md5sum string, category int) (int, error) {
 	if context.chunkSizeInKB <= 0 {
 		return 0, errors.New("uploadchunk size is less or equal to zero. 512 is a recommendet value to begin with")
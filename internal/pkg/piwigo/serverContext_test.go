@@ -0,0 +1,67 @@
+/*
+ * Copyright (C) 2020 Philipp Haefelfinger (http://www.haefelfinger.ch/). All Rights Reserved.
+ * This application is licensed under GPLv2. See the LICENSE file in the root directory of the project.
+ */
+
+package piwigo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func Test_UpdateCategorySettings_sends_the_settings(t *testing.T) {
+	forms := recordPiwigoRequests(t, func(context *ServerContext) error {
+		return context.UpdateCategorySettings(5, CategorySettings{Description: "Holidays", Status: "private"})
+	})
+
+	if len(forms) != 1 {
+		t.Fatalf("Expected only the setInfo request but got %d requests", len(forms))
+	}
+	if forms[0].Get("method") != "pwg.categories.setInfo" || forms[0].Get("category_id") != "5" || forms[0].Get("comment") != "Holidays" || forms[0].Get("status") != "private" {
+		t.Errorf("Got unexpected form data %v", forms[0])
+	}
+}
+
+func Test_UpdateCategorySettings_clears_removed_settings(t *testing.T) {
+	forms := recordPiwigoRequests(t, func(context *ServerContext) error {
+		return context.UpdateCategorySettings(5, CategorySettings{})
+	})
+
+	if len(forms) != 1 {
+		t.Fatalf("Expected only the setInfo request but got %d requests", len(forms))
+	}
+	if comment, ok := forms[0]["comment"]; !ok || comment[0] != "" {
+		t.Errorf("Expected the empty description to be sent but got %v", forms[0])
+	}
+	if forms[0].Get("status") != "public" {
+		t.Errorf("Expected the default status to be sent but got %v", forms[0])
+	}
+}
+
+// Runs the call against a fake piwigo server answering every request successfully and returns the sent forms.
+func recordPiwigoRequests(t *testing.T, call func(context *ServerContext) error) []url.Values {
+	var forms []url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		forms = append(forms, r.PostForm)
+		_, _ = w.Write([]byte(`{"stat":"ok","result":true}`))
+	}))
+	defer server.Close()
+
+	context := &ServerContext{}
+	err := context.Initialize(server.URL, "user", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = call(context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return forms
+}